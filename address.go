@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// splitZone separa o IP da zona (interface) de um endereço IPv6 link-local,
+// como em "fe80::1%eth0"
+func splitZone(host string) (string, string) {
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// normalizePeerAddress valida um endereço host:porta e o coloca na forma
+// canônica, com endereços IPv6 entre colchetes ("[2001:db8::1]:9000").
+// Endereços com esquema que não são host:porta (wss://, relay://, mem://)
+// são devolvidos sem alteração.
+func normalizePeerAddress(address string) (string, error) {
+	scheme, addr := "", address
+	if i := strings.Index(address, "://"); i >= 0 {
+		scheme, addr = address[:i+3], address[i+3:]
+		if scheme != "quic://" && scheme != "tcp://" && scheme != "tor://" {
+			return address, nil
+		}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return "", fmt.Errorf("endereço IPv6 deve estar entre colchetes: [endereço]:porta")
+		}
+		return "", fmt.Errorf("endereço inválido '%s': %v", address, err)
+	}
+	if port == "" {
+		return "", fmt.Errorf("endereço '%s' sem porta", address)
+	}
+
+	ipPart, zone := splitZone(host)
+	if ip := net.ParseIP(ipPart); ip != nil {
+		host = ip.String()
+		if zone != "" {
+			host += "%" + zone
+		}
+	}
+	return scheme + net.JoinHostPort(host, port), nil
+}
+
+// peerAddressMatches diz se o endereço de um peer conectado corresponde ao
+// alvo digitado pelo usuário: o endereço completo, só o IP (com ou sem
+// colchetes) ou um trecho do endereço
+func peerAddressMatches(addr, target string) bool {
+	if peerAddressEquals(addr, target) {
+		return true
+	}
+	if net.ParseIP(strings.Trim(target, "[]")) != nil {
+		return false
+	}
+	return strings.Contains(addr, target)
+}
+
+// peerAddressEquals é a versão exata de peerAddressMatches, sem aceitar
+// trechos: o endereço completo ou o IP
+func peerAddressEquals(addr, target string) bool {
+	if addr == target {
+		return true
+	}
+	if normalized, err := normalizePeerAddress(target); err == nil && normalized == addr {
+		return true
+	}
+
+	if ip := net.ParseIP(strings.Trim(target, "[]")); ip != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return false
+		}
+		host, _ = splitZone(host)
+		return ip.Equal(net.ParseIP(host))
+	}
+	return false
+}
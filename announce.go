@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+)
+
+// Anúncios de descoberta assinados com a senha da sala:
+//
+//	MAGICIAN_DISCOVERY2 <sala> <porta> <ID> <timestamp> <hmac>
+//
+// A sala é uma etiqueta derivada da senha, que permite ignorar em silêncio
+// as outras salas; o HMAC impede que quem não conhece a senha faça os peers
+// discarem para endereços arbitrários. O HMAC inclui o canal, então um
+// anúncio capturado no broadcast não serve como TXT do mDNS (nem o contrário).
+const (
+	announcePrefix = "MAGICIAN_DISCOVERY2 "
+
+	// Diferença máxima de relógio aceita nos anúncios por broadcast
+	announceMaxAge = 30 * time.Second
+
+	// O TXT do mDNS é renovado a cada mdnsTextRefresh e fica em cache nos
+	// outros peers, então a janela é maior
+	mdnsTextRefresh = time.Minute
+	mdnsMaxAge      = 3 * time.Minute
+)
+
+// Anúncios de outra sala e cópias de um anúncio já aceito (o mesmo anúncio
+// chega por IPv4 e IPv6, ou por várias interfaces) são ignorados sem log
+var (
+	errOtherRoom = errors.New("anúncio de outra sala")
+	errReplayed  = errors.New("anúncio repetido")
+)
+
+// Último timestamp aceito de cada peer em cada canal ("canal|ID"): anúncios
+// repetidos ou mais antigos que o último aceito no mesmo canal são
+// descartados. Os canais ficam separados porque o TXT do mDNS (que traz o
+// apelido) é renovado a cada minuto e seria sempre mais antigo que o último
+// broadcast; o HMAC já impede que um anúncio sirva em outro canal.
+var (
+	announceSeen      = make(map[string]int64)
+	announceSeenMutex sync.Mutex
+)
+
+// roomKey deriva da senha a chave dos HMACs, para que a senha em si nunca
+// seja usada diretamente
+func roomKey() []byte {
+	mac := hmac.New(sha256.New, []byte(Password))
+	mac.Write([]byte("magician-discovery-key"))
+	return mac.Sum(nil)
+}
+
+// roomTag identifica a sala nos anúncios sem revelar a senha
+func roomTag() string {
+	mac := hmac.New(sha256.New, []byte(Password))
+	mac.Write([]byte("magician-room-tag"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// announceMAC autentica canal, porta, ID e timestamp com a chave da sala
+func announceMAC(channel, room, port, id string, ts int64) string {
+	mac := hmac.New(sha256.New, roomKey())
+	fmt.Fprintf(mac, "%s|%s|%s|%s|%d", channel, room, port, id, ts)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// buildAnnouncement monta o anúncio assinado deste peer
+func buildAnnouncement(port string) string {
+	room := roomTag()
+	ts := time.Now().Unix()
+	return fmt.Sprintf("%s%s %s %s %d %s", announcePrefix, room, port, localIdentity.ID, ts, announceMAC("broadcast", room, port, localIdentity.ID, ts))
+}
+
+// verifyAnnouncement valida um anúncio recebido e retorna a porta e o ID do peer
+func verifyAnnouncement(message string) (string, string, error) {
+	fields := strings.Fields(strings.TrimPrefix(message, announcePrefix))
+	if len(fields) != 5 {
+		return "", "", fmt.Errorf("anúncio malformado")
+	}
+	room, port, id, tsField, mac := fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	if room != roomTag() {
+		return "", "", errOtherRoom
+	}
+	ts, err := strconv.ParseInt(tsField, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("timestamp inválido")
+	}
+	if err := verifyAnnouncementFields("broadcast", room, port, id, ts, mac, announceMaxAge); err != nil {
+		return "", "", err
+	}
+	return port, id, nil
+}
+
+// verifyAnnouncementFields confere porta, ID, HMAC, idade e repetição
+func verifyAnnouncementFields(channel, room, port, id string, ts int64, mac string, maxAge time.Duration) error {
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("porta inválida: %s", port)
+	}
+	if !identity.ValidID(id) {
+		return fmt.Errorf("ID inválido")
+	}
+	if !hmac.Equal([]byte(mac), []byte(announceMAC(channel, room, port, id, ts))) {
+		return fmt.Errorf("HMAC inválido")
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("anúncio fora da janela de tempo (%s)", age.Round(time.Second))
+	}
+
+	key := channel + "|" + id
+	announceSeenMutex.Lock()
+	defer announceSeenMutex.Unlock()
+	if ts <= announceSeen[key] {
+		return errReplayed
+	}
+	announceSeen[key] = ts
+
+	// Entradas fora da maior janela já seriam recusadas pela idade
+	oldest := time.Now().Add(-2 * mdnsMaxAge).Unix()
+	for seen, last := range announceSeen {
+		if last < oldest {
+			delete(announceSeen, seen)
+		}
+	}
+	return nil
+}
+
+// mdnsText monta o TXT assinado anunciado via mDNS
+func mdnsText(port string) map[string]string {
+	room := roomTag()
+	ts := time.Now().Unix()
+	return map[string]string{
+		"nick": Nickname,
+		"v":    protocolVersion,
+		"id":   localIdentity.ID,
+		"room": room,
+		"ts":   strconv.FormatInt(ts, 10),
+		"mac":  announceMAC("mdns", room, port, localIdentity.ID, ts),
+	}
+}
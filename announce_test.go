@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"magician/identity"
+	"magician/mdns"
+)
+
+// resetDiscovery limpa a lista de descobertos e a janela de repetição
+func resetDiscovery(t *testing.T) {
+	t.Helper()
+	testIdentity(t)
+	announceSeenMutex.Lock()
+	announceSeen = make(map[string]int64)
+	announceSeenMutex.Unlock()
+	discoveredPeersMutex.Lock()
+	discoveredPeers, discoveryPolicy = nil, discoveryAsk
+	discoveredPeersMutex.Unlock()
+}
+
+// otherPeerID gera o ID de um peer que não é o local
+func otherPeerID(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.IDFromPublicKey(pub)
+}
+
+// mdnsEntry monta o TXT assinado que outro peer anunciaria
+func mdnsEntry(id, nick string, port int, ts int64) mdns.Entry {
+	room := roomTag()
+	return mdns.Entry{
+		Instance: "outro",
+		Port:     port,
+		Addrs:    []net.IPAddr{{IP: net.ParseIP("192.0.2.7")}},
+		Text: map[string]string{
+			"nick": nick,
+			"v":    protocolVersion,
+			"id":   id,
+			"room": room,
+			"ts":   strconv.FormatInt(ts, 10),
+			"mac":  announceMAC("mdns", room, strconv.Itoa(port), id, ts),
+		},
+	}
+}
+
+// O broadcast chega a cada poucos segundos e o TXT do mDNS tem até um
+// minuto: o TXT mais antigo ainda precisa valer para trazer o apelido
+func TestMDNSNicknameAfterBroadcast(t *testing.T) {
+	resetDiscovery(t)
+	id := otherPeerID(t)
+	now := time.Now().Unix()
+
+	room := roomTag()
+	broadcast := fmt.Sprintf("%s%s %s %s %d %s", announcePrefix, room, "9000", id, now, announceMAC("broadcast", room, "9000", id, now))
+	if _, _, err := verifyAnnouncement(broadcast); err != nil {
+		t.Fatalf("broadcast recusado: %v", err)
+	}
+	noteDiscoveredPeer("192.0.2.7:9000", id, "", "broadcast")
+
+	handleMDNSEntry(mdnsEntry(id, "Maria", 9000, now-50))
+
+	discoveredPeersMutex.Lock()
+	defer discoveredPeersMutex.Unlock()
+	if len(discoveredPeers) != 1 || discoveredPeers[0].Nickname != "Maria" {
+		t.Fatalf("apelido do mDNS não chegou: %+v", discoveredPeers)
+	}
+}
+
+// Cada canal recusa as próprias repetições, e o HMAC de um canal não vale
+// no outro
+func TestAnnouncementReplayPerChannel(t *testing.T) {
+	resetDiscovery(t)
+	id := otherPeerID(t)
+	ts := time.Now().Unix()
+	room := roomTag()
+
+	mac := announceMAC("mdns", room, "9000", id, ts)
+	if err := verifyAnnouncementFields("mdns", room, "9000", id, ts, mac, mdnsMaxAge); err != nil {
+		t.Fatalf("TXT recusado: %v", err)
+	}
+	if err := verifyAnnouncementFields("mdns", room, "9000", id, ts, mac, mdnsMaxAge); err != errReplayed {
+		t.Errorf("TXT repetido: %v", err)
+	}
+	if err := verifyAnnouncementFields("mdns", room, "9000", id, ts-10, announceMAC("mdns", room, "9000", id, ts-10), mdnsMaxAge); err != errReplayed {
+		t.Errorf("TXT mais antigo: %v", err)
+	}
+	if err := verifyAnnouncementFields("broadcast", room, "9000", id, ts, mac, announceMaxAge); err == nil {
+		t.Error("TXT do mDNS aceito como broadcast")
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limite do tamanho de um pacote de diretório (gravado em disco no receptor)
+const maxArchiveSize = 1024 * 1024 * 1024 // 1 GB
+
+// archiveEntry é um item a ser incluído no tar enviado
+type archiveEntry struct {
+	diskPath string
+	name     string // Caminho relativo dentro do pacote, sempre com '/'
+	info     os.FileInfo
+}
+
+// isGlobPattern indica se o caminho contém curingas
+func isGlobPattern(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// expandSendPaths resolve o argumento de /arquivo em uma lista de caminhos.
+// Retorna também se o envio deve ser empacotado e o nome do pacote.
+func expandSendPaths(arg string) ([]string, bool, string, error) {
+	if isGlobPattern(arg) {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, false, "", fmt.Errorf("padrão inválido: %v", err)
+		}
+		if len(matches) == 0 {
+			return nil, false, "", fmt.Errorf("nenhum arquivo corresponde a '%s'", arg)
+		}
+		name := filepath.Base(filepath.Dir(arg))
+		if name == "." || name == string(filepath.Separator) {
+			name = "arquivos"
+		}
+		return matches, true, name + ".tar", nil
+	}
+
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, false, "", fmt.Errorf("erro ao abrir arquivo: %v", err)
+	}
+	if info.IsDir() {
+		return []string{arg}, true, filepath.Base(filepath.Clean(arg)) + ".tar", nil
+	}
+	return []string{arg}, false, "", nil
+}
+
+// collectArchiveEntries percorre os caminhos e monta a lista de itens do pacote.
+// Diretórios passados diretamente têm o conteúdo incluído relativo a eles mesmos;
+// links simbólicos e arquivos especiais são ignorados.
+func collectArchiveEntries(paths []string, single bool) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	for _, root := range paths {
+		root = filepath.Clean(root)
+		prefix := filepath.Base(root)
+		// Só um diretório sozinho perde o prefixo; um arquivo sozinho (ex:
+		// um padrão com uma única correspondência) mantém o próprio nome
+		if info, err := os.Stat(root); single && err == nil && info.IsDir() {
+			prefix = ""
+		}
+
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(prefix, filepath.ToSlash(rel))
+			if name == "." || name == "" {
+				return nil
+			}
+			if info.IsDir() {
+				name += "/"
+			}
+
+			entries = append(entries, archiveEntry{diskPath: p, name: name, info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("erro ao percorrer '%s': %v", root, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// archiveHeader monta o cabeçalho tar de um item, mantendo apenas as permissões básicas
+func archiveHeader(e archiveEntry) *tar.Header {
+	hdr := &tar.Header{
+		Name:    e.name,
+		Mode:    int64(e.info.Mode().Perm()),
+		ModTime: e.info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	if e.info.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+	} else {
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.info.Size()
+	}
+	return hdr
+}
+
+// countingWriter descarta os dados e conta quantos bytes foram escritos
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// archiveSize calcula o tamanho exato do tar sem ler o conteúdo dos arquivos
+func archiveSize(entries []archiveEntry) (int64, error) {
+	counter := &countingWriter{}
+	tw := tar.NewWriter(counter)
+	for _, e := range entries {
+		hdr := archiveHeader(e)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(tw, zeroReader{}, hdr.Size); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// writeArchive gera o tar em w. Cada arquivo contribui exatamente com o tamanho
+// registrado na coleta, para que o total bata com o anunciado na oferta.
+func writeArchive(w io.Writer, entries []archiveEntry) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := archiveHeader(e)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		f, err := os.Open(e.diskPath)
+		if err != nil {
+			return fmt.Errorf("erro ao abrir '%s': %v", e.diskPath, err)
+		}
+		_, err = io.CopyN(tw, f, hdr.Size)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("'%s' mudou durante o envio: %v", e.diskPath, err)
+		}
+	}
+	return tw.Close()
+}
+
+// sendArchive empacota diretórios ou vários arquivos em um tar gerado sob demanda
+func sendArchive(paths []string, archiveName string, targetPeer string) error {
+	single := len(paths) == 1
+	entries, err := collectArchiveEntries(paths, single)
+	if err != nil {
+		return err
+	}
+
+	size, err := archiveSize(entries)
+	if err != nil {
+		return fmt.Errorf("erro ao calcular tamanho do pacote: %v", err)
+	}
+	if size > maxArchiveSize {
+		return fmt.Errorf("pacote muito grande (limite: 1GB)")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeArchive(pw, entries))
+	}()
+	defer pr.Close()
+
+	logMessage(fmt.Sprintf("Empacotando %d itens em '%s'", len(entries), archiveName))
+	return sendStream(pr, size, archiveName, true, targetPeer)
+}
+
+// createPartialArchive cria o arquivo temporário que recebe um pacote
+func createPartialArchive(id string) (*os.File, error) {
+	os.MkdirAll(receivedDir, 0755)
+	return os.OpenFile(filepath.Join(receivedDir, ".parcial-"+id+".tar"),
+		os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+}
+
+// safeArchivePath valida o nome de um item do tar e o converte em caminho
+// dentro de root. Rejeita caminhos absolutos e qualquer tentativa de sair de root.
+func safeArchivePath(root, name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", false
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(clean))
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return target, true
+}
+
+// extractArchive desempacota o tar em root aceitando apenas diretórios e arquivos regulares
+func extractArchive(r io.Reader, root string, limit int64) (int, error) {
+	tr := tar.NewReader(r)
+	files := 0
+	var written int64
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, fmt.Errorf("pacote corrompido: %v", err)
+		}
+
+		target, ok := safeArchivePath(root, hdr.Name)
+		if !ok {
+			logMessage(fmt.Sprintf("Item ignorado no pacote (caminho inseguro): %q", hdr.Name))
+			continue
+		}
+
+		// Remove bits especiais (setuid, setgid, sticky) e garante acesso ao dono
+		perm := os.FileMode(hdr.Mode).Perm() & 0755
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, perm|0700); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			written += hdr.Size
+			if written > limit {
+				return files, fmt.Errorf("pacote excede o tamanho anunciado")
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm|0600)
+			if err != nil {
+				return files, err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return files, err
+			}
+			if !hdr.ModTime.IsZero() {
+				os.Chtimes(target, time.Now(), hdr.ModTime)
+			}
+			files++
+		default:
+			logMessage(fmt.Sprintf("Item ignorado no pacote (tipo não suportado): %q", hdr.Name))
+		}
+	}
+}
+
+// extractReceivedArchive desempacota um pacote recebido em 'recebidos/<nome>/'.
+// A transferência já chega marcada como concluída e só volta a falhar se o
+// pacote não puder ser desempacotado.
+func extractReceivedArchive(t *Transfer, partial *os.File) {
+	defer os.Remove(partial.Name())
+	defer partial.Close()
+
+	dirName := strings.TrimSuffix(t.FileName, ".tar")
+	if dirName == "" {
+		dirName = t.ID
+	}
+	root := uniqueReceivedPath(dirName)
+
+	var files int
+	_, err := partial.Seek(0, io.SeekStart)
+	if err == nil {
+		err = os.MkdirAll(root, 0755)
+	}
+	if err == nil {
+		files, err = extractArchive(partial, root, t.Size)
+	}
+
+	if err != nil {
+		transfersMutex.Lock()
+		t.finish(TransferFailed, "erro ao desempacotar")
+		transfersMutex.Unlock()
+		logMessage(fmt.Sprintf("Erro ao desempacotar '%s': %v", t.FileName, err))
+		updateChatView(fmt.Sprintf("❌ Erro ao desempacotar '%s': %v", t.FileName, err))
+		return
+	}
+
+	updateChatView(fmt.Sprintf("✅ Pacote '%s' (%d arquivos) salvo em '%s'%s", t.FileName, files, root, t.compressionSummary()))
+	logMessage(fmt.Sprintf("Pacote '%s' [%s] recebido e desempacotado em %s", t.FileName, t.ID, root))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSafeArchivePath(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "pacote")
+
+	for name, want := range map[string]string{
+		"a.txt":         filepath.Join(root, "a.txt"),
+		"dir/b.txt":     filepath.Join(root, "dir", "b.txt"),
+		"dir/./c.txt":   filepath.Join(root, "dir", "c.txt"),
+		"dir\\d.txt":    filepath.Join(root, "dir", "d.txt"),
+		"dir/":          filepath.Join(root, "dir"),
+		"../fora.txt":   "",
+		"dir/../../x":   "",
+		"dir/..":        "",
+		"..\\fora.txt":  "",
+		"/etc/passwd":   "",
+		"\\etc\\passwd": "",
+		"":              "",
+		".":             "",
+		"./":            "",
+	} {
+		got, ok := safeArchivePath(root, name)
+		if ok != (want != "") || got != want {
+			t.Errorf("%q: %q %v, esperava %q", name, got, ok, want)
+		}
+	}
+}
+
+// tarOf monta um pacote com os cabeçalhos informados; itens regulares recebem
+// o próprio nome como conteúdo
+func tarOf(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Um pacote malicioso não escreve fora do diretório de destino nem cria links
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	base, _ := os.Getwd()
+	root := filepath.Join(base, "recebidos", "pacote")
+	os.MkdirAll(root, 0755)
+	outside := filepath.Join(base, "segredo.txt")
+	os.WriteFile(outside, []byte("original"), 0600)
+
+	data := tarOf(t,
+		&tar.Header{Name: "ok/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "ok/a.txt", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "../../segredo.txt", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "ok/../../../x.txt", Typeflag: tar.TypeReg},
+		&tar.Header{Name: outside, Typeflag: tar.TypeReg},
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+		&tar.Header{Name: "dirlink", Typeflag: tar.TypeSymlink, Linkname: base},
+		&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../../segredo.txt"},
+		&tar.Header{Name: "setuid.txt", Typeflag: tar.TypeReg, Mode: 04755},
+	)
+
+	files, err := extractArchive(bytes.NewReader(data), root, int64(len(data)))
+	if err != nil {
+		t.Fatalf("extractArchive: %v", err)
+	}
+	if files != 2 {
+		t.Errorf("%d arquivos extraídos, esperava 2", files)
+	}
+
+	if content, _ := os.ReadFile(outside); string(content) != "original" {
+		t.Errorf("arquivo fora do destino alterado: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(base, "x.txt")); err == nil {
+		t.Error("arquivo criado fora do destino")
+	}
+	for _, name := range []string{"link", "dirlink", "hard"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			t.Errorf("link %q criado", name)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "ok", "a.txt")); string(content) != "ok/a.txt" {
+		t.Errorf("item válido não extraído: %q", content)
+	}
+	if info, err := os.Stat(filepath.Join(root, "setuid.txt")); err != nil || info.Mode()&os.ModeSetuid != 0 {
+		t.Errorf("bits especiais mantidos: %v %v", info, err)
+	}
+}
+
+// Um item que reaparece no pacote não sobrescreve o primeiro, e o total
+// extraído não passa do anunciado
+func TestExtractArchiveLimits(t *testing.T) {
+	t.Chdir(t.TempDir())
+	root := t.TempDir()
+
+	data := tarOf(t,
+		&tar.Header{Name: "a.txt", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "a.txt", Typeflag: tar.TypeReg},
+	)
+	if _, err := extractArchive(bytes.NewReader(data), root, int64(len(data))); err == nil {
+		t.Error("item repetido sobrescreveu o anterior")
+	}
+
+	data = tarOf(t, &tar.Header{Name: "grande.txt", Typeflag: tar.TypeReg})
+	if _, err := extractArchive(bytes.NewReader(data), t.TempDir(), 1); err == nil {
+		t.Error("aceitou pacote maior que o anunciado")
+	}
+}
+
+// O pacote completo já conta como concluído enquanto é desempacotado, então
+// a limpeza periódica não o expira nem apaga o arquivo temporário
+func TestArchiveCompleteBeforeExtraction(t *testing.T) {
+	resetTransfers(t)
+	const peer = "10.0.0.1:9000"
+
+	data := tarOf(t, &tar.Header{Name: "a.txt", Typeflag: tar.TypeReg})
+	tr := incoming(peer, 1, int64(len(data)), true)
+	tr.FileName = "pacote.tar"
+	tr.TotalChunks = 1
+	tr.State = TransferInProgress
+	partial, err := createPartialArchive(tr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.partial = partial
+	if err := addTransfer(tr); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk, _ := json.Marshal(FileChunk{ID: tr.ID, Data: base64.StdEncoding.EncodeToString(data)})
+	handleFileChunk(peer, string(chunk))
+	transfersMutex.Lock()
+	state := tr.State
+	transfersMutex.Unlock()
+	if state != TransferComplete {
+		t.Fatalf("pacote recebido ficou em %s", state)
+	}
+
+	expireTransfers(time.Now().Add(transferTimeout + time.Minute))
+	transfersMutex.Lock()
+	state = tr.State
+	transfersMutex.Unlock()
+	if state != TransferComplete {
+		t.Fatalf("pacote expirou durante a extração: %s", state)
+	}
+
+	// O temporário só some quando a extração termina
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		content, err := os.ReadFile(filepath.Join(receivedDir, "pacote", "a.txt"))
+		if _, gone := os.Stat(partial.Name()); err == nil && string(content) == "a.txt" && os.IsNotExist(gone) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pacote não foi desempacotado: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
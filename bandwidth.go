@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter é um token bucket simples medido em bytes por segundo.
+// Taxa zero significa sem limite.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter cria um limitador com a taxa informada
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, last: time.Now()}
+}
+
+// SetRate altera a taxa do limitador, zerando o saldo acumulado
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// Rate retorna a taxa atual em bytes por segundo
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait bloqueia até que n bytes possam ser transmitidos. Blocos maiores que
+// o saldo deixam o bucket negativo, atrasando os próximos na mesma proporção.
+func (l *RateLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	// Permite no máximo um segundo de rajada
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Limites globais de transferência de arquivos
+var (
+	uploadLimiter   = NewRateLimiter(0)
+	downloadLimiter = NewRateLimiter(0)
+)
+
+// RateNotice informa aos peers a taxa máxima que aceitamos receber deles
+type RateNotice struct {
+	Download int64
+}
+
+// parseRate interpreta uma taxa em KB/s ("0" ou "off" removem o limite)
+func parseRate(arg string) (int64, error) {
+	arg = strings.ToLower(strings.TrimSuffix(strings.ToLower(arg), "kb/s"))
+	if arg == "off" || arg == "sem" {
+		return 0, nil
+	}
+	kb, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || kb < 0 {
+		return 0, fmt.Errorf("taxa inválida '%s' (use KB/s, 0 para ilimitado)", arg)
+	}
+	return kb * 1024, nil
+}
+
+// formatRate formata uma taxa para exibição
+func formatRate(rate int64) string {
+	if rate <= 0 {
+		return "ilimitado"
+	}
+	return formatBytes(rate) + "/s"
+}
+
+// broadcastDownloadLimit avisa todos os peers sobre nosso limite de download
+func broadcastDownloadLimit() {
+	data, err := json.Marshal(RateNotice{Download: downloadLimiter.Rate()})
+	if err != nil {
+		return
+	}
+	for _, peer := range connectedPeers() {
+		peerSend(peer, priorityControl, "[RATE]"+string(data))
+	}
+}
+
+// handleRateNotice aplica o limite de download anunciado por um peer aos nossos envios para ele
+func handleRateNotice(peer string, data string) {
+	var notice RateNotice
+	if err := json.Unmarshal([]byte(data), &notice); err != nil || notice.Download < 0 {
+		logMessage(fmt.Sprintf("Aviso de taxa inválido de %s", peer))
+		return
+	}
+	setPeerDownloadRate(peer, notice.Download)
+	logMessage(fmt.Sprintf("Peer %s limitou o download a %s", peer, formatRate(notice.Download)))
+}
+
+// setTransferRate limita uma transferência específica. Para transferências
+// recebidas, o limite é repassado ao remetente, que controla o ritmo do envio.
+func setTransferRate(prefix string, rate int64) (string, error) {
+	transfersMutex.Lock()
+	matches := matchTransfers(prefix)
+	ids := make(map[string]bool)
+	for _, t := range matches {
+		ids[t.ID] = true
+	}
+	if len(matches) == 0 {
+		transfersMutex.Unlock()
+		return "", fmt.Errorf("nenhuma transferência ativa com ID '%s'", prefix)
+	}
+	if len(ids) > 1 {
+		transfersMutex.Unlock()
+		return "", fmt.Errorf("ID '%s' é ambíguo, informe mais caracteres", prefix)
+	}
+
+	var notify []*Transfer
+	for _, t := range matches {
+		t.limiter.SetRate(rate)
+		if t.Incoming {
+			notify = append(notify, t)
+		}
+	}
+	fileName := matches[0].FileName
+	transfersMutex.Unlock()
+
+	for _, t := range notify {
+		sendFileControlRate(t.Peer, t.ID, rate)
+	}
+	return fileName, nil
+}
+
+// cmdBandwidth mostra ou altera os limites de banda
+func cmdBandwidth(args []string) string {
+	if len(args) == 0 {
+		result := "📶 Limites de banda para arquivos:\n"
+		result += fmt.Sprintf("⬆ Upload:   %s\n", formatRate(uploadLimiter.Rate()))
+		result += fmt.Sprintf("⬇ Download: %s\n", formatRate(downloadLimiter.Rate()))
+		for _, t := range snapshotTransfers(true) {
+			if rate := t.limiter.Rate(); rate > 0 {
+				result += fmt.Sprintf("[%s] '%s': %s\n", t.ID, t.FileName, formatRate(rate))
+			}
+		}
+		result += "Uso: /banda up|down <KB/s> ou /banda <id> <KB/s> (0 = ilimitado)"
+		return result
+	}
+
+	if len(args) < 2 {
+		return "Uso: /banda up|down <KB/s> ou /banda <id> <KB/s> (0 = ilimitado)"
+	}
+
+	rate, err := parseRate(args[1])
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "up", "upload", "envio":
+		uploadLimiter.SetRate(rate)
+		logMessage("Limite de upload alterado para " + formatRate(rate))
+		return fmt.Sprintf("⬆ Limite de upload: %s", formatRate(rate))
+	case "down", "download", "recebimento":
+		downloadLimiter.SetRate(rate)
+		broadcastDownloadLimit()
+		logMessage("Limite de download alterado para " + formatRate(rate))
+		return fmt.Sprintf("⬇ Limite de download: %s", formatRate(rate))
+	default:
+		fileName, err := setTransferRate(args[0], rate)
+		if err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		return fmt.Sprintf("📶 Limite de '%s': %s", fileName, formatRate(rate))
+	}
+}
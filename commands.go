@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"github.com/jroimartin/gocui"
 	"net"
-	"os"
 	"strings"
+	"time"
+
+	"magician/tor"
 )
 
 func cmdPrivateMsg(args []string) string {
@@ -22,7 +24,7 @@ func cmdPrivateMsg(args []string) string {
 
 	peersMutex.Lock()
 	for addr, conn := range Peers {
-		if strings.Contains(addr, target) {
+		if peerAddressMatches(addr, target) {
 			targetConn = conn
 			targetAddr = addr
 			break
@@ -35,7 +37,7 @@ func cmdPrivateMsg(args []string) string {
 	}
 
 	// Envia mensagem privada
-	fmt.Fprintf(targetConn, "[PRIVADO] %s\n", message)
+	writeChatLine(targetAddr, "[PRIVADO] "+message)
 
 	// Loga a mensagem privada
 	logMessage(fmt.Sprintf("[PRIVADO para %s] %s", targetAddr, message))
@@ -63,6 +65,54 @@ func cmdListUsers(args []string) string {
 	return result
 }
 
+// cmdListTransfers lista as transferências ativas de entrada e saída
+func cmdListTransfers(args []string) string {
+	list := snapshotTransfers(true)
+	if len(list) == 0 {
+		return "Nenhuma transferência ativa."
+	}
+
+	now := time.Now()
+	result := fmt.Sprintf("📦 Transferências ativas (%d):\n", len(list))
+	for _, t := range list {
+		direction := "⬆ para"
+		if t.Incoming {
+			direction = "⬇ de"
+		}
+
+		status := t.State.String()
+		if t.Paused {
+			status = "pausada"
+		}
+
+		result += fmt.Sprintf("[%s] %s %s '%s' — %.1f%% de %s, %s/s, ETA %s (%s)\n",
+			t.ID, direction, t.Peer, t.FileName, t.Progress()*100, formatBytes(t.Size),
+			formatBytes(int64(t.Speed(now))), formatETA(t.ETA(now)), status)
+	}
+	return result
+}
+
+// cmdControlTransfer cancela, pausa ou retoma uma transferência pelo ID
+func cmdControlTransfer(args []string, action string) string {
+	if len(args) < 1 {
+		return fmt.Sprintf("Uso: /%s <id>", action)
+	}
+
+	fileName, err := controlTransfers(args[0], action)
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+
+	switch action {
+	case controlCancel:
+		return fmt.Sprintf("🚫 Transferência '%s' cancelada", fileName)
+	case controlPause:
+		return fmt.Sprintf("⏸️  Transferência '%s' pausada", fileName)
+	default:
+		return fmt.Sprintf("▶️  Transferência '%s' retomada", fileName)
+	}
+}
+
 func sendMessage(g *gocui.Gui, v *gocui.View) error {
 	if v != nil {
 		message := strings.TrimSpace(v.Buffer())
@@ -91,11 +141,9 @@ func sendMessage(g *gocui.Gui, v *gocui.View) error {
 			formattedMsg := fmt.Sprintf("[%s] %s", Nickname, message)
 
 			// Envia mensagem para todos os peers
-			peersMutex.Lock()
-			for _, conn := range Peers {
-				fmt.Fprintf(conn, "%s\n", formattedMsg)
+			for _, addr := range connectedPeers() {
+				writeChatLine(addr, formattedMsg)
 			}
-			peersMutex.Unlock()
 
 			updateChatView(fmt.Sprintf("[Você] %s", message))
 		}
@@ -107,25 +155,55 @@ func sendMessage(g *gocui.Gui, v *gocui.View) error {
 }
 
 func cmdInfo(args []string) string {
-	onion, err := os.ReadFile("/var/lib/tor/magician_chat/hostname")
-	if err != nil {
-		return "Erro ao ler endereço .onion"
-	}
-
-	ip := getLocalIP()
+	status := currentTorStatus()
 
 	resp := "🔍 Informações do Peer:\n"
-	resp += fmt.Sprintf("🧅 Endereço .onion: %s", strings.TrimSpace(string(onion)))
-	resp += fmt.Sprintf("\n📡 IP local: %s", ip)
-	resp += "\n🛡️  Modo: Tor ativado"
+	resp += fmt.Sprintf("🆔 ID: %s", localIdentity.ID)
+	// No modo somente Tor nenhum IP é consultado nem mostrado
+	if !torOnly {
+		resp += fmt.Sprintf("\n📡 IP local: %s", getLocalIP())
+		resp += fmt.Sprintf("\n🌍 Endereço externo: %s", portMappingInfo())
+	}
+	resp += fmt.Sprintf("\n🌐 DHT: %s", dhtInfo())
 
+	if status.Checked.IsZero() {
+		resp += "\n🧅 Tor: verificando..."
+		return resp
+	}
+	resp += fmt.Sprintf("\n🧅 Tor: %s", status.Summary())
+	resp += fmt.Sprintf("\n   Circuitos: %s", status.CircuitSummary())
+	if onion := localOnionAddress(); onion != "" {
+		resp += fmt.Sprintf("\n🧅 Endereço .onion: %s", onion)
+	} else {
+		resp += "\n🧅 Endereço .onion: nenhum serviço configurado"
+	}
+	resp += fmt.Sprintf("\n   (verificado às %s)", status.Checked.Format("15:04:05"))
+
+	switch {
+	case torOnly && status.Ready():
+		resp += "\n🛡️  Modo: somente Tor (sem conexões diretas, descoberta ou UPnP)"
+	case torOnly:
+		resp += "\n🛡️  Modo: somente Tor, mas o Tor não está pronto (nenhuma conexão será feita)"
+	case tor.Default().Config().Everything && status.Ready():
+		resp += "\n🛡️  Modo: todas as conexões passam pelo Tor"
+	case tor.Default().Config().Everything:
+		resp += "\n🛡️  Modo: tudo pelo Tor, mas o Tor não está pronto (conexões vão falhar)"
+	case status.Ready():
+		resp += "\n🛡️  Modo: peers .onion são conectados via Tor"
+	default:
+		resp += "\n🛡️  Modo: sem Tor, só conexões diretas"
+	}
 	return resp
 }
 
 func getLocalIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
-		return "desconhecido"
+		// Redes só IPv6
+		conn, err = net.Dial("udp", "[2001:4860:4860::8888]:80")
+		if err != nil {
+			return "desconhecido"
+		}
 	}
 	defer conn.Close()
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
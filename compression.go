@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Funcionalidade anunciada no HELLO por peers que aceitam gzip
+	featureGzip = "gzip"
+
+	// Mensagens de chat acima deste tamanho são comprimidas
+	chatCompressThreshold = 512
+
+	// Limite de uma linha descomprimida, evitando "bombas" de compressão
+	maxExpandedLine = 256 * 1024
+)
+
+// Extensões de formatos que já são comprimidos e não ganham nada com gzip
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".7z": true, ".rar": true, ".xz": true,
+	".bz2": true, ".zst": true, ".lz4": true, ".jpg": true, ".jpeg": true, ".png": true,
+	".gif": true, ".webp": true, ".mp3": true, ".mp4": true, ".mkv": true, ".avi": true,
+	".mov": true, ".ogg": true, ".flac": true, ".webm": true, ".pdf": true, ".docx": true,
+	".xlsx": true, ".pptx": true, ".jar": true, ".apk": true,
+}
+
+// Assinaturas (magic bytes) de formatos comprimidos
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{'P', 'K', 0x03, 0x04},             // zip e derivados
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{'R', 'a', 'r', '!'},               // rar
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'B', 'Z', 'h'},                    // bzip2
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xff, 0xd8, 0xff},                 // jpeg
+	{0x89, 'P', 'N', 'G'},              // png
+	{'G', 'I', 'F', '8'},               // gif
+	{'%', 'P', 'D', 'F'},               // pdf
+	{'O', 'g', 'g', 'S'},               // ogg
+	{'f', 'L', 'a', 'C'},               // flac
+	{'I', 'D', '3'},                    // mp3 com ID3
+	{0x1a, 0x45, 0xdf, 0xa3},           // matroska/webm
+}
+
+// isCompressedFormat detecta arquivos já comprimidos pela extensão ou pelos primeiros bytes
+func isCompressedFormat(fileName string, head []byte) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		return true
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	// Contêineres MP4/MOV têm "ftyp" a partir do byte 4
+	return len(head) >= 8 && string(head[4:8]) == "ftyp"
+}
+
+// gzipBytes comprime um bloco de dados
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes descomprime um bloco recusando resultados maiores que limit
+func gunzipBytes(data []byte, limit int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("dados descomprimidos excedem o limite")
+	}
+	return out, nil
+}
+
+// compressChunk comprime um chunk de arquivo. Retorna false quando a
+// compressão não reduz o tamanho e o chunk deve seguir sem compressão.
+func compressChunk(data []byte) ([]byte, bool) {
+	compressed, err := gzipBytes(data)
+	if err != nil || len(compressed) >= len(data) {
+		return data, false
+	}
+	return compressed, true
+}
+
+// compressionRatio formata o tamanho transmitido em relação ao original
+func compressionRatio(wire, original int64) string {
+	if original <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%% do original", float64(wire)/float64(original)*100)
+}
+
+// writeChatLine envia uma linha de chat, comprimindo mensagens longas
+// quando o peer anunciou suporte a gzip
+func writeChatLine(peer string, line string) {
+	if len(line) > chatCompressThreshold && peerSupports(peer, featureGzip) {
+		if compressed, err := gzipBytes([]byte(line)); err == nil {
+			// Só vale a pena se o resultado em base64 ainda for menor que o texto
+			encoded := base64.StdEncoding.EncodeToString(compressed)
+			if len(encoded)+4 < len(line) {
+				peerSend(peer, priorityChat, "[GZ]"+encoded)
+				return
+			}
+		}
+	}
+	peerSend(peer, priorityChat, line)
+}
+
+// expandLine desfaz a compressão de uma linha recebida com o prefixo [GZ]
+func expandLine(message string) (string, error) {
+	if !strings.HasPrefix(message, "[GZ]") {
+		return message, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(message, "[GZ]"))
+	if err != nil {
+		return "", fmt.Errorf("linha comprimida inválida: %v", err)
+	}
+	expanded, err := gunzipBytes(data, maxExpandedLine)
+	if err != nil {
+		return "", fmt.Errorf("linha comprimida inválida: %v", err)
+	}
+
+	line := strings.TrimSpace(string(expanded))
+	if strings.HasPrefix(line, "[GZ]") {
+		return "", fmt.Errorf("compressão aninhada não é permitida")
+	}
+	return line, nil
+}
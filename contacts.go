@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"magician/identity"
+)
+
+// Arquivo da agenda de contatos, no diretório de execução
+const contactsFile = "contatos.json"
+
+// Contact é um peer salvo na agenda
+type Contact struct {
+	Name        string   `json:"nome"`
+	Addresses   []string `json:"enderecos"`             // IP:porta, .onion:porta, quic://, relay://...
+	Fingerprint string   `json:"fingerprint,omitempty"` // ID da identidade do peer
+	Favorite    bool     `json:"favorito,omitempty"`    // Conectado automaticamente ao iniciar
+}
+
+// Agenda carregada de contactsFile, por nome em minúsculas
+var (
+	contacts      = make(map[string]*Contact)
+	contactsMutex sync.Mutex
+)
+
+// loadContacts lê a agenda do disco, se existir
+func loadContacts() error {
+	data, err := os.ReadFile(contactsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler agenda: %v", err)
+	}
+
+	var list []*Contact
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("erro ao ler agenda: %v", err)
+	}
+
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+	for _, c := range list {
+		contacts[strings.ToLower(c.Name)] = c
+	}
+	return nil
+}
+
+// saveContacts grava a agenda. Deve ser chamada com contactsMutex travado.
+func saveContacts() error {
+	data, err := json.MarshalIndent(sortedContacts(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(contactsFile, data, 0600); err != nil {
+		return fmt.Errorf("erro ao salvar agenda: %v", err)
+	}
+	return nil
+}
+
+// sortedContacts retorna os contatos em ordem alfabética.
+// Deve ser chamada com contactsMutex travado.
+func sortedContacts() []*Contact {
+	list := make([]*Contact, 0, len(contacts))
+	for _, c := range contacts {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// findContact procura um contato pelo nome
+func findContact(name string) (Contact, bool) {
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+
+	c, ok := contacts[strings.ToLower(name)]
+	if !ok {
+		return Contact{}, false
+	}
+	return *c, true
+}
+
+// contactConnected diz se algum endereço (ou o fingerprint) do contato está conectado
+func contactConnected(c Contact) bool {
+	if c.Fingerprint != "" {
+		if _, ok := findPeerByID(c.Fingerprint); ok {
+			return true
+		}
+	}
+
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	for _, addr := range c.Addresses {
+		if normalized, err := normalizePeerAddress(addr); err == nil {
+			if _, ok := Peers[normalized]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialContact tenta os endereços do contato em ordem até um conectar; se
+// nenhum responder, procura os endereços atuais do fingerprint na DHT
+func dialContact(c Contact) {
+	for _, addr := range c.Addresses {
+		if contactConnected(c) {
+			return
+		}
+		connectToPeer(addr)
+	}
+	if contactConnected(c) {
+		return
+	}
+	if c.Fingerprint != "" {
+		connectByID(c.Fingerprint)
+		return
+	}
+	updateChatView(fmt.Sprintf("Sistema: Não foi possível conectar a %s", c.Name))
+}
+
+// dialFavorites conecta aos contatos favoritos ao iniciar
+func dialFavorites() {
+	contactsMutex.Lock()
+	var favorites []Contact
+	for _, c := range sortedContacts() {
+		if c.Favorite {
+			favorites = append(favorites, *c)
+		}
+	}
+	contactsMutex.Unlock()
+
+	for _, c := range favorites {
+		go dialContact(c)
+	}
+}
+
+// checkContactFingerprint confere o ID provado no handshake com o fingerprint
+// do contato dono do endereço. Retorna false (e avisa) se não conferir: a
+// conexão deve ser descartada.
+func checkContactFingerprint(remote, id string) bool {
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+
+	for _, c := range contacts {
+		if c.Fingerprint == "" || c.Fingerprint == id {
+			continue
+		}
+		for _, addr := range c.Addresses {
+			if normalized, err := normalizePeerAddress(addr); err == nil && normalized == remote {
+				updateChatView(fmt.Sprintf("⚠️ %s provou o ID %s, mas o contato '%s' tem o fingerprint %s. Conexão recusada.",
+					remote, identity.Short(id), c.Name, identity.Short(c.Fingerprint)))
+				logMessage(fmt.Sprintf("Fingerprint divergente para %s em %s: %s", c.Name, remote, id))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cmdContacts lista a agenda
+func cmdContacts(args []string) string {
+	contactsMutex.Lock()
+	list := make([]Contact, 0, len(contacts))
+	for _, c := range sortedContacts() {
+		list = append(list, *c)
+	}
+	contactsMutex.Unlock()
+
+	if len(list) == 0 {
+		return "Agenda vazia. Use /adicionar <nome> <endereço> para salvar um contato."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📒 Contatos:\n")
+	for _, c := range list {
+		star := "  "
+		if c.Favorite {
+			star = "⭐"
+		}
+		status := ""
+		if contactConnected(c) {
+			status = " ✅ conectado"
+		}
+		fmt.Fprintf(&sb, "%s %s — %s%s\n", star, c.Name, strings.Join(c.Addresses, ", "), status)
+		if c.Fingerprint != "" {
+			fmt.Fprintf(&sb, "     🔑 %s\n", c.Fingerprint)
+		}
+	}
+	sb.WriteString("Use /conectar <nome> para conectar.")
+	return sb.String()
+}
+
+// cmdAddContact cria um contato ou acrescenta endereços a um existente
+func cmdAddContact(args []string) string {
+	usage := "Uso: /adicionar <nome> <endereço|peer> [endereço...] [id=<fingerprint>] [favorito]"
+	if len(args) < 2 {
+		return usage
+	}
+
+	name := args[0]
+	var addresses []string
+	fingerprint := ""
+	favorite := false
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "favorito" || arg == "*":
+			favorite = true
+		case strings.HasPrefix(arg, "id="):
+			fingerprint = strings.ToLower(strings.TrimPrefix(arg, "id="))
+			if !identity.ValidID(fingerprint) {
+				return fmt.Sprintf("❌ Fingerprint inválido: %s", fingerprint)
+			}
+		default:
+			addr, id, err := resolveContactAddress(arg)
+			if err != nil {
+				return fmt.Sprintf("❌ %v", err)
+			}
+			addresses = append(addresses, addr)
+			if fingerprint == "" {
+				fingerprint = id
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return usage
+	}
+
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+
+	key := strings.ToLower(name)
+	c, exists := contacts[key]
+	if !exists {
+		c = &Contact{Name: name}
+		contacts[key] = c
+	}
+	for _, addr := range addresses {
+		duplicate := false
+		for _, existing := range c.Addresses {
+			if existing == addr {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			c.Addresses = append(c.Addresses, addr)
+		}
+	}
+	if fingerprint != "" {
+		c.Fingerprint = fingerprint
+	}
+	if favorite {
+		c.Favorite = true
+	}
+
+	if err := saveContacts(); err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	if exists {
+		return fmt.Sprintf("📒 Contato '%s' atualizado", c.Name)
+	}
+	return fmt.Sprintf("📒 Contato '%s' adicionado", c.Name)
+}
+
+// resolveContactAddress valida o endereço; se ele for de um peer conectado,
+// o ID anunciado no HELLO vira o fingerprint do contato
+func resolveContactAddress(arg string) (string, string, error) {
+	addr, err := normalizePeerAddress(arg)
+	if err != nil {
+		return "", "", err
+	}
+
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	if info, ok := peerInfos[addr]; ok {
+		return addr, info.ID, nil
+	}
+	return addr, "", nil
+}
+
+// cmdRemoveContact apaga um contato da agenda
+func cmdRemoveContact(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /remover <nome>"
+	}
+
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+
+	key := strings.ToLower(args[0])
+	c, ok := contacts[key]
+	if !ok {
+		return fmt.Sprintf("❌ Contato '%s' não encontrado", args[0])
+	}
+	delete(contacts, key)
+
+	if err := saveContacts(); err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	return fmt.Sprintf("📒 Contato '%s' removido", c.Name)
+}
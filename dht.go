@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/dht"
+	"magician/identity"
+	"magician/tor"
+	"magician/transport"
+)
+
+const (
+	// Funcionalidade anunciada no HELLO por peers que atendem a DHT
+	featureDHT = "dht"
+
+	// Primeira linha de uma conexão de consulta à DHT, no lugar do AUTH:
+	// os registros são públicos e assinados, então não exigem a senha
+	dhtHandshake = "DHT"
+
+	dhtCallTimeout   = 10 * time.Second
+	dhtCheckInterval = time.Minute
+	dhtRepublish     = 30 * time.Minute
+	maxDHTMessage    = 64 * 1024
+)
+
+// Nó local da DHT e o último registro publicado
+var (
+	dhtNode         *dht.Node
+	dhtPublished    time.Time
+	dhtPublishedFor string // Endereços do último registro, para republicar se mudarem
+	dhtMutex        sync.Mutex
+)
+
+// peerNetwork leva as mensagens da DHT pelos transportes dos peers: cada
+// consulta é uma conexão curta que começa com "DHT" em vez de "AUTH"
+type peerNetwork struct{}
+
+// Call implementa dht.Network
+func (peerNetwork) Call(addr string, req *dht.Message) (*dht.Message, error) {
+	// Endereços vêm de outros nós: só host:porta, nunca outro transporte
+	if !dht.ValidAddr(addr) {
+		return nil, fmt.Errorf("endereço de nó inválido: %q", addr)
+	}
+	conn, err := transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dhtCallTimeout))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n%s\n", dhtHandshake, data); err != nil {
+		return nil, err
+	}
+
+	line, err := readLimitedLine(bufio.NewReader(io.LimitReader(conn, maxDHTMessage)))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler resposta da DHT: %v", err)
+	}
+	var resp dht.Message
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return nil, fmt.Errorf("resposta da DHT inválida: %v", err)
+	}
+	return &resp, nil
+}
+
+// readLimitedLine lê uma linha de um leitor já limitado
+func readLimitedLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// serveDHT atende uma consulta recebida no listener de peers
+func serveDHT(conn net.Conn, reader *bufio.Reader) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dhtCallTimeout))
+
+	if dhtNode == nil {
+		return
+	}
+
+	line, err := readLimitedLine(bufio.NewReader(io.LimitReader(reader, maxDHTMessage)))
+	if err != nil {
+		return
+	}
+	var req dht.Message
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		return
+	}
+	if !dhtSenderMatches(req.From.Addr, conn.RemoteAddr()) {
+		// Responde, mas não deixa o remetente indicar o endereço de outro host
+		req.From.Addr = ""
+	}
+
+	data, err := json.Marshal(dhtNode.Handle(&req))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(conn, "%s\n", data)
+}
+
+// dhtSenderMatches diz se o endereço informado pelo remetente é do host de
+// onde a consulta veio. Endereços .onion não podem ser conferidos e valem
+// como estão.
+func dhtSenderMatches(claimed string, observed net.Addr) bool {
+	host, _, err := net.SplitHostPort(claimed)
+	if err != nil {
+		return false
+	}
+	if tor.IsOnion(claimed) {
+		return true
+	}
+	observedHost, _, err := net.SplitHostPort(observed.String())
+	if err != nil {
+		return false
+	}
+	claimedIP, observedIP := net.ParseIP(host), net.ParseIP(observedHost)
+	return claimedIP != nil && observedIP != nil && claimedIP.Equal(observedIP)
+}
+
+// firstDHTAddr escolhe o primeiro endereço que serve para a tabela da DHT
+func firstDHTAddr(addrs []string) string {
+	for _, addr := range addrs {
+		if dht.ValidAddr(addr) {
+			return addr
+		}
+	}
+	return ""
+}
+
+// initDHT cria o nó local e começa a publicar o registro deste peer
+func initDHT(port string) {
+	addrs := dhtAddresses(port)
+	self := dht.Contact{ID: localIdentity.ID, Addr: firstDHTAddr(addrs)}
+
+	node, err := dht.NewNode(self, peerNetwork{})
+	if err != nil {
+		log.Printf("Erro ao iniciar DHT: %v", err)
+		return
+	}
+
+	// Contatos da agenda com fingerprint servem de ponto de entrada
+	contactsMutex.Lock()
+	for _, c := range contacts {
+		if addr := firstDHTAddr(c.Addresses); c.Fingerprint != "" && addr != "" {
+			node.AddContact(dht.Contact{ID: c.Fingerprint, Addr: addr})
+		}
+	}
+	contactsMutex.Unlock()
+
+	dhtNode = node
+	go dhtPublishLoop(port)
+}
+
+// dhtAddresses lista os endereços públicos onde este peer pode ser
+// encontrado, dos mais aos menos prováveis de funcionar de fora da rede local
+func dhtAddresses(port string) []string {
+	// No modo somente Tor, publicar um IP revelaria o peer
+	if torOnly {
+		if onion := localOnionAddress(); onion != "" {
+			return []string{net.JoinHostPort(onion, port)}
+		}
+		return nil
+	}
+
+	var public []string
+
+	// Em NAT duplo o roteador informa um endereço externo que ainda é privado
+	portMappingMutex.Lock()
+	if host, _, err := net.SplitHostPort(externalAddress); err == nil && publicIP(net.ParseIP(host)) {
+		public = append(public, externalAddress)
+	}
+	portMappingMutex.Unlock()
+
+	if onion := localOnionAddress(); onion != "" {
+		public = append(public, net.JoinHostPort(onion, port))
+	}
+
+	peersMutex.Lock()
+	for hostPort := range relayListens {
+		public = append(public, fmt.Sprintf("relay://%s/%s", hostPort, localIdentity.ID))
+	}
+	peersMutex.Unlock()
+
+	ifaces, _ := net.InterfaceAddrs()
+	for _, addr := range ifaces {
+		if ipnet, ok := addr.(*net.IPNet); ok && publicIP(ipnet.IP) {
+			public = append(public, net.JoinHostPort(ipnet.IP.String(), port))
+		}
+	}
+
+	if len(public) > 16 {
+		public = public[:16]
+	}
+	return public
+}
+
+// cgnatNet é o espaço compartilhado das operadoras (RFC 6598)
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP indica se um IP é alcançável de fora da rede local. Endereços
+// privados, de loopback, link-local e de CGNAT não são publicados na DHT:
+// não servem para quem está em outra rede e revelariam a rede interna.
+func publicIP(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatNet.Contains(ip)
+}
+
+// readOnionHostname lê o endereço .onion do serviço configurado no torrc,
+// que o Tor grava em HiddenServiceDir/hostname
+func readOnionHostname() string {
+	data, err := os.ReadFile(filepath.Join(torServiceDir(), "hostname"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// dhtPublishLoop entra na DHT assim que houver algum nó conhecido e mantém
+// o registro deste peer atualizado
+func dhtPublishLoop(port string) {
+	bootstrapped := false
+	ticker := time.NewTicker(dhtCheckInterval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if dhtNode.Size() == 0 {
+			continue
+		}
+		if !bootstrapped {
+			if err := dhtNode.Bootstrap(nil); err != nil {
+				continue
+			}
+			bootstrapped = true
+			logMessage(fmt.Sprintf("DHT: %d nós conhecidos", dhtNode.Size()))
+		}
+
+		addrs := dhtAddresses(port)
+		if len(addrs) == 0 {
+			continue
+		}
+		dhtNode.SetAddr(firstDHTAddr(addrs))
+
+		dhtMutex.Lock()
+		current := strings.Join(addrs, " ")
+		due := time.Since(dhtPublished) > dhtRepublish || current != dhtPublishedFor
+		dhtMutex.Unlock()
+		if !due {
+			continue
+		}
+
+		rec, err := dht.NewRecord(localIdentity.Private, addrs)
+		if err != nil {
+			log.Printf("Erro ao criar registro da DHT: %v", err)
+			continue
+		}
+		stored, err := dhtNode.Publish(rec)
+		if err != nil {
+			log.Printf("Erro ao publicar na DHT: %v", err)
+			continue
+		}
+
+		dhtMutex.Lock()
+		dhtPublished = time.Now()
+		dhtPublishedFor = current
+		dhtMutex.Unlock()
+		logMessage(fmt.Sprintf("DHT: registro publicado em %d nós", stored))
+	}
+}
+
+// addDHTContact aprende um nó da DHT a partir de um peer conectado
+func addDHTContact(remote string, info *PeerInfo) {
+	if dhtNode == nil || !info.Features[featureDHT] || !identity.ValidID(info.ID) {
+		return
+	}
+
+	addr := remote
+	if !peerDialed(remote) {
+		// Conexão recebida: a porta de origem é efêmera, mas o peer escuta
+		// em TCP na mesma porta anunciada para o QUIC
+		host, _, err := net.SplitHostPort(remote)
+		if err != nil || info.UDPPort == 0 {
+			return
+		}
+		addr = net.JoinHostPort(host, fmt.Sprint(info.UDPPort))
+	}
+	dhtNode.AddContact(dht.Contact{ID: info.ID, Addr: addr})
+}
+
+// connectByID procura o ID na DHT e tenta os endereços publicados
+func connectByID(id string) {
+	if _, ok := findPeerByID(id); ok {
+		updateChatView(fmt.Sprintf("Sistema: Já conectado a %s", identity.Short(id)))
+		return
+	}
+	if dhtNode == nil {
+		updateChatView("Sistema: DHT indisponível")
+		return
+	}
+
+	updateChatView(fmt.Sprintf("🔎 Procurando %s na DHT...", identity.Short(id)))
+	rec, err := dhtNode.Lookup(id)
+	if err != nil {
+		updateChatView(fmt.Sprintf("❌ %s: %v", identity.Short(id), err))
+		return
+	}
+	updateChatView(fmt.Sprintf("🔎 %s publicou: %s", identity.Short(id), strings.Join(rec.Addresses, ", ")))
+
+	for _, addr := range rec.Addresses {
+		connectToPeer(addr)
+		if normalized, err := normalizePeerAddress(addr); err == nil && peerConnected(normalized) {
+			return
+		}
+	}
+	updateChatView(fmt.Sprintf("❌ Nenhum endereço de %s respondeu", identity.Short(id)))
+}
+
+// peerConnected diz se há um peer conectado no endereço
+func peerConnected(addr string) bool {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	_, ok := Peers[addr]
+	return ok
+}
+
+// dhtInfo resume o estado da DHT para o /info
+func dhtInfo() string {
+	if dhtNode == nil {
+		return "desativada"
+	}
+	dhtMutex.Lock()
+	published := dhtPublished
+	dhtMutex.Unlock()
+
+	info := fmt.Sprintf("%d nós, %d registros guardados", dhtNode.Size(), dhtNode.StoredRecords())
+	if !published.IsZero() {
+		info += ", publicado às " + published.Format("15:04")
+	}
+	return info
+}
@@ -0,0 +1,68 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemoryNetwork liga nós no mesmo processo, para testes com muitos nós.
+// As mensagens passam por JSON, como na rede real.
+type MemoryNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool
+}
+
+// NewMemoryNetwork cria uma rede vazia
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		nodes: make(map[string]*Node),
+		down:  make(map[string]bool),
+	}
+}
+
+// Attach registra o nó no endereço informado
+func (m *MemoryNetwork) Attach(addr string, n *Node) {
+	m.mu.Lock()
+	m.nodes[addr] = n
+	m.mu.Unlock()
+}
+
+// SetDown simula um nó que parou de responder
+func (m *MemoryNetwork) SetDown(addr string, down bool) {
+	m.mu.Lock()
+	m.down[addr] = down
+	m.mu.Unlock()
+}
+
+// Call implementa Network
+func (m *MemoryNetwork) Call(addr string, req *Message) (*Message, error) {
+	m.mu.Lock()
+	n, ok := m.nodes[addr]
+	down := m.down[addr]
+	m.mu.Unlock()
+	if !ok || down {
+		return nil, fmt.Errorf("nó inalcançável: %s", addr)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var decoded Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	resp := n.Handle(&decoded)
+	data, err = json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	var out Message
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,397 @@
+// Package dht implementa uma DHT no estilo Kademlia para encontrar peers
+// pelo ID da identidade em qualquer lugar da internet. Cada nó publica um
+// registro assinado com seus endereços atuais nos K nós de ID mais próximo
+// do seu, e qualquer outro nó o encontra com uma busca iterativa.
+//
+// O pacote não abre conexões: as mensagens passam por uma Network, que no
+// programa usa os transportes dos peers e nos testes pode ser uma
+// MemoryNetwork com muitos nós no mesmo processo.
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"magician/identity"
+)
+
+// Tipos de mensagem
+const (
+	MsgPing      = "PING"
+	MsgFindNode  = "FIND_NODE"
+	MsgFindValue = "FIND_VALUE"
+	MsgStore     = "STORE"
+
+	MsgPong  = "PONG"
+	MsgNodes = "NODES"
+	MsgValue = "VALUE"
+	MsgOK    = "OK"
+	MsgError = "ERRO"
+)
+
+const (
+	// alpha é o número de consultas paralelas em cada rodada da busca
+	alpha = 3
+
+	// Limite de registros guardados de outros nós
+	maxStoredRecords = 10000
+
+	// Remetentes desconhecidos sendo verificados ao mesmo tempo; os demais
+	// são ignorados até uma próxima requisição
+	maxVerifying = 8
+)
+
+// ErrNotFound indica que nenhum nó tinha o registro procurado
+var ErrNotFound = errors.New("registro não encontrado na DHT")
+
+// Message é uma requisição ou resposta da DHT
+type Message struct {
+	Type   string
+	From   Contact   // Remetente; Addr vazio se ele não aceita conexões
+	Target string    `json:",omitempty"` // ID procurado
+	Record *Record   `json:",omitempty"`
+	Nodes  []Contact `json:",omitempty"`
+	Error  string    `json:",omitempty"`
+}
+
+// Network entrega uma requisição ao nó no endereço informado e retorna a resposta
+type Network interface {
+	Call(addr string, req *Message) (*Message, error)
+}
+
+// Node é um participante da DHT
+type Node struct {
+	self    Contact
+	network Network
+	table   *table
+
+	mu        sync.Mutex
+	records   map[string]*Record
+	verifying map[string]bool // Contatos aguardando a verificação por PING
+}
+
+// NewNode cria um nó. self.Addr pode ser vazio para um nó que só consulta.
+func NewNode(self Contact, network Network) (*Node, error) {
+	if !identity.ValidID(self.ID) {
+		return nil, fmt.Errorf("ID de nó inválido: %s", self.ID)
+	}
+	return &Node{
+		self:      self,
+		network:   network,
+		table:     newTable(self.ID),
+		records:   make(map[string]*Record),
+		verifying: make(map[string]bool),
+	}, nil
+}
+
+// Self retorna o contato deste nó
+func (n *Node) Self() Contact {
+	return n.self
+}
+
+// SetAddr muda o endereço anunciado deste nó
+func (n *Node) SetAddr(addr string) {
+	n.mu.Lock()
+	n.self.Addr = addr
+	n.mu.Unlock()
+}
+
+func (n *Node) selfContact() Contact {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.self
+}
+
+// AddContact insere um nó conhecido na tabela de roteamento
+func (n *Node) AddContact(c Contact) {
+	n.table.add(c)
+}
+
+// Size retorna o número de nós na tabela de roteamento
+func (n *Node) Size() int {
+	return n.table.size()
+}
+
+// StoredRecords retorna quantos registros de outros nós este nó guarda
+func (n *Node) StoredRecords() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.records)
+}
+
+// Handle responde a uma requisição recebida pela rede
+func (n *Node) Handle(req *Message) *Message {
+	n.learn(req.From)
+
+	resp := &Message{From: n.selfContact()}
+	switch req.Type {
+	case MsgPing:
+		resp.Type = MsgPong
+	case MsgFindNode:
+		resp.Type = MsgNodes
+		resp.Nodes = n.closestExcept(req.Target, req.From.ID)
+	case MsgFindValue:
+		// Os nós vão junto com o registro, para que a busca continue até
+		// quem tenha uma versão mais nova
+		resp.Type = MsgNodes
+		resp.Nodes = n.closestExcept(req.Target, req.From.ID)
+		if rec := n.localRecord(req.Target); rec != nil {
+			resp.Type = MsgValue
+			resp.Record = rec
+		}
+	case MsgStore:
+		if err := n.storeRecord(req.Record); err != nil {
+			resp.Type = MsgError
+			resp.Error = err.Error()
+		} else {
+			resp.Type = MsgOK
+		}
+	default:
+		resp.Type = MsgError
+		resp.Error = "mensagem desconhecida: " + req.Type
+	}
+	return resp
+}
+
+// learn considera o remetente de uma requisição para a tabela. Como ninguém
+// precisa se autenticar para consultar a DHT, o endereço informado só entra
+// depois que um PING para ele é respondido pelo mesmo ID.
+func (n *Node) learn(c Contact) {
+	if c.ID == n.self.ID || !identity.ValidID(c.ID) || !ValidAddr(c.Addr) {
+		return
+	}
+	if n.table.has(c) {
+		n.table.add(c) // Só renova
+		return
+	}
+
+	key := c.ID + "@" + c.Addr
+	n.mu.Lock()
+	if n.verifying[key] || len(n.verifying) >= maxVerifying {
+		n.mu.Unlock()
+		return
+	}
+	n.verifying[key] = true
+	n.mu.Unlock()
+
+	go func() {
+		// call só adiciona o contato se ele responder com o ID esperado
+		n.call(c, &Message{Type: MsgPing})
+		n.mu.Lock()
+		delete(n.verifying, key)
+		n.mu.Unlock()
+	}()
+}
+
+// closestExcept retorna os K contatos mais próximos, sem o próprio solicitante
+func (n *Node) closestExcept(target, except string) []Contact {
+	if !identity.ValidID(target) {
+		return nil
+	}
+	var result []Contact
+	for _, c := range n.table.closest(target, K+1) {
+		if c.ID != except && len(result) < K {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// localRecord retorna o registro guardado, se ainda válido
+func (n *Node) localRecord(id string) *Record {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rec, ok := n.records[id]
+	if !ok {
+		return nil
+	}
+	if rec.Expired(time.Now()) {
+		delete(n.records, id)
+		return nil
+	}
+	return rec
+}
+
+// storeRecord valida e guarda um registro, mantendo sempre o mais novo
+func (n *Node) storeRecord(rec *Record) error {
+	if rec == nil {
+		return errors.New("registro ausente")
+	}
+	now := time.Now()
+	if err := rec.Verify(now); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if old, ok := n.records[rec.ID]; ok && old.Issued >= rec.Issued {
+		return nil
+	}
+	if len(n.records) >= maxStoredRecords {
+		for id, old := range n.records {
+			if old.Expired(now) {
+				delete(n.records, id)
+			}
+		}
+		if len(n.records) >= maxStoredRecords {
+			return errors.New("armazenamento cheio")
+		}
+	}
+	n.records[rec.ID] = rec
+	return nil
+}
+
+// call envia uma requisição e atualiza a tabela conforme o nó responde ou não
+func (n *Node) call(c Contact, req *Message) (*Message, error) {
+	if !ValidAddr(c.Addr) {
+		n.table.remove(c.ID)
+		return nil, fmt.Errorf("endereço de nó inválido: %q", c.Addr)
+	}
+	req.From = n.selfContact()
+	resp, err := n.network.Call(c.Addr, req)
+	if err != nil {
+		n.table.remove(c.ID)
+		return nil, err
+	}
+	// O ID informado pelo nó precisa bater com o que esperávamos
+	if resp.From.ID != c.ID {
+		n.table.remove(c.ID)
+		return nil, fmt.Errorf("nó em %s respondeu com outro ID", c.Addr)
+	}
+	n.table.add(Contact{ID: c.ID, Addr: c.Addr})
+	return resp, nil
+}
+
+// Bootstrap entra na rede a partir de nós conhecidos, procurando o próprio ID
+// para preencher a tabela de roteamento
+func (n *Node) Bootstrap(seeds []Contact) error {
+	for _, c := range seeds {
+		n.table.add(c)
+	}
+	if n.table.size() == 0 {
+		return errors.New("nenhum nó conhecido para entrar na DHT")
+	}
+	n.iterate(n.self.ID, false)
+	return nil
+}
+
+// Publish guarda o registro nos K nós mais próximos do seu ID
+func (n *Node) Publish(rec *Record) (int, error) {
+	if err := rec.Verify(time.Now()); err != nil {
+		return 0, err
+	}
+
+	_, closest := n.iterate(rec.ID, false)
+	stored := 0
+	for _, c := range closest {
+		resp, err := n.call(c, &Message{Type: MsgStore, Record: rec})
+		if err == nil && resp.Type == MsgOK {
+			stored++
+		}
+	}
+	if stored == 0 {
+		return 0, errors.New("nenhum nó aceitou o registro")
+	}
+	return stored, nil
+}
+
+// Lookup procura o registro de um ID. Nós que perderam uma republicação
+// ainda guardam registros antigos, então a busca consulta todos os mais
+// próximos e fica com o emitido por último, inclusive contra a cópia local.
+func (n *Node) Lookup(id string) (*Record, error) {
+	if !identity.ValidID(id) {
+		return nil, fmt.Errorf("ID inválido: %s", id)
+	}
+
+	rec, _ := n.iterate(id, true)
+	if local := n.localRecord(id); local != nil && (rec == nil || local.Issued > rec.Issued) {
+		rec = local
+	}
+	if rec == nil {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// iterate é a busca iterativa do Kademlia: consulta os nós mais próximos do
+// alvo, aprende nós ainda mais próximos com as respostas e repete até não
+// haver progresso. Com findValue, devolve também o registro válido mais novo
+// entre os recebidos.
+func (n *Node) iterate(target string, findValue bool) (*Record, []Contact) {
+	var newest *Record
+	shortlist := n.table.closest(target, K)
+	seen := map[string]bool{n.self.ID: true}
+	for _, c := range shortlist {
+		seen[c.ID] = true
+	}
+	queried := make(map[string]bool)
+	failed := make(map[string]bool)
+
+	type result struct {
+		contact Contact
+		resp    *Message
+		err     error
+	}
+
+	for {
+		var batch []Contact
+		for _, c := range shortlist {
+			if !queried[c.ID] && len(batch) < alpha {
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		results := make(chan result, len(batch))
+		for _, c := range batch {
+			queried[c.ID] = true
+			go func(c Contact) {
+				req := &Message{Type: MsgFindNode, Target: target}
+				if findValue {
+					req.Type = MsgFindValue
+				}
+				resp, err := n.call(c, req)
+				results <- result{c, resp, err}
+			}(c)
+		}
+
+		for range batch {
+			r := <-results
+			if r.err != nil {
+				failed[r.contact.ID] = true
+				continue
+			}
+			if findValue && r.resp.Type == MsgValue && r.resp.Record != nil &&
+				r.resp.Record.ID == target && r.resp.Record.Verify(time.Now()) == nil &&
+				(newest == nil || r.resp.Record.Issued > newest.Issued) {
+				newest = r.resp.Record
+			}
+			for _, c := range r.resp.Nodes {
+				if !seen[c.ID] && identity.ValidID(c.ID) && ValidAddr(c.Addr) {
+					seen[c.ID] = true
+					shortlist = append(shortlist, c)
+				}
+			}
+		}
+
+		// Mantém só os K mais próximos que ainda respondem
+		var alive []Contact
+		for _, c := range shortlist {
+			if !failed[c.ID] {
+				alive = append(alive, c)
+			}
+		}
+		sortByDistance(target, alive)
+		if len(alive) > K {
+			alive = alive[:K]
+		}
+		shortlist = alive
+	}
+	return newest, shortlist
+}
@@ -0,0 +1,316 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"magician/identity"
+)
+
+// testNode é um nó da rede de teste e a chave da sua identidade
+type testNode struct {
+	*Node
+	key ed25519.PrivateKey
+}
+
+// newTestNetwork cria count nós na MemoryNetwork, todos entrando na rede
+// pelo primeiro
+func newTestNetwork(t *testing.T, count int) (*MemoryNetwork, []*testNode) {
+	t.Helper()
+	network := NewMemoryNetwork()
+	nodes := make([]*testNode, count)
+	for i := range nodes {
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := fmt.Sprintf("10.0.%d.%d:4000", i/250, i%250+1)
+		n, err := NewNode(Contact{ID: identity.IDFromPublicKey(pub), Addr: addr}, network)
+		if err != nil {
+			t.Fatalf("NewNode: %v", err)
+		}
+		network.Attach(addr, n)
+		nodes[i] = &testNode{Node: n, key: key}
+	}
+
+	// Os remetentes entram nas tabelas depois do PING de volta, então cada
+	// nó espera as verificações antes do próximo entrar
+	seed := []Contact{nodes[0].Self()}
+	for _, n := range nodes[1:] {
+		if err := n.Bootstrap(seed); err != nil {
+			t.Fatalf("Bootstrap: %v", err)
+		}
+		waitVerified(t, nodes)
+	}
+	// Uma segunda rodada completa as tabelas dos que entraram primeiro
+	for _, n := range nodes {
+		n.Bootstrap(nil)
+		waitVerified(t, nodes)
+	}
+	return network, nodes
+}
+
+// waitVerified espera terminarem as verificações de remetentes pendentes
+func waitVerified(t *testing.T, nodes []*testNode) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, n := range nodes {
+		for {
+			n.mu.Lock()
+			pending := len(n.verifying)
+			n.mu.Unlock()
+			if pending == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("verificações de remetentes não terminaram")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// signRecord assina um registro com data de emissão escolhida
+func signRecord(key ed25519.PrivateKey, addresses []string, issued time.Time) *Record {
+	pub := key.Public().(ed25519.PublicKey)
+	r := &Record{
+		ID:        identity.IDFromPublicKey(pub),
+		PublicKey: pub,
+		Addresses: addresses,
+		Issued:    issued.Unix(),
+	}
+	r.Signature = ed25519.Sign(key, r.signedData())
+	return r
+}
+
+func TestPublishAndLookup(t *testing.T) {
+	_, nodes := newTestNetwork(t, 50)
+
+	owner := nodes[17]
+	rec, err := NewRecord(owner.key, []string{"198.51.100.4:9000", "exemplo.onion:9000", "relay://relay.exemplo:9443/" + owner.Self().ID})
+	if err != nil {
+		t.Fatalf("NewRecord: %v", err)
+	}
+	stored, err := owner.Publish(rec)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if stored < K/2 {
+		t.Errorf("registro guardado em só %d nós", stored)
+	}
+
+	for i, n := range nodes {
+		if n == owner {
+			continue
+		}
+		got, err := n.Lookup(owner.Self().ID)
+		if err != nil {
+			t.Fatalf("nó %d: Lookup: %v", i, err)
+		}
+		if got.ID != rec.ID || len(got.Addresses) != 3 || got.Addresses[0] != "198.51.100.4:9000" {
+			t.Fatalf("nó %d: registro inesperado: %+v", i, got)
+		}
+	}
+}
+
+func TestLookupNotFound(t *testing.T) {
+	_, nodes := newTestNetwork(t, 30)
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := nodes[3].Lookup(identity.IDFromPublicKey(pub)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("esperava ErrNotFound, veio %v", err)
+	}
+	if _, err := nodes[3].Lookup("não-é-um-id"); err == nil {
+		t.Error("Lookup aceitou ID inválido")
+	}
+}
+
+func TestNewestRecordWins(t *testing.T) {
+	_, nodes := newTestNetwork(t, 40)
+	owner := nodes[9]
+	now := time.Now()
+
+	old := signRecord(owner.key, []string{"198.51.100.1:9000"}, now.Add(-time.Minute))
+	current := signRecord(owner.key, []string{"198.51.100.2:9000"}, now)
+
+	if _, err := owner.Publish(old); err != nil {
+		t.Fatalf("Publish antigo: %v", err)
+	}
+	if _, err := owner.Publish(current); err != nil {
+		t.Fatalf("Publish novo: %v", err)
+	}
+	// Republicar o antigo (um replay, por exemplo) não desfaz a troca
+	owner.Publish(old)
+
+	for _, i := range []int{0, 13, 27, 39} {
+		got, err := nodes[i].Lookup(owner.Self().ID)
+		if err != nil {
+			t.Fatalf("nó %d: Lookup: %v", i, err)
+		}
+		if got.Addresses[0] != "198.51.100.2:9000" {
+			t.Errorf("nó %d recebeu o registro antigo: %v", i, got.Addresses)
+		}
+	}
+}
+
+// Só um dos nós mais próximos recebeu a republicação; os demais ainda
+// guardam o registro antigo, e a busca precisa achar o novo mesmo assim
+func TestLookupPrefersNewestAmongStaleRecords(t *testing.T) {
+	_, nodes := newTestNetwork(t, 40)
+	owner := nodes[5]
+	now := time.Now()
+
+	old := signRecord(owner.key, []string{"198.51.100.1:9000"}, now.Add(-time.Minute))
+	current := signRecord(owner.key, []string{"198.51.100.2:9000"}, now)
+	if _, err := owner.Publish(old); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	byAddr := make(map[string]*testNode)
+	var contacts []Contact
+	for _, n := range nodes {
+		byAddr[n.Self().Addr] = n
+		contacts = append(contacts, n.Self())
+	}
+	sortByDistance(owner.Self().ID, contacts)
+	holder := byAddr[contacts[K/2].Addr]
+	if err := holder.storeRecord(current); err != nil {
+		t.Fatalf("storeRecord: %v", err)
+	}
+
+	// Inclui o mais próximo, que tem a cópia antiga guardada localmente
+	for _, c := range []Contact{contacts[0], contacts[1], contacts[len(contacts)-1]} {
+		n := byAddr[c.Addr]
+		if n == holder {
+			continue
+		}
+		got, err := n.Lookup(owner.Self().ID)
+		if err != nil {
+			t.Fatalf("%s: Lookup: %v", c.Addr, err)
+		}
+		if got.Issued != current.Issued {
+			t.Errorf("%s recebeu o registro antigo: %v", c.Addr, got.Addresses)
+		}
+	}
+}
+
+func TestExpiredRecordRejected(t *testing.T) {
+	_, nodes := newTestNetwork(t, 30)
+	owner := nodes[4]
+
+	expired := signRecord(owner.key, []string{"198.51.100.3:9000"}, time.Now().Add(-RecordTTL-time.Minute))
+	if _, err := owner.Publish(expired); err == nil {
+		t.Fatal("Publish aceitou registro expirado")
+	}
+
+	// Mesmo enviado direto, nenhum nó guarda o registro
+	resp := nodes[7].Handle(&Message{Type: MsgStore, From: owner.Self(), Record: expired})
+	if resp.Type != MsgError {
+		t.Errorf("STORE de registro expirado respondeu %s", resp.Type)
+	}
+	if nodes[7].StoredRecords() != 0 {
+		t.Error("registro expirado foi guardado")
+	}
+
+	future := signRecord(owner.key, []string{"198.51.100.3:9000"}, time.Now().Add(time.Hour))
+	if resp := nodes[7].Handle(&Message{Type: MsgStore, From: owner.Self(), Record: future}); resp.Type != MsgError {
+		t.Errorf("STORE de registro do futuro respondeu %s", resp.Type)
+	}
+}
+
+func TestForgedRecordRejected(t *testing.T) {
+	_, nodes := newTestNetwork(t, 30)
+	victim, attacker := nodes[5], nodes[6]
+
+	// Endereços trocados depois da assinatura
+	altered := signRecord(victim.key, []string{"198.51.100.5:9000"}, time.Now())
+	altered.Addresses = []string{"203.0.113.66:9000"}
+
+	// Registro com o ID da vítima, assinado pela chave do atacante
+	stolen := signRecord(attacker.key, []string{"203.0.113.66:9000"}, time.Now())
+	stolen.ID = victim.Self().ID
+
+	for name, rec := range map[string]*Record{"alterado": altered, "ID alheio": stolen} {
+		if resp := nodes[8].Handle(&Message{Type: MsgStore, From: attacker.Self(), Record: rec}); resp.Type != MsgError {
+			t.Errorf("%s: STORE respondeu %s", name, resp.Type)
+		}
+	}
+	if nodes[8].StoredRecords() != 0 {
+		t.Error("registro forjado foi guardado")
+	}
+
+	// Nós que respondem com o registro forjado são ignorados na busca
+	searcher := nodes[21]
+	for _, n := range nodes {
+		if n != searcher {
+			n.mu.Lock()
+			n.records[victim.Self().ID] = altered
+			n.mu.Unlock()
+		}
+	}
+	if rec, err := searcher.Lookup(victim.Self().ID); err == nil {
+		t.Errorf("busca aceitou registro forjado: %v", rec.Addresses)
+	}
+}
+
+// Remetentes só entram na tabela depois de responder a um PING no endereço
+// informado, e nunca com endereços que não sejam host:porta
+func TestUnverifiedSenderNotAdded(t *testing.T) {
+	network, nodes := newTestNetwork(t, 10)
+	target := nodes[2]
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	ghost := Contact{ID: identity.IDFromPublicKey(pub), Addr: "192.0.2.10:4000"}
+	target.Handle(&Message{Type: MsgPing, From: ghost})
+
+	for _, addr := range []string{"relay://192.0.2.1:9443/x", "mem://sala", "ws://192.0.2.1:80/", "192.0.2.1", "senha@192.0.2.1:9443"} {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		target.Handle(&Message{Type: MsgPing, From: Contact{ID: identity.IDFromPublicKey(pub), Addr: addr}})
+	}
+
+	// Um nó de verdade responde ao PING e entra
+	pub, _, _ = ed25519.GenerateKey(rand.Reader)
+	honest, err := NewNode(Contact{ID: identity.IDFromPublicKey(pub), Addr: "10.9.0.1:4000"}, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.Attach("10.9.0.1:4000", honest)
+	target.Handle(&Message{Type: MsgPing, From: honest.Self()})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !target.table.has(honest.Self()) {
+		if time.Now().After(deadline) {
+			t.Fatal("nó verificado não entrou na tabela")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, c := range target.table.closest(ghost.ID, 1000) {
+		if c.ID == ghost.ID {
+			t.Errorf("remetente sem resposta entrou na tabela: %v", c)
+		}
+		if !ValidAddr(c.Addr) {
+			t.Errorf("endereço inválido na tabela: %v", c)
+		}
+	}
+}
+
+func TestValidAddr(t *testing.T) {
+	valid := []string{"192.0.2.1:9000", "[2001:db8::1]:9000", "exemplo.onion:9000", "host.exemplo:1"}
+	invalid := []string{"", "192.0.2.1", "192.0.2.1:0", "192.0.2.1:70000", ":9000",
+		"relay://192.0.2.1:9443/abc", "quic://192.0.2.1:9000", "wss://h:443/ws", "mem://x", "a@192.0.2.1:9000"}
+	for _, addr := range valid {
+		if !ValidAddr(addr) {
+			t.Errorf("%q deveria ser válido", addr)
+		}
+	}
+	for _, addr := range invalid {
+		if ValidAddr(addr) {
+			t.Errorf("%q deveria ser inválido", addr)
+		}
+	}
+}
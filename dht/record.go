@@ -0,0 +1,98 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"magician/identity"
+)
+
+const (
+	// RecordTTL é por quanto tempo um registro vale depois de emitido
+	RecordTTL = 2 * time.Hour
+
+	// Tolerância para relógios adiantados
+	maxClockSkew = 5 * time.Minute
+
+	maxAddresses  = 16
+	maxAddressLen = 256
+)
+
+// Record associa o ID de um peer aos endereços onde ele pode ser encontrado.
+// É assinado pela identidade do peer, então nenhum outro nó consegue forjar
+// ou alterar os endereços.
+type Record struct {
+	ID        string
+	PublicKey ed25519.PublicKey
+	Addresses []string // IP:porta, .onion:porta, quic://, relay://...
+	Issued    int64    // Unix; registros mais novos substituem os antigos
+	Signature []byte
+}
+
+// NewRecord cria e assina um registro com os endereços atuais
+func NewRecord(key ed25519.PrivateKey, addresses []string) (*Record, error) {
+	pub, ok := key.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("chave privada inválida")
+	}
+	r := &Record{
+		ID:        identity.IDFromPublicKey(pub),
+		PublicKey: pub,
+		Addresses: addresses,
+		Issued:    time.Now().Unix(),
+	}
+	if err := r.checkAddresses(); err != nil {
+		return nil, err
+	}
+	r.Signature = ed25519.Sign(key, r.signedData())
+	return r, nil
+}
+
+// signedData é o conteúdo coberto pela assinatura
+func (r *Record) signedData() []byte {
+	return []byte(fmt.Sprintf("magician-dht-v1\n%s\n%d\n%s", r.ID, r.Issued, strings.Join(r.Addresses, "\n")))
+}
+
+func (r *Record) checkAddresses() error {
+	if len(r.Addresses) == 0 || len(r.Addresses) > maxAddresses {
+		return fmt.Errorf("número de endereços inválido: %d", len(r.Addresses))
+	}
+	for _, addr := range r.Addresses {
+		if addr == "" || len(addr) > maxAddressLen || strings.ContainsAny(addr, "\n\r ") {
+			return fmt.Errorf("endereço inválido no registro: %q", addr)
+		}
+	}
+	return nil
+}
+
+// Verify confere a assinatura, o vínculo entre chave e ID e a validade
+func (r *Record) Verify(now time.Time) error {
+	if len(r.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("chave pública inválida")
+	}
+	if identity.IDFromPublicKey(r.PublicKey) != r.ID {
+		return errors.New("ID não corresponde à chave pública")
+	}
+	if err := r.checkAddresses(); err != nil {
+		return err
+	}
+	issued := time.Unix(r.Issued, 0)
+	if issued.After(now.Add(maxClockSkew)) {
+		return errors.New("registro emitido no futuro")
+	}
+	if now.Sub(issued) > RecordTTL {
+		return errors.New("registro expirado")
+	}
+	if !ed25519.Verify(r.PublicKey, r.signedData(), r.Signature) {
+		return errors.New("assinatura inválida")
+	}
+	return nil
+}
+
+// Expired informa se o registro já passou da validade
+func (r *Record) Expired(now time.Time) bool {
+	return now.Sub(time.Unix(r.Issued, 0)) > RecordTTL
+}
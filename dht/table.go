@@ -0,0 +1,175 @@
+package dht
+
+import (
+	"encoding/hex"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"magician/identity"
+)
+
+// K é o tamanho de cada bucket e de cada resposta de FIND_NODE
+const K = 8
+
+// idBits é o tamanho do espaço de IDs (os IDs de identidade têm 160 bits)
+const idBits = 160
+
+// Contact é um nó da DHT e o endereço onde ele atende as consultas
+type Contact struct {
+	ID   string
+	Addr string
+}
+
+// ValidAddr diz se o endereço pode ser de um nó da DHT: só host:porta
+// (incluindo .onion:porta). Endereços com esquema (relay://, ws://, mem://)
+// não são aceitos, para que um nó não faça os outros discarem para
+// transportes ou destinos arbitrários.
+func ValidAddr(addr string) bool {
+	if len(addr) > maxAddressLen || strings.ContainsAny(addr, "/@ \r\n") {
+		return false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
+
+// distance é o XOR entre dois IDs
+func distance(a, b string) []byte {
+	x, _ := hex.DecodeString(a)
+	y, _ := hex.DecodeString(b)
+	d := make([]byte, len(x))
+	for i := range x {
+		if i < len(y) {
+			d[i] = x[i] ^ y[i]
+		}
+	}
+	return d
+}
+
+// closer diz se a está mais perto do alvo que b
+func closer(target, a, b string) bool {
+	da, db := distance(target, a), distance(target, b)
+	for i := range da {
+		if da[i] != db[i] {
+			return da[i] < db[i]
+		}
+	}
+	return false
+}
+
+// bucketIndex retorna o índice do bucket: o número de bits iniciais em comum
+func bucketIndex(self, id string) int {
+	d := distance(self, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return idBits - 1
+}
+
+// sortByDistance ordena os contatos pela distância até o alvo
+func sortByDistance(target string, contacts []Contact) {
+	sort.Slice(contacts, func(i, j int) bool {
+		return closer(target, contacts[i].ID, contacts[j].ID)
+	})
+}
+
+// table é a tabela de roteamento: um bucket de até K contatos por prefixo comum
+type table struct {
+	self string
+
+	mu      sync.Mutex
+	buckets [idBits][]Contact
+}
+
+func newTable(self string) *table {
+	return &table{self: self}
+}
+
+// add insere ou renova um contato. Contatos antigos e ativos são mantidos
+// quando o bucket está cheio, como no Kademlia original.
+func (t *table) add(c Contact) {
+	if c.ID == t.self || !ValidAddr(c.Addr) || !identity.ValidID(c.ID) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := bucketIndex(t.self, c.ID)
+	bucket := t.buckets[i]
+	for j, existing := range bucket {
+		if existing.ID == c.ID {
+			// Move para o fim (visto mais recentemente)
+			bucket = append(bucket[:j], bucket[j+1:]...)
+			t.buckets[i] = append(bucket, c)
+			return
+		}
+	}
+	if len(bucket) < K {
+		t.buckets[i] = append(bucket, c)
+	}
+}
+
+// has diz se o contato, com o mesmo endereço, já está na tabela
+func (t *table) has(c Contact) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, existing := range t.buckets[bucketIndex(t.self, c.ID)] {
+		if existing == c {
+			return true
+		}
+	}
+	return false
+}
+
+// remove tira um contato que não respondeu
+func (t *table) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := bucketIndex(t.self, id)
+	for j, c := range t.buckets[i] {
+		if c.ID == id {
+			t.buckets[i] = append(t.buckets[i][:j], t.buckets[i][j+1:]...)
+			return
+		}
+	}
+}
+
+// closest retorna os n contatos mais próximos do alvo
+func (t *table) closest(target string, n int) []Contact {
+	t.mu.Lock()
+	var all []Contact
+	for _, bucket := range t.buckets {
+		all = append(all, bucket...)
+	}
+	t.mu.Unlock()
+
+	sortByDistance(target, all)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// size retorna o número de contatos conhecidos
+func (t *table) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"198.51.100.7":  true,
+		"2001:db8::1":   true,
+		"10.1.2.3":      false,
+		"172.16.0.1":    false,
+		"192.168.1.10":  false,
+		"100.64.0.1":    false,
+		"100.127.255.1": false,
+		"100.128.0.1":   true,
+		"127.0.0.1":     false,
+		"169.254.1.1":   false,
+		"::1":           false,
+		"fe80::1":       false,
+		"fd00::1":       false,
+		"0.0.0.0":       false,
+		"224.0.0.1":     false,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("%s: %v, esperava %v", addr, got, want)
+		}
+	}
+	if publicIP(nil) {
+		t.Error("IP ausente considerado público")
+	}
+}
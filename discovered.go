@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+)
+
+// Políticas para peers descobertos na rede local
+const (
+	discoveryAuto = "auto"      // Conecta sozinho, como nas versões anteriores
+	discoveryAsk  = "perguntar" // Avisa no chat e espera /conectar <n>
+	discoveryNone = "nunca"     // Só registra na lista
+
+	// Entradas sem anúncio há mais tempo que isso saem da lista
+	discoveredExpiry = 10 * time.Minute
+)
+
+// discoveredPeer é um peer anunciado na rede local
+type discoveredPeer struct {
+	Number    int // Usado em /conectar <n>; não muda enquanto a entrada existir
+	ID        string
+	Nickname  string
+	Addr      string
+	Source    string // "mDNS" ou "broadcast"
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Peers descobertos, na ordem em que apareceram. Cada entrada recebe um
+// número próprio, que não é reaproveitado quando outras expiram.
+var (
+	discoveredPeers      []*discoveredPeer
+	discoveredNext       = 1
+	discoveryPolicy      = discoveryAsk
+	discoveredPeersMutex sync.Mutex
+)
+
+// pruneDiscovered remove as entradas expiradas. Deve ser chamada com
+// discoveredPeersMutex travado.
+func pruneDiscovered(now time.Time) {
+	active := discoveredPeers[:0]
+	for _, p := range discoveredPeers {
+		if now.Sub(p.LastSeen) <= discoveredExpiry {
+			active = append(active, p)
+		}
+	}
+	for i := len(active); i < len(discoveredPeers); i++ {
+		discoveredPeers[i] = nil
+	}
+	discoveredPeers = active
+}
+
+// noteDiscoveredPeer atualiza a lista com um anúncio válido e aplica a política
+func noteDiscoveredPeer(addr, id, nick, source string) {
+	now := time.Now()
+
+	discoveredPeersMutex.Lock()
+	pruneDiscovered(now)
+	var entry *discoveredPeer
+	for _, p := range discoveredPeers {
+		if p.ID == id {
+			entry = p
+			break
+		}
+	}
+
+	isNew := entry == nil
+	if entry == nil {
+		entry = &discoveredPeer{Number: discoveredNext, ID: id, FirstSeen: now}
+		discoveredNext++
+		discoveredPeers = append(discoveredPeers, entry)
+	}
+	number := entry.Number
+	entry.Addr = addr
+	entry.Source = source
+	entry.LastSeen = now
+	if nick != "" {
+		entry.Nickname = nick
+	}
+	policy := discoveryPolicy
+	discoveredPeersMutex.Unlock()
+
+	if discoveredConnected(entry) {
+		return
+	}
+
+	switch policy {
+	case discoveryAuto:
+		log.Printf("Descoberto novo peer: %s", addr)
+		updateChatView(fmt.Sprintf("Sistema: Descoberto novo peer: %s", addr))
+		go connectToPeer(addr)
+	case discoveryAsk:
+		if isNew {
+			updateChatView(fmt.Sprintf("🧭 Peer descoberto: %s (%s) — use /conectar %d", discoveredName(entry), addr, number))
+		}
+	}
+}
+
+// discoveredConnected diz se o peer descoberto já está conectado
+func discoveredConnected(p *discoveredPeer) bool {
+	if _, ok := findPeerByID(p.ID); ok {
+		return true
+	}
+	peersMutex.Lock()
+	_, ok := Peers[p.Addr]
+	peersMutex.Unlock()
+	return ok
+}
+
+// discoveredName usa o nickname anunciado ou, na falta dele, o ID curto
+func discoveredName(p *discoveredPeer) string {
+	if p.Nickname != "" {
+		return p.Nickname
+	}
+	return identity.Short(p.ID)
+}
+
+// discoveredAddr retorna o endereço da entrada de número n
+func discoveredAddr(n int) (string, error) {
+	discoveredPeersMutex.Lock()
+	defer discoveredPeersMutex.Unlock()
+
+	pruneDiscovered(time.Now())
+	for _, p := range discoveredPeers {
+		if p.Number == n {
+			return p.Addr, nil
+		}
+	}
+	return "", fmt.Errorf("não há peer descoberto número %d (veja /descobertos)", n)
+}
+
+// cmdDiscovered lista os peers descobertos ou muda a política
+func cmdDiscovered(args []string) string {
+	if len(args) > 0 {
+		if args[0] != "politica" && args[0] != "política" {
+			return "Uso: /descobertos [politica auto|perguntar|nunca]"
+		}
+		if len(args) < 2 {
+			return fmt.Sprintf("Política de descoberta: %s", discoveryPolicy)
+		}
+		switch args[1] {
+		case discoveryAuto, discoveryAsk, discoveryNone:
+			discoveredPeersMutex.Lock()
+			discoveryPolicy = args[1]
+			discoveredPeersMutex.Unlock()
+			return fmt.Sprintf("🧭 Política de descoberta: %s", args[1])
+		default:
+			return "❌ Política inválida. Use auto, perguntar ou nunca."
+		}
+	}
+
+	now := time.Now()
+	discoveredPeersMutex.Lock()
+	pruneDiscovered(now)
+	list := make([]discoveredPeer, len(discoveredPeers))
+	for i, p := range discoveredPeers {
+		list[i] = *p
+	}
+	policy := discoveryPolicy
+	discoveredPeersMutex.Unlock()
+
+	if len(list) == 0 {
+		return fmt.Sprintf("Nenhum peer descoberto (política: %s).", policy)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🧭 Peers descobertos (política: %s):\n", policy)
+	for i := range list {
+		p := &list[i]
+		status := ""
+		if discoveredConnected(p) {
+			status = " ✅ conectado"
+		}
+		fmt.Fprintf(&sb, "%2d. %s — %s (%s, visto há %s)%s\n",
+			p.Number, discoveredName(p), p.Addr, p.Source, now.Sub(p.LastSeen).Round(time.Second), status)
+	}
+	sb.WriteString("Use /conectar <n> para conectar.")
+	return sb.String()
+}
@@ -4,11 +4,40 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"time"
+
+	"magician/identity"
+	"magician/mdns"
+)
+
+const (
+	discoveryPort = 9999 // Porta fixa para descoberta
+
+	// Grupo multicast IPv6 de escopo de enlace usado nos anúncios
+	// (ff02::114 é reservado pela IANA para experimentos privados)
+	discoveryGroup6 = "ff02::114"
+
+	// Tipo de serviço DNS-SD anunciado via mDNS
+	mdnsServiceType = "_magician._tcp"
+	mdnsInterval    = 10 * time.Second
+
+	// Versão do protocolo entre peers, anunciada no TXT do mDNS
+	protocolVersion = "1"
+)
+
+// Anúncio e busca mDNS ativos (nil se a descoberta estiver desligada)
+var (
+	mdnsResponder *mdns.Responder
+	mdnsBrowser   *mdns.Browser
+	mdnsStop      = make(chan struct{})
 )
 
 func startDiscovery(port string) {
+	// O mDNS funciona mesmo em redes Wi-Fi que descartam broadcast
+	startMDNS(port)
+
 	// Cria um socket UDP para broadcast
 	conn, err := net.ListenUDP("udp4", &net.UDPAddr{
 		IP:   net.IPv4zero,
@@ -24,11 +53,20 @@ func startDiscovery(port string) {
 	// Configura para permitir broadcast
 	conn.SetReadBuffer(1024)
 
+	// Em redes IPv6 os anúncios vão por multicast em cada interface
+	conn6, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		log.Printf("Descoberta IPv6 indisponível: %v", err)
+	} else {
+		defer conn6.Close()
+	}
+
 	// Inicia a escuta por broadcasts de peers
 	go listenForDiscovery(port)
+	go listenForDiscovery6(port)
 
 	// Anuncia nossa presença periodicamente
-	broadcastAddr, err := net.ResolveUDPAddr("udp4", "255.255.255.255:9999") // Porta fixa para discovery
+	broadcastAddr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", discoveryPort))
 	if err != nil {
 		log.Printf("Erro ao resolver endereço de broadcast: %v", err)
 		updateChatView("Sistema: Erro ao configurar descoberta - " + err.Error())
@@ -43,41 +81,89 @@ func startDiscovery(port string) {
 	defer ticker.Stop() // Importante: evita vazamento de goroutines
 
 	for range ticker.C {
-		message := fmt.Sprintf("MAGICIAN_DISCOVERY_%s", port)
+		message := buildAnnouncement(port)
 		_, err := conn.WriteToUDP([]byte(message), broadcastAddr)
 		if err != nil {
 			log.Printf("Erro ao enviar broadcast: %v", err)
 		}
+
+		if conn6 != nil {
+			announce6(conn6, message)
+		}
 	}
 }
 
-// Melhoria na função listenForDiscovery para tratar melhor erros de conectividade
-func listenForDiscovery(port string) {
-	addr := net.UDPAddr{
-		IP:   net.IPv4zero,
-		Port: 9999, // Porta dedicada para descoberta
+// announce6 envia o anúncio ao grupo multicast em cada interface IPv6.
+// A zona do destino escolhe a interface de saída do endereço link-local.
+func announce6(conn *net.UDPConn, message string) {
+	for _, iface := range multicastInterfaces6() {
+		dst := &net.UDPAddr{IP: net.ParseIP(discoveryGroup6), Port: discoveryPort, Zone: iface.Name}
+		if _, err := conn.WriteToUDP([]byte(message), dst); err != nil {
+			log.Printf("Erro ao enviar anúncio IPv6 por %s: %v", iface.Name, err)
+		}
 	}
+}
 
-	// Tentativa com tratamento de erro aprimorado
-	conn, err := net.ListenUDP("udp", &addr)
+// multicastInterfaces6 lista as interfaces ativas com multicast e endereço IPv6
+func multicastInterfaces6() []net.Interface {
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		// Tenta portas alternativas se a padrão estiver em uso
-		for altPort := 10000; altPort < 10010; altPort++ {
-			addr.Port = altPort
-			conn, err = net.ListenUDP("udp", &addr)
-			if err == nil {
-				log.Printf("Usando porta alternativa para descoberta: %d", altPort)
-				updateChatView(fmt.Sprintf("Sistema: Usando porta alternativa para descoberta: %d", altPort))
+		return nil
+	}
+
+	var result []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil {
+				result = append(result, iface)
 				break
 			}
 		}
+	}
+	return result
+}
 
+// listenForDiscovery6 entra no grupo multicast de descoberta em cada interface IPv6
+func listenForDiscovery6(port string) {
+	group := &net.UDPAddr{IP: net.ParseIP(discoveryGroup6), Port: discoveryPort}
+	for _, iface := range multicastInterfaces6() {
+		iface := iface
+		conn, err := net.ListenMulticastUDP("udp6", &iface, group)
 		if err != nil {
-			log.Printf("Erro ao escutar anúncios: %v", err)
-			updateChatView("Sistema: Falha ao iniciar serviço de descoberta")
-			return
+			log.Printf("Erro ao escutar anúncios IPv6 em %s: %v", iface.Name, err)
+			continue
 		}
+		go readDiscovery(conn, port)
+	}
+}
+
+// Melhoria na função listenForDiscovery para tratar melhor erros de conectividade
+func listenForDiscovery(port string) {
+	addr := net.UDPAddr{
+		IP:   net.IPv4zero,
+		Port: discoveryPort,
+	}
+
+	// Os anúncios sempre vão para a porta fixa; se ela estiver ocupada (outra
+	// instância na mesma máquina) resta a descoberta via mDNS
+	conn, err := net.ListenUDP("udp4", &addr)
+	if err != nil {
+		log.Printf("Erro ao escutar anúncios: %v", err)
+		updateChatView(fmt.Sprintf("Sistema: Porta %d ocupada, descoberta apenas via mDNS", discoveryPort))
+		return
 	}
+	readDiscovery(conn, port)
+}
+
+// readDiscovery trata os anúncios recebidos em um socket de descoberta
+func readDiscovery(conn *net.UDPConn, port string) {
 	defer conn.Close()
 
 	buffer := make([]byte, 1024)
@@ -88,66 +174,144 @@ func listenForDiscovery(port string) {
 			continue
 		}
 
+		// Anúncios sem assinatura (versões antigas) não são mais aceitos
 		message := string(buffer[:n])
-		if strings.HasPrefix(message, "MAGICIAN_DISCOVERY_") {
-			peerPort := strings.TrimPrefix(message, "MAGICIAN_DISCOVERY_")
-			peerAddr := fmt.Sprintf("%s:%s", remoteAddr.IP.String(), peerPort)
-
-			// Não conecta a si mesmo
-			myIPs, err := getLocalIPs()
-			if err != nil {
-				log.Printf("Erro ao obter IPs locais: %v", err)
-				continue
-			}
+		if !strings.HasPrefix(message, announcePrefix) {
+			continue
+		}
 
-			isSelf := false
-			for _, ip := range myIPs {
-				if remoteAddr.IP.String() == ip && peerPort == port {
-					isSelf = true
-					break
-				}
+		peerPort, peerID, err := verifyAnnouncement(message)
+		if err != nil {
+			if err != errOtherRoom && err != errReplayed {
+				log.Printf("Anúncio de descoberta rejeitado de %s: %v", remoteAddr, err)
 			}
+			continue
+		}
 
-			if !isSelf {
-				// Verifica se já estamos conectados a este peer
-				peersMutex.Lock()
-				_, exists := Peers[peerAddr]
-				peersMutex.Unlock()
-
-				if !exists {
-					log.Printf("Descoberto novo peer: %s", peerAddr)
-					updateChatView(fmt.Sprintf("Sistema: Descoberto novo peer: %s", peerAddr))
-					go connectToPeer(peerAddr)
-				}
-			}
+		// Ignora os próprios anúncios
+		if peerID == localIdentity.ID {
+			continue
+		}
+
+		// Endereços link-local só funcionam com a zona (interface)
+		host := remoteAddr.IP.String()
+		if remoteAddr.Zone != "" && remoteAddr.IP.IsLinkLocalUnicast() {
+			host += "%" + remoteAddr.Zone
 		}
+		noteDiscoveredPeer(net.JoinHostPort(host, peerPort), peerID, "", "broadcast")
 	}
 }
 
-func getLocalIPs() ([]string, error) {
-	var ips []string
-	ifaces, err := net.Interfaces()
+// startMDNS anuncia este peer como _magician._tcp.local e procura os demais
+func startMDNS(port string) {
+	p := 0
+	fmt.Sscanf(port, "%d", &p)
+
+	// O ID curto evita colisão entre peers com o mesmo nickname
+	instance := strings.NewReplacer(".", "-", "\\", "-").Replace(Nickname)
+	if localIdentity != nil {
+		instance += "-" + identity.Short(localIdentity.ID)
+	}
+
+	responder, err := mdns.Advertise(mdns.Service{
+		Instance: instance,
+		Service:  mdnsServiceType,
+		Port:     p,
+		Text:     mdnsText(port),
+	})
 	if err != nil {
-		return nil, err
+		log.Printf("Erro ao anunciar via mDNS: %v", err)
+		updateChatView("Sistema: mDNS indisponível - " + err.Error())
+		return
 	}
 
-	for _, iface := range ifaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
+	browser, err := mdns.Browse(mdnsServiceType, mdnsInterval, handleMDNSEntry)
+	if err != nil {
+		responder.Close()
+		log.Printf("Erro ao buscar peers via mDNS: %v", err)
+		return
+	}
+
+	mdnsResponder = responder
+	mdnsBrowser = browser
+	logMessage("Anunciando via mDNS como " + instance)
+
+	// O timestamp assinado no TXT precisa ser renovado
+	go func() {
+		ticker := time.NewTicker(mdnsTextRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mdnsStop:
+				return
+			case <-ticker.C:
+				responder.SetText(mdnsText(port))
+			}
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
+	}()
+}
+
+// stopMDNS avisa a rede que o peer saiu (goodbye) e encerra a busca
+func stopMDNS() {
+	close(mdnsStop)
+	if mdnsBrowser != nil {
+		mdnsBrowser.Close()
+	}
+	if mdnsResponder != nil {
+		mdnsResponder.Close()
+	}
+}
+
+// handleMDNSEntry valida um peer anunciado via mDNS e o registra na lista de descobertos
+func handleMDNSEntry(entry mdns.Entry) {
+	id := entry.Text["id"]
+	if localIdentity != nil && id == localIdentity.ID {
+		return
+	}
+	if entry.Text["room"] != roomTag() {
+		return
+	}
+	if v := entry.Text["v"]; v != protocolVersion {
+		log.Printf("Peer %s usa protocolo incompatível (v%s)", entry.Instance, v)
+		return
+	}
+
+	ts, _ := strconv.ParseInt(entry.Text["ts"], 10, 64)
+	err := verifyAnnouncementFields("mdns", entry.Text["room"], strconv.Itoa(entry.Port), id, ts, entry.Text["mac"], mdnsMaxAge)
+	if err != nil {
+		if err != errReplayed {
+			log.Printf("Anúncio mDNS rejeitado de %s: %v", entry.Instance, err)
+		}
+		return
+	}
+	ip := preferredAddr(entry.Addrs)
+	if ip == nil {
+		return
+	}
+	noteDiscoveredPeer(net.JoinHostPort(ip.String(), fmt.Sprint(entry.Port)), id, entry.Text["nick"], "mDNS")
+}
+
+// preferredAddr escolhe o endereço mais provável de funcionar: IPv4,
+// depois IPv6 global e por último link-local (com a zona)
+func preferredAddr(addrs []net.IPAddr) *net.IPAddr {
+	var global, linkLocal *net.IPAddr
+	for i := range addrs {
+		a := &addrs[i]
+		switch {
+		case a.IP.To4() != nil:
+			return a
+		case a.IP.IsLinkLocalUnicast():
+			if linkLocal == nil && a.Zone != "" {
+				linkLocal = a
 			}
-			if ip != nil && !ip.IsLoopback() && ip.To4() != nil {
-				ips = append(ips, ip.String())
+		default:
+			if global == nil {
+				global = a
 			}
 		}
 	}
-	return ips, nil
+	if global != nil {
+		return global
+	}
+	return linkLocal
 }
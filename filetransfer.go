@@ -1,29 +1,89 @@
 package main
 
 import (
+	"bufio"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
 )
 
 var (
-	chunkSize     = 64 * 1024 // 64 KB
-	incomingFiles = make(map[string][][]byte)
-	incomingMeta  = make(map[string]FileChunk)
-	transferMutex sync.Mutex
+	chunkSize   = 64 * 1024         // 64 KB
+	maxFileSize = 100 * 1024 * 1024 // 100 MB
+	receivedDir = "recebidos"
 )
 
-type FileChunk struct {
+// FileOffer anuncia uma transferência antes do envio dos chunks
+type FileOffer struct {
+	ID          string
 	FileName    string
+	Size        int64
 	TotalChunks int
-	ChunkIndex  int
-	Data        string
 	Sender      string
+	Archive     bool   // Conteúdo é um tar com vários arquivos
+	Compression string // "gzip" quando os chunks podem vir comprimidos
+}
+
+type FileChunk struct {
+	ID         string
+	ChunkIndex int
+	Data       string
+	Compressed bool `json:",omitempty"`
+}
+
+// resolveTargetPeers retorna os peers que devem receber o arquivo
+func resolveTargetPeers(targetPeer string) ([]string, error) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	var targets []string
+	for addr := range Peers {
+		if targetPeer == "" || strings.Contains(addr, targetPeer) {
+			targets = append(targets, addr)
+			if targetPeer != "" {
+				break
+			}
+		}
+	}
+
+	if len(targets) == 0 && targetPeer != "" {
+		return nil, fmt.Errorf("peer '%s' não encontrado", targetPeer)
+	} else if len(targets) == 0 {
+		return nil, fmt.Errorf("nenhum peer conectado para envio do arquivo")
+	}
+	return targets, nil
+}
+
+// sendToTransferPeers envia uma linha do protocolo para os peers ativos de uma
+// transferência. Oferta e chunks seguem no fluxo próprio da transferência, na ordem.
+func sendToTransferPeers(transfers []*Transfer, line string) int {
+	sent := 0
+	for _, t := range transfers {
+		transfersMutex.Lock()
+		active := t.IsActive()
+		transfersMutex.Unlock()
+		if !active {
+			continue
+		}
+
+		if peerSendOn(t.Peer, t.ID, priorityFile, line) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// closeTransferStreams encerra os fluxos abertos para uma transferência
+func closeTransferStreams(transfers []*Transfer) {
+	for _, t := range transfers {
+		closePeerStream(t.Peer, t.ID)
+	}
 }
 
 func sendFile(filePath string, targetPeer string) error {
@@ -40,85 +100,315 @@ func sendFile(filePath string, targetPeer string) error {
 		return fmt.Errorf("erro ao obter informações do arquivo: %v", err)
 	}
 
-	if fileInfo.Size() > 100*1024*1024 {
+	if !fileInfo.Mode().IsRegular() {
+		return fmt.Errorf("'%s' não é um arquivo regular", filePath)
+	}
+
+	if fileInfo.Size() > int64(maxFileSize) {
 		return fmt.Errorf("arquivo muito grande (limite: 100MB)")
 	}
 
+	return sendStream(file, fileInfo.Size(), filepath.Base(filePath), false, targetPeer)
+}
+
+// sendStream envia o conteúdo de r em chunks. O tamanho precisa ser conhecido
+// de antemão para que o receptor valide a oferta e acompanhe o progresso.
+func sendStream(r io.Reader, size int64, fileName string, archive bool, targetPeer string) error {
+	targets, err := resolveTargetPeers(targetPeer)
+	if err != nil {
+		return err
+	}
+
+	id, err := newTransferID()
+	if err != nil {
+		return err
+	}
+
+	// Só comprime se todos os destinos aceitarem gzip e o conteúdo não for já comprimido
+	br := bufio.NewReaderSize(r, chunkSize)
+	head, _ := br.Peek(16)
+	compression := ""
+	if !isCompressedFormat(fileName, head) {
+		compression = featureGzip
+		for _, peer := range targets {
+			if !peerSupports(peer, featureGzip) {
+				compression = ""
+				break
+			}
+		}
+	}
+
 	// Calcula o número total de chunks
-	totalChunks := int((fileInfo.Size() + int64(chunkSize) - 1) / int64(chunkSize))
-	fileName := filepath.Base(filePath)
+	totalChunks := int((size + int64(chunkSize) - 1) / int64(chunkSize))
+
+	// Registra uma transferência de saída para cada peer destino
+	var transfers []*Transfer
+	for _, peer := range targets {
+		t := &Transfer{
+			ID:          id,
+			Peer:        peer,
+			Sender:      Nickname,
+			FileName:    fileName,
+			Size:        size,
+			TotalChunks: totalChunks,
+			Archive:     archive,
+			Compression: compression,
+		}
+		if err := addTransfer(t); err == nil {
+			transfers = append(transfers, t)
+		}
+	}
+
+	offer, err := json.Marshal(FileOffer{
+		ID:          id,
+		FileName:    fileName,
+		Size:        size,
+		TotalChunks: totalChunks,
+		Sender:      Nickname,
+		Archive:     archive,
+		Compression: compression,
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao serializar oferta: %v", err)
+	}
+	defer closeTransferStreams(transfers)
+	sendToTransferPeers(transfers, "[FILE_OFFER]"+string(offer))
 
 	// Adiciona entrada no log
-	logMessage(fmt.Sprintf("Iniciando envio do arquivo '%s' (%d bytes, %d chunks)",
-		fileName, fileInfo.Size(), totalChunks))
+	logMessage(fmt.Sprintf("Iniciando envio do arquivo '%s' [%s] (%d bytes, %d chunks)",
+		fileName, id, size, totalChunks))
 
-	updateChatView(fmt.Sprintf("📤 Enviando arquivo '%s' (%d bytes)...", fileName, fileInfo.Size()))
+	updateChatView(fmt.Sprintf("📤 Enviando arquivo '%s' [%s] (%d bytes)...", fileName, id, size))
 
 	// Envia os chunks
 	buffer := make([]byte, chunkSize)
 	for chunkIndex := 0; chunkIndex < totalChunks; chunkIndex++ {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
+		n, err := io.ReadFull(br, buffer)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			failTransfers(transfers, "erro de leitura")
 			return fmt.Errorf("erro ao ler arquivo: %v", err)
 		}
 
+		payload, compressed := buffer[:n], false
+		if compression != "" {
+			payload, compressed = compressChunk(buffer[:n])
+		}
+
 		// Codifica o chunk em base64
 		chunk := FileChunk{
-			FileName:    fileName,
-			TotalChunks: totalChunks,
-			ChunkIndex:  chunkIndex,
-			Data:        base64.StdEncoding.EncodeToString(buffer[:n]),
-			Sender:      Nickname,
+			ID:         id,
+			ChunkIndex: chunkIndex,
+			Data:       base64.StdEncoding.EncodeToString(payload),
+			Compressed: compressed,
 		}
 
 		// Serializa o chunk em JSON
 		jsonData, err := json.Marshal(chunk)
 		if err != nil {
+			failTransfers(transfers, "erro de serialização")
 			return fmt.Errorf("erro ao serializar chunk: %v", err)
 		}
 
-		// Envia para o peer específico ou todos
-		sent := false
-		peersMutex.Lock()
-		if targetPeer != "" {
-			for addr, conn := range Peers {
-				if strings.Contains(addr, targetPeer) {
-					fmt.Fprintf(conn, "[FILE_TRANSFER]%s\n", string(jsonData))
-					sent = true
-					break
-				}
+		// Aguarda enquanto algum dos lados mantiver a transferência pausada
+		if !waitWhilePaused(transfers) {
+			return transferInterrupted(transfers, fileName)
+		}
+
+		// Respeita os limites definidos para esta transferência com /banda
+		waitTransferLimits(transfers, len(jsonData))
+
+		if sendToTransferPeers(transfers, "[FILE_TRANSFER]"+string(jsonData)) == 0 {
+			return transferInterrupted(transfers, fileName)
+		}
+
+		transfersMutex.Lock()
+		for _, t := range transfers {
+			if t.IsActive() {
+				t.State = TransferInProgress
+				t.received = chunkIndex + 1
+				t.BytesDone += int64(n)
+				t.WireBytes += int64(len(payload))
+				t.LastActivity = time.Now()
 			}
-		} else {
-			for _, conn := range Peers {
-				fmt.Fprintf(conn, "[FILE_TRANSFER]%s\n", string(jsonData))
-				sent = true
+		}
+		transfersMutex.Unlock()
+	}
+
+	transfersMutex.Lock()
+	for _, t := range transfers {
+		if t.IsActive() {
+			t.finish(TransferComplete, "")
+		}
+	}
+	transfersMutex.Unlock()
+
+	summary := fmt.Sprintf("✅ Arquivo '%s' enviado com sucesso!", fileName)
+	if compression != "" && len(transfers) > 0 {
+		summary += fmt.Sprintf(" (%s → %s, %s)", formatBytes(size),
+			formatBytes(transfers[0].WireBytes), compressionRatio(transfers[0].WireBytes, size))
+	}
+	updateChatView(summary)
+	logMessage(fmt.Sprintf("Arquivo '%s' [%s] enviado com sucesso", fileName, id))
+	return nil
+}
+
+// waitWhilePaused bloqueia enquanto houver uma transferência ativa pausada.
+// Retorna false se nenhuma transferência continuar ativa.
+func waitWhilePaused(transfers []*Transfer) bool {
+	for {
+		transfersMutex.Lock()
+		active, paused := false, false
+		for _, t := range transfers {
+			if t.IsActive() {
+				active = true
+				if t.Paused {
+					paused = true
+					// Mantém a transferência viva enquanto pausada
+					t.LastActivity = time.Now()
+				}
 			}
 		}
-		peerCount := len(Peers)
-		peersMutex.Unlock()
+		transfersMutex.Unlock()
 
-		if !sent && targetPeer != "" {
-			return fmt.Errorf("peer '%s' não encontrado", targetPeer)
-		} else if !sent && peerCount > 0 {
-			return fmt.Errorf("falha ao enviar para qualquer peer")
-		} else if !sent {
-			return fmt.Errorf("nenhum peer conectado para envio do arquivo")
+		if !active || !paused {
+			return active
 		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
 
-		// Atualiza status a cada 10% do progresso
-		if chunkIndex%(totalChunks/10+1) == 0 || chunkIndex == totalChunks-1 {
-			progress := float64(chunkIndex+1) / float64(totalChunks) * 100
-			updateChatView(fmt.Sprintf("📤 Enviando '%s': %.1f%% concluído", fileName, progress))
+// waitTransferLimits aguarda o limitador de cada transferência ativa
+func waitTransferLimits(transfers []*Transfer, n int) {
+	transfersMutex.Lock()
+	var limiters []*RateLimiter
+	for _, t := range transfers {
+		if t.IsActive() {
+			limiters = append(limiters, t.limiter)
 		}
 	}
+	transfersMutex.Unlock()
 
-	updateChatView(fmt.Sprintf("✅ Arquivo '%s' enviado com sucesso!", fileName))
-	logMessage(fmt.Sprintf("Arquivo '%s' enviado com sucesso", fileName))
-	return nil
+	for _, l := range limiters {
+		l.Wait(n)
+	}
+}
+
+// transferInterrupted gera o resultado de um envio sem peers ativos
+func transferInterrupted(transfers []*Transfer, fileName string) error {
+	transfersMutex.Lock()
+	cancelled := true
+	for _, t := range transfers {
+		if t.State != TransferCancelled {
+			cancelled = false
+		}
+	}
+	transfersMutex.Unlock()
+
+	if cancelled {
+		updateChatView(fmt.Sprintf("🚫 Envio de '%s' cancelado", fileName))
+		return nil
+	}
+	failTransfers(transfers, "nenhum peer ativo")
+	return fmt.Errorf("transferência '%s' interrompida: nenhum peer ativo", fileName)
+}
+
+// failTransfers marca transferências de saída ainda ativas como falhas
+func failTransfers(transfers []*Transfer, reason string) {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+
+	for _, t := range transfers {
+		if t.IsActive() {
+			t.finish(TransferFailed, reason)
+		}
+	}
+}
+
+// sanitizeFileName reduz o nome recebido a um nome de arquivo simples,
+// impedindo que um peer escreva fora de 'recebidos/'
+func sanitizeFileName(name string) (string, bool) {
+	name = filepath.Base(filepath.Clean(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == ".." || name == "/" || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// handleFileOffer registra uma transferência anunciada por um peer
+func handleFileOffer(peer string, data string) {
+	var offer FileOffer
+	if err := json.Unmarshal([]byte(data), &offer); err != nil {
+		logMessage(fmt.Sprintf("Erro ao processar oferta de arquivo: %v", err))
+		updateChatView(fmt.Sprintf("❌ Erro ao processar oferta de arquivo: %v", err))
+		return
+	}
+
+	limit := int64(maxFileSize)
+	if offer.Archive {
+		limit = maxArchiveSize
+	}
+
+	fileName, ok := sanitizeFileName(offer.FileName)
+	expectedChunks := int((offer.Size + int64(chunkSize) - 1) / int64(chunkSize))
+	if !ok || !validTransferID(offer.ID) || offer.Size < 0 || offer.Size > limit ||
+		offer.TotalChunks != expectedChunks || (offer.Compression != "" && offer.Compression != featureGzip) {
+		logMessage("Recebida oferta de arquivo com dados inválidos de " + peer)
+		updateChatView("❌ Recebida oferta de arquivo com dados inválidos")
+		return
+	}
+
+	t := &Transfer{
+		ID:          offer.ID,
+		Peer:        peer,
+		Sender:      offer.Sender,
+		FileName:    fileName,
+		Size:        offer.Size,
+		TotalChunks: offer.TotalChunks,
+		Incoming:    true,
+		Archive:     offer.Archive,
+		Compression: offer.Compression,
+	}
+
+	// Pacotes são gravados em disco à medida que chegam; arquivos simples ficam em memória
+	if offer.Archive {
+		partial, err := createPartialArchive(offer.ID)
+		if err != nil {
+			logMessage(fmt.Sprintf("Erro ao preparar recebimento de pacote: %v", err))
+			updateChatView(fmt.Sprintf("❌ Erro ao preparar recebimento de pacote: %v", err))
+			return
+		}
+		t.partial = partial
+	} else {
+		t.chunks = make([][]byte, offer.TotalChunks)
+	}
+
+	if err := addTransfer(t); err != nil {
+		t.discardPartial()
+		logMessage(fmt.Sprintf("Oferta %s de %s recusada: %v", offer.ID, peer, err))
+		if errors.Is(err, errDuplicateTransfer) {
+			return
+		}
+		updateChatView(fmt.Sprintf("❌ Oferta de '%s' de %s recusada: %v", fileName, offer.Sender, err))
+		// Avisa o remetente para que ele não continue enviando
+		sendFileControl(peer, offer.ID, controlCancel)
+		return
+	}
+
+	updateChatView(fmt.Sprintf("📥 Recebendo arquivo '%s' [%s] de %s...", fileName, offer.ID, offer.Sender))
+
+	// Arquivos vazios não têm chunks
+	if offer.TotalChunks == 0 {
+		transfersMutex.Lock()
+		saveReceivedFile(t)
+		transfersMutex.Unlock()
+	}
 }
 
 // Melhoria na função handleFileChunk para evitar potencial pânico com JSON inválido
-func handleFileChunk(data string) {
+func handleFileChunk(peer string, data string) {
+	// O remetente já respeita nosso limite anunciado; aqui garantimos o limite localmente
+	downloadLimiter.Wait(len(data))
+
 	var chunk FileChunk
 	err := json.Unmarshal([]byte(data), &chunk)
 	if err != nil {
@@ -127,103 +417,126 @@ func handleFileChunk(data string) {
 		return
 	}
 
-	// Validações adicionais
-	if chunk.FileName == "" || chunk.TotalChunks <= 0 || chunk.ChunkIndex < 0 || chunk.ChunkIndex >= chunk.TotalChunks {
-		logMessage("Recebido chunk de arquivo com dados inválidos")
-		updateChatView("❌ Recebido chunk de arquivo com dados inválidos")
-		return
-	}
-
-	transferMutex.Lock()
-	defer transferMutex.Unlock()
-
-	// Gera um ID para o arquivo (nome + remetente)
-	fileID := fmt.Sprintf("%s_%s", chunk.Sender, chunk.FileName)
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
 
-	// Inicializa estruturas se necessário
-	if _, exists := incomingFiles[fileID]; !exists {
-		incomingFiles[fileID] = make([][]byte, chunk.TotalChunks)
-		incomingMeta[fileID] = chunk
-		updateChatView(fmt.Sprintf("📥 Recebendo arquivo '%s' de %s...", chunk.FileName, chunk.Sender))
+	t := findTransfer(peer, chunk.ID, true)
+	if t == nil || !t.IsActive() {
+		logMessage(fmt.Sprintf("Chunk de transferência desconhecida ou encerrada (%s) de %s", chunk.ID, peer))
+		return
 	}
 
-	// Verifica se o índice é válido para o array
-	meta := incomingMeta[fileID]
-	if chunk.ChunkIndex >= len(incomingFiles[fileID]) {
-		// Realocar o array se preciso
-		newArray := make([][]byte, chunk.TotalChunks)
-		copy(newArray, incomingFiles[fileID])
-		incomingFiles[fileID] = newArray
+	// Validações adicionais
+	if chunk.ChunkIndex < 0 || chunk.ChunkIndex >= t.TotalChunks {
+		t.finish(TransferFailed, "chunk inválido")
+		logMessage("Recebido chunk de arquivo com dados inválidos")
+		updateChatView(fmt.Sprintf("❌ Transferência '%s' cancelada: chunk inválido", t.FileName))
+		return
 	}
 
-	// Decodifica e armazena o chunk
+	// Decodifica, descomprime se necessário e armazena o chunk
 	chunkData, err := base64.StdEncoding.DecodeString(chunk.Data)
-	if err != nil {
+	wireSize := len(chunkData)
+	if err == nil && chunk.Compressed {
+		if t.Compression != featureGzip {
+			err = fmt.Errorf("chunk comprimido sem compressão negociada")
+		} else {
+			chunkData, err = gunzipBytes(chunkData, chunkSize)
+		}
+	}
+	if err != nil || len(chunkData) > chunkSize {
+		t.finish(TransferFailed, "chunk corrompido")
 		logMessage(fmt.Sprintf("Erro ao decodificar chunk: %v", err))
-		updateChatView(fmt.Sprintf("❌ Erro ao decodificar chunk: %v", err))
+		updateChatView(fmt.Sprintf("❌ Transferência '%s' cancelada: chunk corrompido", t.FileName))
 		return
 	}
 
-	incomingFiles[fileID][chunk.ChunkIndex] = chunkData
+	t.State = TransferInProgress
+	t.LastActivity = time.Now()
 
-	// Verifica se todos os chunks foram recebidos
-	complete := true
-	for _, c := range incomingFiles[fileID] {
-		if c == nil {
-			complete = false
-			break
+	if t.Archive {
+		// O pacote é gravado sequencialmente, então os chunks precisam chegar em ordem
+		if chunk.ChunkIndex != t.received {
+			t.finish(TransferFailed, "chunk fora de ordem")
+			updateChatView(fmt.Sprintf("❌ Transferência '%s' cancelada: chunk fora de ordem", t.FileName))
+			return
 		}
+		if _, err := t.partial.Write(chunkData); err != nil {
+			t.finish(TransferFailed, "erro ao gravar")
+			updateChatView(fmt.Sprintf("❌ Erro ao gravar pacote '%s': %v", t.FileName, err))
+			return
+		}
+		t.received++
+		t.BytesDone += int64(len(chunkData))
+		t.WireBytes += int64(wireSize)
+	} else {
+		if t.chunks[chunk.ChunkIndex] == nil {
+			t.received++
+			t.BytesDone += int64(len(chunkData))
+			t.WireBytes += int64(wireSize)
+		}
+		t.chunks[chunk.ChunkIndex] = chunkData
 	}
 
-	// Evita divisão por zero se totalChunks for pequeno
-	updateInterval := meta.TotalChunks / 10
-	if updateInterval < 1 {
-		updateInterval = 1
-	}
-
-	// Atualiza o progresso a cada 10% ou quando concluído
-	progress := float64(countReceivedChunks(fileID)) / float64(meta.TotalChunks) * 100
-	if chunk.ChunkIndex%updateInterval == 0 || complete {
-		updateChatView(fmt.Sprintf("📥 Recebendo '%s' de %s: %.1f%% concluído",
-			meta.FileName, meta.Sender, progress))
-	}
+	// O progresso é exibido no painel de transferências
+	complete := t.received == t.TotalChunks
 
 	// Se completo, salva o arquivo
-	if complete {
-		saveReceivedFile(fileID)
+	if complete && t.Archive {
+		// A transferência termina aqui, para que a limpeza periódica não a
+		// expire nem descarte o pacote enquanto ele é desempacotado
+		partial := t.partial
+		t.partial = nil
+		t.finish(TransferComplete, "")
+		go extractReceivedArchive(t, partial)
+	} else if complete {
+		saveReceivedFile(t)
 	}
 }
 
-func countReceivedChunks(fileID string) int {
-	count := 0
-	for _, c := range incomingFiles[fileID] {
-		if c != nil {
-			count++
+// uniqueReceivedPath evita sobrescrever um arquivo já recebido
+func uniqueReceivedPath(fileName string) string {
+	path := filepath.Join(receivedDir, fileName)
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
 		}
+		path = filepath.Join(receivedDir, fmt.Sprintf("%s (%d)%s", base, i, ext))
 	}
-	return count
 }
-func saveReceivedFile(fileID string) {
-	meta := incomingMeta[fileID]
-	filePath := fmt.Sprintf("recebidos/%s", meta.FileName)
 
-	os.MkdirAll("recebidos", 0755)
-	file, err := os.Create(filePath)
+// saveReceivedFile grava a transferência completa em disco.
+// Deve ser chamada com transfersMutex travado.
+func saveReceivedFile(t *Transfer) {
+	os.MkdirAll(receivedDir, 0755)
+	filePath := uniqueReceivedPath(t.FileName)
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
+		t.finish(TransferFailed, "erro ao salvar")
 		logMessage(fmt.Sprintf("Erro ao criar arquivo recebido: %v", err))
 		updateChatView(fmt.Sprintf("❌ Erro ao salvar arquivo: %v", err))
 		return
 	}
 	defer file.Close()
 
-	for _, chunk := range incomingFiles[fileID] {
-		file.Write(chunk)
+	for _, chunk := range t.chunks {
+		if _, err := file.Write(chunk); err != nil {
+			t.finish(TransferFailed, "erro ao salvar")
+			logMessage(fmt.Sprintf("Erro ao gravar arquivo recebido: %v", err))
+			updateChatView(fmt.Sprintf("❌ Erro ao salvar arquivo: %v", err))
+			return
+		}
 	}
 
-	updateChatView(fmt.Sprintf("✅ Arquivo '%s' salvo em 'recebidos/'", meta.FileName))
-	logMessage(fmt.Sprintf("Arquivo '%s' recebido e salvo com sucesso", meta.FileName))
+	// Libera a memória dos chunks
+	t.finish(TransferComplete, "")
+
+	updateChatView(fmt.Sprintf("✅ Arquivo '%s' salvo em '%s'%s", t.FileName, filePath, t.compressionSummary()))
+	logMessage(fmt.Sprintf("Arquivo '%s' [%s] recebido e salvo com sucesso", t.FileName, t.ID))
 
-	// Limpa memória
-	delete(incomingFiles, fileID)
-	delete(incomingMeta, fileID)
+	registerReceivedFile(t.ID, filePath)
+	go showFilePreview(t.ID, filePath)
 }
@@ -2,10 +2,16 @@ module magician
 
 go 1.24.2
 
-require github.com/jroimartin/gocui v0.5.0
+require (
+	github.com/jroimartin/gocui v0.5.0
+	// Fixado: golang.org/x/net/quic (transport/quic.go) é experimental e sem
+	// garantia de API; atualize só depois de rodar os testes do transporte
+	golang.org/x/net v0.39.0
+)
 
 require (
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/nsf/termbox-go v1.1.1 // indirect
-	golang.org/x/net v0.39.0 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
 )
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"magician/identity"
+	"magician/transport"
+)
+
+// Autenticação entre peers, antes da sessão multiplexada. A senha nunca
+// trafega: os dois lados provam conhecê-la com HMACs (chave roomKey) sobre
+// nonces aleatórios e o material exportado da sessão TLS, então um endereço
+// falso (anúncio de descoberta forjado, por exemplo) não aprende nada e não
+// consegue repassar a prova para o peer verdadeiro.
+//
+// O material do TLS só entra quando as duas pontas estão na mesma sessão.
+// Cada lado anuncia uma marca da sua sessão (ou "-" sem TLS próprio):
+// atrás de um proxy reverso que termina o TLS, o listener ws:// não tem
+// sessão e os dois lados seguem sem o material; se os dois têm TLS mas as
+// marcas diferem, há algo terminando o TLS no meio e o handshake falha. As
+// marcas entram no transcript, então quem as troca no caminho derruba as
+// provas.
+//
+// Cada lado também assina o mesmo conteúdo com a chave da sua identidade,
+// como no relay: o ID que vale para a agenda e para a DHT é o da chave que
+// assinou, e não o que o peer declara depois no HELLO.
+//
+//	cliente:  AUTH <nonce do cliente> <marca da sessão>
+//	servidor: CHALLENGE <nonce do servidor> <marca da sessão> <hmac do servidor> <chave> <assinatura>
+//	          (ou MISMATCH, se as sessões TLS diferem)
+//	cliente:  PROOF <hmac do cliente> <chave> <assinatura>
+//	servidor: OK  (ou DENIED)
+//
+// O cliente confere o HMAC do servidor antes de responder, então também
+// não conversa com quem não conhece a senha.
+const (
+	authNonceSize = 16
+	authTimeout   = 30 * time.Second
+
+	// Rótulo do material exportado do TLS (RFC 5705)
+	authBindingLabel = "EXPORTER-magician-peer-auth"
+)
+
+var errAuthDenied = errors.New("senha incorreta")
+
+var errBindingMismatch = errors.New("as pontas estão em sessões TLS diferentes, então algo termina o TLS no caminho; " +
+	"atrás de um proxy reverso, escute com ws:// e deixe o TLS com o proxy")
+
+// Marca de quem não tem sessão TLS própria (ws:// atrás de proxy, mem://)
+const bindingNone = "-"
+
+// bindingTag identifica a sessão TLS sem revelar o material exportado
+func bindingTag(binding []byte) string {
+	if binding == nil {
+		return bindingNone
+	}
+	sum := sha256.Sum256(append([]byte("magician-peer-binding:"), binding...))
+	return hex.EncodeToString(sum[:16])
+}
+
+// authBinding decide o que entra no transcript: as duas marcas e, se as duas
+// pontas estão na mesma sessão TLS, o material exportado dela
+func authBinding(clientTag, serverTag string, binding []byte) ([]byte, error) {
+	for _, tag := range []string{clientTag, serverTag} {
+		if _, err := hex.DecodeString(tag); tag != bindingNone && (err != nil || len(tag) != 32) {
+			return nil, fmt.Errorf("marca de sessão inválida")
+		}
+	}
+
+	material := []byte(clientTag + "|" + serverTag + "|")
+	switch {
+	case clientTag == bindingNone || serverTag == bindingNone:
+		return material, nil
+	case clientTag != serverTag:
+		return nil, errBindingMismatch
+	}
+	return append(material, binding...), nil
+}
+
+// authTranscript junta o papel, os nonces e o material da sessão
+func authTranscript(role string, clientNonce, serverNonce, binding []byte) []byte {
+	var t []byte
+	t = append(t, role...)
+	t = append(t, '|')
+	t = append(t, clientNonce...)
+	t = append(t, serverNonce...)
+	t = append(t, binding...)
+	return t
+}
+
+// Prefixo das assinaturas de identidade no handshake
+const authIdentContext = "magician-peer-ident:"
+
+// authIdent assina o transcript com a identidade local e retorna a chave
+// pública e a assinatura em base64
+func authIdent(role string, clientNonce, serverNonce, binding []byte) (string, string) {
+	message := append([]byte(authIdentContext), authTranscript(role, clientNonce, serverNonce, binding)...)
+	sig := ed25519.Sign(localIdentity.Private, message)
+	return base64.StdEncoding.EncodeToString(localIdentity.Public), base64.StdEncoding.EncodeToString(sig)
+}
+
+// verifyAuthIdent confere a assinatura do outro lado e retorna o ID dele
+func verifyAuthIdent(pubField, sigField, role string, clientNonce, serverNonce, binding []byte) (string, error) {
+	pub, err := base64.StdEncoding.DecodeString(pubField)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("chave pública inválida")
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigField)
+	if err != nil {
+		return "", fmt.Errorf("assinatura inválida")
+	}
+	message := append([]byte(authIdentContext), authTranscript(role, clientNonce, serverNonce, binding)...)
+	if !ed25519.Verify(pub, message, sig) {
+		return "", fmt.Errorf("assinatura de identidade não confere")
+	}
+	return identity.IDFromPublicKey(pub), nil
+}
+
+// authMAC prova o conhecimento da senha para um papel ("cliente"/"servidor")
+func authMAC(role string, clientNonce, serverNonce, binding []byte) string {
+	mac := hmac.New(sha256.New, roomKey())
+	mac.Write([]byte("magician-peer-auth:"))
+	mac.Write(authTranscript(role, clientNonce, serverNonce, binding))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAuthNonce() ([]byte, error) {
+	nonce := make([]byte, authNonceSize)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+// readAuthLine lê uma linha do handshake e separa os campos
+func readAuthLine(reader *bufio.Reader, command string, fields int) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) > 0 && parts[0] == "DENIED" {
+		return nil, errAuthDenied
+	}
+	if len(parts) > 0 && parts[0] == "MISMATCH" {
+		return nil, errBindingMismatch
+	}
+	if len(parts) != fields+1 || parts[0] != command {
+		return nil, fmt.Errorf("resposta inesperada no handshake: %q", strings.TrimSpace(line))
+	}
+	return parts[1:], nil
+}
+
+// clientHandshake autentica a conexão discada por nós e retorna o ID
+// comprovado do peer
+func clientHandshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	tlsBinding := transport.ChannelBinding(conn, authBindingLabel)
+	clientTag := bindingTag(tlsBinding)
+	clientNonce, err := newAuthNonce()
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(conn, "AUTH %s %s\n", hex.EncodeToString(clientNonce), clientTag); err != nil {
+		return "", err
+	}
+
+	fields, err := readAuthLine(reader, "CHALLENGE", 5)
+	if err != nil {
+		return "", err
+	}
+	serverNonce, err := hex.DecodeString(fields[0])
+	if err != nil || len(serverNonce) != authNonceSize {
+		return "", fmt.Errorf("desafio inválido")
+	}
+	binding, err := authBinding(clientTag, fields[1], tlsBinding)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(fields[2]), []byte(authMAC("servidor", clientNonce, serverNonce, binding))) {
+		return "", fmt.Errorf("o peer não conhece a senha da sala")
+	}
+	peerID, err := verifyAuthIdent(fields[3], fields[4], "servidor", clientNonce, serverNonce, binding)
+	if err != nil {
+		return "", err
+	}
+
+	pub, sig := authIdent("cliente", clientNonce, serverNonce, binding)
+	if _, err := fmt.Fprintf(conn, "PROOF %s %s %s\n", authMAC("cliente", clientNonce, serverNonce, binding), pub, sig); err != nil {
+		return "", err
+	}
+	if _, err := readAuthLine(reader, "OK", 0); err != nil {
+		return "", err
+	}
+	return peerID, nil
+}
+
+// serverHandshake autentica uma conexão recebida, a partir da linha AUTH já
+// lida, e retorna o ID comprovado do peer
+func serverHandshake(conn net.Conn, reader *bufio.Reader, first string) (string, error) {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	deny := func(err error) (string, error) {
+		fmt.Fprintln(conn, "DENIED")
+		return "", err
+	}
+
+	parts := strings.Fields(first)
+	if len(parts) != 3 || parts[0] != "AUTH" {
+		return deny(fmt.Errorf("handshake sem nonce"))
+	}
+	clientNonce, err := hex.DecodeString(parts[1])
+	if err != nil || len(clientNonce) != authNonceSize {
+		return deny(fmt.Errorf("nonce inválido"))
+	}
+
+	tlsBinding := transport.ChannelBinding(conn, authBindingLabel)
+	serverTag := bindingTag(tlsBinding)
+	binding, err := authBinding(parts[2], serverTag, tlsBinding)
+	if errors.Is(err, errBindingMismatch) {
+		fmt.Fprintln(conn, "MISMATCH")
+		return "", err
+	} else if err != nil {
+		return deny(err)
+	}
+	serverNonce, err := newAuthNonce()
+	if err != nil {
+		return deny(err)
+	}
+	pub, sig := authIdent("servidor", clientNonce, serverNonce, binding)
+	if _, err := fmt.Fprintf(conn, "CHALLENGE %s %s %s %s %s\n", hex.EncodeToString(serverNonce), serverTag,
+		authMAC("servidor", clientNonce, serverNonce, binding), pub, sig); err != nil {
+		return "", err
+	}
+
+	fields, err := readAuthLine(reader, "PROOF", 3)
+	if err != nil {
+		return deny(err)
+	}
+	if !hmac.Equal([]byte(fields[0]), []byte(authMAC("cliente", clientNonce, serverNonce, binding))) {
+		return deny(errAuthDenied)
+	}
+	peerID, err := verifyAuthIdent(fields[1], fields[2], "cliente", clientNonce, serverNonce, binding)
+	if err != nil {
+		return deny(err)
+	}
+
+	if _, err := fmt.Fprintln(conn, "OK"); err != nil {
+		return "", err
+	}
+	return peerID, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"magician/identity"
+	"magician/transport"
+)
+
+// O transporte em memória só existe nos testes
+func init() {
+	transport.Register(transport.NewMemory())
+}
+
+// memoryPair prepara senha e identidade e retorna as duas pontas de uma
+// conexão mem://
+func memoryPair(t *testing.T, room string) (client, server net.Conn) {
+	t.Helper()
+	testIdentity(t)
+
+	tr, ok := transport.Get("mem")
+	if !ok {
+		t.Fatal("transporte mem não registrado")
+	}
+	ln, err := tr.Listen(room)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err = transport.Dial("mem://" + room)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server = <-accepted
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// testIdentity prepara a senha da sala e uma identidade local nova
+func testIdentity(t *testing.T) {
+	t.Helper()
+	Password = "senha-de-teste"
+
+	id, err := identity.Load(t.TempDir() + "/identity.key")
+	if err != nil {
+		t.Fatalf("identidade: %v", err)
+	}
+	localIdentity = id
+}
+
+type handshakeResult struct {
+	id  string
+	err error
+}
+
+// runServer atende o handshake do lado de quem escuta
+func runServer(server net.Conn) <-chan handshakeResult {
+	done := make(chan handshakeResult, 1)
+	go func() {
+		reader := bufio.NewReader(server)
+		first, err := reader.ReadString('\n')
+		if err != nil {
+			done <- handshakeResult{err: err}
+			return
+		}
+		id, err := serverHandshake(server, reader, strings.TrimSpace(first))
+		done <- handshakeResult{id, err}
+	}()
+	return done
+}
+
+func TestHandshakeProvesIdentity(t *testing.T) {
+	client, server := memoryPair(t, "handshake-ok")
+	done := runServer(server)
+
+	id, err := clientHandshake(client, bufio.NewReader(client))
+	if err != nil {
+		t.Fatalf("cliente: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("servidor: %v", res.err)
+	}
+	if id != localIdentity.ID || res.id != localIdentity.ID {
+		t.Errorf("IDs comprovados %s e %s, esperava %s", id, res.id, localIdentity.ID)
+	}
+}
+
+// Quem não conhece a senha não passa do PROOF
+func TestHandshakeRejectsWrongProof(t *testing.T) {
+	client, server := memoryPair(t, "handshake-proof")
+	done := runServer(server)
+
+	nonce := make([]byte, authNonceSize)
+	rand.Read(nonce)
+	fmt.Fprintf(client, "AUTH %s %s\n", hex.EncodeToString(nonce), bindingNone)
+
+	reader := bufio.NewReader(client)
+	if _, err := readAuthLine(reader, "CHALLENGE", 5); err != nil {
+		t.Fatalf("desafio: %v", err)
+	}
+	pub, sig := authIdent("cliente", nonce, nonce, noBinding)
+	fmt.Fprintf(client, "PROOF %s %s %s\n", strings.Repeat("00", 32), pub, sig)
+
+	if _, err := readAuthLine(reader, "OK", 0); !errors.Is(err, errAuthDenied) {
+		t.Errorf("esperava DENIED, veio %v", err)
+	}
+	if res := <-done; !errors.Is(res.err, errAuthDenied) {
+		t.Errorf("servidor aceitou prova inválida: %v", res.err)
+	}
+}
+
+// Material do transcript quando nenhuma das pontas tem TLS (mem://)
+var noBinding = []byte(bindingNone + "|" + bindingNone + "|")
+
+// fakeServer responde ao AUTH com o desafio montado por challenge e retorna
+// o que o cliente enviou em seguida
+func fakeServer(server net.Conn, challenge func(clientNonce, serverNonce []byte) string) <-chan string {
+	next := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(server)
+		first, err := reader.ReadString('\n')
+		if err != nil {
+			next <- ""
+			return
+		}
+		parts := strings.Fields(first)
+		if len(parts) != 3 {
+			next <- ""
+			return
+		}
+		clientNonce, _ := hex.DecodeString(parts[1])
+		serverNonce := make([]byte, authNonceSize)
+		rand.Read(serverNonce)
+		fmt.Fprintln(server, challenge(clientNonce, serverNonce))
+
+		line, _ := reader.ReadString('\n')
+		next <- line
+	}()
+	return next
+}
+
+// O cliente não responde a quem não prova conhecer a senha, como um
+// endereço falso vindo da descoberta
+func TestHandshakeClientRejectsImpostor(t *testing.T) {
+	client, server := memoryPair(t, "handshake-impostor")
+	next := fakeServer(server, func(clientNonce, serverNonce []byte) string {
+		pub, sig := authIdent("servidor", clientNonce, serverNonce, noBinding)
+		return fmt.Sprintf("CHALLENGE %s %s %s %s %s", hex.EncodeToString(serverNonce), bindingNone, strings.Repeat("ab", 32), pub, sig)
+	})
+
+	if _, err := clientHandshake(client, bufio.NewReader(client)); err == nil {
+		t.Fatal("cliente aceitou servidor sem a senha")
+	}
+	client.Close()
+	if line := <-next; line != "" {
+		t.Errorf("cliente enviou %q a um impostor", line)
+	}
+}
+
+// Uma assinatura feita para o outro papel não vale como prova de identidade
+func TestHandshakeRejectsReflectedSignature(t *testing.T) {
+	client, server := memoryPair(t, "handshake-reflect")
+	next := fakeServer(server, func(clientNonce, serverNonce []byte) string {
+		pub, sig := authIdent("cliente", clientNonce, serverNonce, noBinding)
+		return fmt.Sprintf("CHALLENGE %s %s %s %s %s", hex.EncodeToString(serverNonce), bindingNone,
+			authMAC("servidor", clientNonce, serverNonce, noBinding), pub, sig)
+	})
+
+	if _, err := clientHandshake(client, bufio.NewReader(client)); err == nil {
+		t.Fatal("cliente aceitou assinatura do papel errado")
+	}
+	client.Close()
+	if line := <-next; line != "" {
+		t.Errorf("cliente enviou %q sem identidade comprovada", line)
+	}
+}
+
+// proxiedPair disca wss:// para um proxy reverso que termina o TLS e repassa
+// para o listener do peer, como na instalação atrás da porta 443. Com
+// backendTLS o proxy fala wss:// também com o peer, em outra sessão TLS.
+func proxiedPair(t *testing.T, backendTLS bool) (client, server net.Conn) {
+	t.Helper()
+	testIdentity(t)
+
+	proxy := httptest.NewUnstartedServer(nil)
+	proxy.StartTLS()
+	t.Cleanup(proxy.Close)
+
+	backend := &url.URL{Scheme: "http"}
+	listener := transport.NewWebSocket(false, nil, nil)
+	if backendTLS {
+		backend.Scheme = "https"
+		listener = transport.NewWebSocket(true, proxy.TLS.Clone(), nil)
+	}
+	ln, err := listener.Listen("127.0.0.1:0/magician")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	backend.Host = ln.Addr().String()
+
+	reverse := httputil.NewSingleHostReverseProxy(backend)
+	reverse.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	proxy.Config.Handler = reverse
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialer := transport.NewWebSocket(true, nil, &tls.Config{InsecureSkipVerify: true})
+	client, err = dialer.Dial(strings.TrimPrefix(proxy.URL, "https://") + "/magician")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("o listener não recebeu a conexão")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// wss:// no cliente e ws:// atrás do proxy: só o cliente tem sessão TLS, e o
+// handshake segue sem o material exportado
+func TestHandshakeThroughTLSTerminatingProxy(t *testing.T) {
+	client, server := proxiedPair(t, false)
+	if transport.ChannelBinding(client, authBindingLabel) == nil || transport.ChannelBinding(server, authBindingLabel) != nil {
+		t.Fatal("esperava TLS só no cliente")
+	}
+	done := runServer(server)
+
+	id, err := clientHandshake(client, bufio.NewReader(client))
+	if err != nil {
+		t.Fatalf("cliente: %v", err)
+	}
+	if res := <-done; res.err != nil || res.id != localIdentity.ID || id != localIdentity.ID {
+		t.Fatalf("servidor: %q %v", res.id, res.err)
+	}
+}
+
+// Com TLS dos dois lados, mas em sessões diferentes, os dois lados falham
+// com o erro que aponta o proxy em vez de "senha incorreta"
+func TestHandshakeDetectsDifferentTLSSessions(t *testing.T) {
+	client, server := proxiedPair(t, true)
+	done := runServer(server)
+
+	if _, err := clientHandshake(client, bufio.NewReader(client)); !errors.Is(err, errBindingMismatch) {
+		t.Errorf("cliente: esperava sessões diferentes, veio %v", err)
+	}
+	if res := <-done; !errors.Is(res.err, errBindingMismatch) {
+		t.Errorf("servidor: esperava sessões diferentes, veio %v", res.err)
+	}
+}
@@ -0,0 +1,81 @@
+// Package identity mantém o par de chaves ed25519 que identifica este peer
+// de forma estável entre sessões, independente de IP ou porta.
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// Tamanho do ID em bytes (160 bits)
+const idSize = 20
+
+const pemType = "MAGICIAN IDENTITY"
+
+// Identity é a chave privada do peer e o ID derivado dela
+type Identity struct {
+	Private ed25519.PrivateKey
+	Public  ed25519.PublicKey
+	ID      string
+}
+
+// IDFromPublicKey deriva o ID de um peer: hex dos primeiros 160 bits do SHA-256 da chave pública
+func IDFromPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:idSize])
+}
+
+// ValidID informa se s tem o formato de um ID de peer
+func ValidID(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == idSize
+}
+
+// Short abrevia um ID para exibição
+func Short(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Load lê a identidade do arquivo, criando uma nova se ele não existir
+func Load(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return create(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler identidade: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemType || len(block.Bytes) != ed25519.SeedSize {
+		return nil, fmt.Errorf("arquivo de identidade inválido: %s", path)
+	}
+	return fromSeed(block.Bytes), nil
+}
+
+func create(path string) (*Identity, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("erro ao gerar identidade: %v", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: seed})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("erro ao salvar identidade: %v", err)
+	}
+	return fromSeed(seed), nil
+}
+
+func fromSeed(seed []byte) *Identity {
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := priv.Public().(ed25519.PublicKey)
+	return &Identity{Private: priv, Public: pub, ID: IDFromPublicKey(pub)}
+}
@@ -13,6 +13,7 @@ import (
 
 // Variáveis globais compartilhadas
 var Nickname, Password string
+var ListenPort string
 var Peers = make(map[string]net.Conn)
 var G *gocui.Gui
 
@@ -22,11 +23,24 @@ func initLogSystem() error {
 }
 
 func initFileTransferSystem() error {
-	os.MkdirAll("recebidos", 0755)
+	os.MkdirAll(receivedDir, 0755)
+	go startTransferJanitor()
 	return nil
 }
 
 func main() {
+	// Modo relay: sem interface nem prompts
+	if len(os.Args) > 1 && os.Args[1] == "relay" {
+		runRelay(os.Args[2:])
+		return
+	}
+
+	// Gera e confere o torrc, sem iniciar o chat
+	if len(os.Args) > 1 && os.Args[1] == "tor-setup" {
+		runTorSetup(os.Args[2:])
+		return
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Seu nome: ")
 	Nickname, _ = reader.ReadString('\n')
@@ -35,14 +49,37 @@ func main() {
 	fmt.Print("Porta para escutar (ex: 9000): ")
 	port, _ := reader.ReadString('\n')
 	port = strings.TrimSpace(port)
+	ListenPort = port
 
 	fmt.Print("Senha (obrigatória): ")
 	Password, _ = reader.ReadString('\n')
 	Password = strings.TrimSpace(Password)
 
-	fmt.Print("Habilitar descoberta automática de peers? (s/n): ")
-	enableDiscovery, _ := reader.ReadString('\n')
-	enableDiscovery = strings.TrimSpace(strings.ToLower(enableDiscovery))
+	fmt.Print("Modo somente Tor (nenhuma conexão fora do Tor)? (s/n): ")
+	strictTor, _ := reader.ReadString('\n')
+	strictTor = strings.TrimSpace(strings.ToLower(strictTor))
+
+	// No modo somente Tor, WebSocket, UPnP e descoberta ficam desligados e
+	// o serviço onion é obrigatório
+	var wsListen string
+	enableMapping, enableOnion, enableDiscovery := "n", "s", "n"
+	if strictTor != "s" && strictTor != "sim" {
+		fmt.Print("Escutar também via WebSocket (ex: wss://:8443/magician) ou Enter para pular: ")
+		wsListen, _ = reader.ReadString('\n')
+		wsListen = strings.TrimSpace(wsListen)
+
+		fmt.Print("Mapear a porta no roteador (UPnP/NAT-PMP)? (s/n): ")
+		enableMapping, _ = reader.ReadString('\n')
+		enableMapping = strings.TrimSpace(strings.ToLower(enableMapping))
+
+		fmt.Print("Publicar a porta como serviço onion pelo Tor? (s/n): ")
+		enableOnion, _ = reader.ReadString('\n')
+		enableOnion = strings.TrimSpace(strings.ToLower(enableOnion))
+
+		fmt.Print("Habilitar descoberta automática de peers? (s/n): ")
+		enableDiscovery, _ = reader.ReadString('\n')
+		enableDiscovery = strings.TrimSpace(strings.ToLower(enableDiscovery))
+	}
 
 	// Inicializa os subsistemas
 	if err := initLogSystem(); err != nil {
@@ -53,23 +90,73 @@ func main() {
 		log.Fatalf("Erro ao inicializar sistema de transferência: %v", err)
 	}
 
+	if err := loadIdentity(); err != nil {
+		log.Fatalf("Erro ao carregar identidade: %v", err)
+	}
+
+	if err := initTransports(); err != nil {
+		log.Fatalf("Erro ao inicializar transportes: %v", err)
+	}
+
+	if err := configureTorDialer(); err != nil {
+		log.Fatalf("Erro ao configurar o Tor: %v", err)
+	}
+	if strictTor == "s" || strictTor == "sim" {
+		if err := enableTorOnly(); err != nil {
+			log.Fatalf("Modo somente Tor: %v", err)
+		}
+	}
+	go monitorTor()
+
+	if err := loadContacts(); err != nil {
+		log.Printf("Agenda de contatos ignorada: %v", err)
+	}
+
 	// Adiciona entrada inicial ao log
 	logMessage(fmt.Sprintf("--- Sessão iniciada por %s na porta %s ---", Nickname, port))
 
+	// O Tor não transporta UDP: no modo somente Tor não há QUIC
+	if !torOnly {
+		listenQUIC(port)
+	}
 	go listenForPeers(port)
 
+	if wsListen != "" {
+		go listenWebSocket(wsListen)
+	}
+
+	if enableMapping == "s" || enableMapping == "sim" {
+		go startPortMapping(port)
+	}
+
+	if enableOnion == "s" || enableOnion == "sim" {
+		go startOnionService(port)
+	}
+
 	if enableDiscovery == "s" || enableDiscovery == "sim" {
 		go startDiscovery(port)
 	}
 
-	fmt.Print("Conectar a peer (IP:porta) ou Enter para pular: ")
+	initDHT(port)
+
+	// Os contatos favoritos são conectados sem perguntar
+	go dialFavorites()
+
+	fmt.Print("Conectar a peer (IP:porta ou contato) ou Enter para pular: ")
 	addr, _ := reader.ReadString('\n')
 	addr = strings.TrimSpace(addr)
 
-	if addr != "" {
+	if c, ok := findContact(addr); ok {
+		go dialContact(c)
+	} else if addr != "" {
 		go connectToPeer(addr)
 	}
 
 	// Inicia a interface
 	initUI()
+
+	// Remove os mapeamentos do roteador, o anúncio mDNS e o serviço onion ao sair
+	stopPortMapping()
+	stopMDNS()
+	stopOnionService()
 }
@@ -0,0 +1,213 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Browser procura periodicamente instâncias de um tipo de serviço
+type Browser struct {
+	svcName string
+	found   func(Entry)
+	conn    *conn
+	done    chan struct{}
+
+	mu        sync.Mutex
+	instances map[string]*Entry // Por nome completo da instância
+	hosts     map[string][]net.IPAddr
+	reported  map[string]string // Última versão entregue de cada instância
+}
+
+// Browse consulta a rede a cada intervalo e chama found para cada instância
+// nova ou alterada
+func Browse(service string, interval time.Duration, found func(Entry)) (*Browser, error) {
+	c, err := listen()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir socket mDNS: %v", err)
+	}
+
+	b := &Browser{
+		svcName:   serviceName(service),
+		found:     found,
+		conn:      c,
+		done:      make(chan struct{}),
+		instances: make(map[string]*Entry),
+		hosts:     make(map[string][]net.IPAddr),
+		reported:  make(map[string]string),
+	}
+	c.receive(b.handle)
+	go b.query(interval)
+	return b, nil
+}
+
+// Close para a busca
+func (b *Browser) Close() {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	close(b.done)
+	b.conn.close()
+}
+
+// query envia a pergunta PTR pelo tipo de serviço a cada intervalo
+func (b *Browser) query(interval time.Duration) {
+	name, err := dnsmessage.NewName(b.svcName)
+	if err != nil {
+		return
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	msg, err := builder.Finish()
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.conn.send(msg, 0)
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handle processa as respostas. Os registros de uma instância podem chegar
+// em pacotes diferentes, por isso ficam guardados até estarem completos.
+func (b *Browser) handle(p packet) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(p.data)
+	if err != nil || !header.Response {
+		return
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return
+	}
+	parser.SkipAllAuthorities()
+	additionals, _ := parser.AllAdditionals()
+	records := append(answers, additionals...)
+
+	zone := b.conn.interfaceName(p.ifIndex)
+
+	b.mu.Lock()
+	var touched []string
+	for _, rr := range records {
+		name := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name != strings.ToLower(b.svcName) {
+				continue
+			}
+			inst := strings.ToLower(body.PTR.String())
+			if rr.Header.TTL == 0 {
+				// Goodbye: a instância saiu da rede
+				delete(b.instances, inst)
+				delete(b.reported, inst)
+				continue
+			}
+			if _, ok := b.instances[inst]; !ok {
+				b.instances[inst] = &Entry{Instance: instanceLabel(body.PTR.String(), b.svcName)}
+			}
+			touched = append(touched, inst)
+		case *dnsmessage.SRVResource:
+			if e, ok := b.instances[name]; ok {
+				e.Host = strings.ToLower(body.Target.String())
+				e.Port = int(body.Port)
+				touched = append(touched, name)
+			}
+		case *dnsmessage.TXTResource:
+			if e, ok := b.instances[name]; ok {
+				e.Text = parseTXT(body.TXT)
+				touched = append(touched, name)
+			}
+		case *dnsmessage.AResource:
+			b.addHostAddr(name, net.IPAddr{IP: net.IP(body.A[:])})
+		case *dnsmessage.AAAAResource:
+			addr := net.IPAddr{IP: net.IP(body.AAAA[:])}
+			if addr.IP.IsLinkLocalUnicast() {
+				addr.Zone = zone
+			}
+			b.addHostAddr(name, addr)
+		}
+	}
+
+	// Instâncias completas que mudaram desde a última entrega
+	var ready []Entry
+	for inst, e := range b.instances {
+		addrs := b.hosts[e.Host]
+		if e.Port == 0 || len(addrs) == 0 {
+			continue
+		}
+		entry := *e
+		entry.Addrs = append([]net.IPAddr(nil), addrs...)
+		key := entryKey(entry)
+		if b.reported[inst] != key {
+			b.reported[inst] = key
+			ready = append(ready, entry)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, e := range ready {
+		b.found(e)
+	}
+}
+
+// addHostAddr guarda um endereço do host sem repetir
+func (b *Browser) addHostAddr(host string, addr net.IPAddr) {
+	for _, a := range b.hosts[host] {
+		if a.IP.Equal(addr.IP) && a.Zone == addr.Zone {
+			return
+		}
+	}
+	b.hosts[host] = append(b.hosts[host], addr)
+}
+
+// instanceLabel extrai o nome da instância de "alice._magician._tcp.local."
+func instanceLabel(full, svcName string) string {
+	if len(full) > len(svcName) && strings.EqualFold(full[len(full)-len(svcName):], svcName) {
+		return strings.TrimSuffix(full[:len(full)-len(svcName)], ".")
+	}
+	return full
+}
+
+// parseTXT converte os registros "chave=valor" em mapa
+func parseTXT(records []string) map[string]string {
+	text := make(map[string]string)
+	for _, record := range records {
+		if record == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(record, "=")
+		text[strings.ToLower(k)] = v
+	}
+	return text
+}
+
+// entryKey resume uma entrada para detectar mudanças
+func entryKey(e Entry) string {
+	var parts []string
+	for _, a := range e.Addrs {
+		parts = append(parts, a.String())
+	}
+	for k, v := range e.Text {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%s:%d|%s", e.Host, e.Port, strings.Join(parts, ","))
+}
@@ -0,0 +1,226 @@
+// Package mdns implementa o necessário de mDNS (RFC 6762) e DNS-SD
+// (RFC 6763) para anunciar um serviço e descobrir outros na rede local,
+// sem servidor central e sem depender de broadcast.
+package mdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Port é a porta UDP do mDNS
+const Port = 5353
+
+// TTLs recomendados pela RFC 6762: registros ligados ao host expiram antes
+const (
+	hostTTL    = 120
+	serviceTTL = 4500
+
+	// Bit de "cache flush" na classe dos registros únicos
+	cacheFlush = 0x8000
+
+	maxPacketSize = 9000
+)
+
+var (
+	group4 = net.IPv4(224, 0, 0, 251)
+	group6 = net.ParseIP("ff02::fb")
+
+	// ErrNoInterface indica que nenhuma interface de rede suporta multicast
+	ErrNoInterface = errors.New("nenhuma interface de rede com multicast")
+)
+
+// Service descreve um serviço anunciado
+type Service struct {
+	Instance string            // Nome da instância (sem pontos), ex: "alice-3f2a"
+	Service  string            // Tipo do serviço, ex: "_magician._tcp"
+	Host     string            // Nome do host sem ".local"; derivado da instância se vazio
+	Port     int               // Porta onde o serviço escuta
+	Text     map[string]string // Registros TXT (chave=valor)
+}
+
+// Entry é um serviço encontrado na rede
+type Entry struct {
+	Instance string
+	Host     string
+	Port     int
+	Addrs    []net.IPAddr // Endereços IPv6 link-local vêm com a zona da interface
+	Text     map[string]string
+}
+
+// serviceName monta o nome completo do tipo de serviço: "_magician._tcp.local."
+func serviceName(service string) string {
+	return strings.TrimSuffix(service, ".") + ".local."
+}
+
+// instanceName monta o nome da instância: "alice._magician._tcp.local."
+func instanceName(instance, service string) string {
+	return instance + "." + serviceName(service)
+}
+
+// sameName compara nomes DNS sem diferenciar maiúsculas
+func sameName(a dnsmessage.Name, b string) bool {
+	return strings.EqualFold(a.String(), b)
+}
+
+// packet é uma mensagem recebida, com a interface por onde chegou
+type packet struct {
+	data    []byte
+	src     net.Addr
+	ifIndex int
+}
+
+// conn agrupa os sockets multicast IPv4 e IPv6 do mDNS
+type conn struct {
+	ifaces []net.Interface
+	udp4   *net.UDPConn
+	p4     *ipv4.PacketConn
+	udp6   *net.UDPConn
+	p6     *ipv6.PacketConn
+}
+
+// multicastInterfaces lista as interfaces ativas com multicast
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var result []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			result = append(result, iface)
+		}
+	}
+	return result
+}
+
+// listenMulticast abre um socket na porta do mDNS e entra no grupo em todas
+// as interfaces. Sockets multicast usam SO_REUSEADDR, então convivem com o
+// avahi ou o mDNSResponder do sistema.
+func listenMulticast(network string, group net.IP, ifaces []net.Interface) (*net.UDPConn, error) {
+	addr := &net.UDPAddr{IP: group, Port: Port}
+	conn, err := net.ListenMulticastUDP(network, nil, addr)
+	if err == nil {
+		return conn, nil
+	}
+	for i := range ifaces {
+		if conn, err = net.ListenMulticastUDP(network, &ifaces[i], addr); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// listen abre os sockets IPv4 e IPv6; basta um dos dois funcionar
+func listen() (*conn, error) {
+	c := &conn{ifaces: multicastInterfaces()}
+	if len(c.ifaces) == 0 {
+		return nil, ErrNoInterface
+	}
+
+	udp4, err4 := listenMulticast("udp4", group4, c.ifaces)
+	if err4 == nil {
+		c.udp4 = udp4
+		c.p4 = ipv4.NewPacketConn(udp4)
+		for i := range c.ifaces {
+			c.p4.JoinGroup(&c.ifaces[i], &net.UDPAddr{IP: group4})
+		}
+		// Outros programas na mesma máquina também precisam nos ouvir
+		c.p4.SetMulticastLoopback(true)
+		c.p4.SetMulticastTTL(255)
+		c.p4.SetControlMessage(ipv4.FlagInterface, true)
+	}
+
+	udp6, err6 := listenMulticast("udp6", group6, c.ifaces)
+	if err6 == nil {
+		c.udp6 = udp6
+		c.p6 = ipv6.NewPacketConn(udp6)
+		for i := range c.ifaces {
+			c.p6.JoinGroup(&c.ifaces[i], &net.UDPAddr{IP: group6})
+		}
+		c.p6.SetMulticastLoopback(true)
+		c.p6.SetMulticastHopLimit(255)
+		c.p6.SetControlMessage(ipv6.FlagInterface, true)
+	}
+
+	if err4 != nil && err6 != nil {
+		return nil, err4
+	}
+	return c, nil
+}
+
+// send envia a mensagem ao grupo multicast pela interface informada, ou por
+// todas quando ifIndex é 0
+func (c *conn) send(msg []byte, ifIndex int) {
+	for _, iface := range c.ifaces {
+		if ifIndex != 0 && iface.Index != ifIndex {
+			continue
+		}
+		if c.p4 != nil {
+			c.p4.WriteTo(msg, &ipv4.ControlMessage{IfIndex: iface.Index}, &net.UDPAddr{IP: group4, Port: Port})
+		}
+		if c.p6 != nil {
+			c.p6.WriteTo(msg, &ipv6.ControlMessage{IfIndex: iface.Index}, &net.UDPAddr{IP: group6, Port: Port, Zone: iface.Name})
+		}
+	}
+}
+
+// receive lê as mensagens dos dois sockets até o fechamento
+func (c *conn) receive(handle func(packet)) {
+	if c.p4 != nil {
+		go func() {
+			buf := make([]byte, maxPacketSize)
+			for {
+				n, cm, src, err := c.p4.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				p := packet{data: append([]byte(nil), buf[:n]...), src: src}
+				if cm != nil {
+					p.ifIndex = cm.IfIndex
+				}
+				handle(p)
+			}
+		}()
+	}
+	if c.p6 != nil {
+		go func() {
+			buf := make([]byte, maxPacketSize)
+			for {
+				n, cm, src, err := c.p6.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				p := packet{data: append([]byte(nil), buf[:n]...), src: src}
+				if cm != nil {
+					p.ifIndex = cm.IfIndex
+				}
+				handle(p)
+			}
+		}()
+	}
+}
+
+// interfaceName retorna o nome da interface pelo índice (zona IPv6)
+func (c *conn) interfaceName(index int) string {
+	for _, iface := range c.ifaces {
+		if iface.Index == index {
+			return iface.Name
+		}
+	}
+	return ""
+}
+
+func (c *conn) close() {
+	if c.udp4 != nil {
+		c.udp4.Close()
+	}
+	if c.udp6 != nil {
+		c.udp6.Close()
+	}
+}
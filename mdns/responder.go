@@ -0,0 +1,259 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Nome usado para enumerar os tipos de serviço da rede (RFC 6763, seção 9)
+const servicesEnumeration = "_services._dns-sd._udp.local."
+
+// Responder anuncia um serviço e responde às consultas por ele
+type Responder struct {
+	service  Service
+	svcName  string
+	instName string
+	hostName string
+	conn     *conn
+	done     chan struct{}
+
+	mu   sync.Mutex
+	text map[string]string
+}
+
+// Advertise começa a anunciar o serviço na rede local
+func Advertise(service Service) (*Responder, error) {
+	if service.Instance == "" || service.Service == "" || service.Port <= 0 {
+		return nil, fmt.Errorf("serviço mDNS incompleto")
+	}
+	if strings.Contains(service.Instance, ".") {
+		return nil, fmt.Errorf("nome de instância não pode conter pontos: %s", service.Instance)
+	}
+	if service.Host == "" {
+		service.Host = service.Instance
+	}
+
+	c, err := listen()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir socket mDNS: %v", err)
+	}
+
+	r := &Responder{
+		service:  service,
+		svcName:  serviceName(service.Service),
+		instName: instanceName(service.Instance, service.Service),
+		hostName: service.Host + ".local.",
+		conn:     c,
+		done:     make(chan struct{}),
+		text:     service.Text,
+	}
+	c.receive(r.handle)
+	go r.announce()
+	return r, nil
+}
+
+// Close envia o "goodbye" (TTL 0) e para de responder
+func (r *Responder) Close() {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	close(r.done)
+
+	for _, iface := range r.conn.ifaces {
+		if msg, err := r.response(iface.Index, 0); err == nil {
+			r.conn.send(msg, iface.Index)
+		}
+	}
+	r.conn.close()
+}
+
+// SetText troca os registros TXT e anuncia a mudança
+func (r *Responder) SetText(text map[string]string) {
+	r.mu.Lock()
+	r.text = text
+	r.mu.Unlock()
+
+	for _, iface := range r.conn.ifaces {
+		if msg, err := r.response(iface.Index, 1); err == nil {
+			r.conn.send(msg, iface.Index)
+		}
+	}
+}
+
+// announce envia anúncios não solicitados ao iniciar, como pede a RFC 6762
+func (r *Responder) announce() {
+	for i := 0; i < 3; i++ {
+		for _, iface := range r.conn.ifaces {
+			if msg, err := r.response(iface.Index, 1); err == nil {
+				r.conn.send(msg, iface.Index)
+			}
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Second << i):
+		}
+	}
+}
+
+// handle responde às perguntas sobre o nosso serviço
+func (r *Responder) handle(p packet) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(p.data)
+	if err != nil || header.Response {
+		return
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	answer := false
+	for _, q := range questions {
+		switch {
+		case sameName(q.Name, r.svcName) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			answer = true
+		case sameName(q.Name, r.instName) || sameName(q.Name, r.hostName):
+			answer = true
+		case sameName(q.Name, servicesEnumeration) && q.Type == dnsmessage.TypePTR:
+			if msg, err := r.enumeration(); err == nil {
+				r.conn.send(msg, p.ifIndex)
+			}
+		}
+	}
+	if !answer {
+		return
+	}
+
+	if msg, err := r.response(p.ifIndex, 1); err == nil {
+		r.conn.send(msg, p.ifIndex)
+	}
+}
+
+// response monta a resposta completa: PTR, SRV, TXT e os endereços da
+// interface. ttlScale 0 produz o "goodbye".
+func (r *Responder) response(ifIndex int, ttlScale uint32) ([]byte, error) {
+	svc, err := dnsmessage.NewName(r.svcName)
+	if err != nil {
+		return nil, err
+	}
+	inst, err := dnsmessage.NewName(r.instName)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(r.hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	shared := func(name dnsmessage.Name, ttl uint32) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl * ttlScale}
+	}
+	unique := func(name dnsmessage.Name, ttl uint32) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl * ttlScale}
+	}
+
+	if err := b.PTRResource(shared(svc, serviceTTL), dnsmessage.PTRResource{PTR: inst}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(unique(inst, hostTTL), dnsmessage.SRVResource{Port: uint16(r.service.Port), Target: host}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(unique(inst, serviceTTL), dnsmessage.TXTResource{TXT: r.txt()}); err != nil {
+		return nil, err
+	}
+
+	for _, ip := range interfaceAddrs(ifIndex) {
+		if ip4 := ip.To4(); ip4 != nil {
+			var a [4]byte
+			copy(a[:], ip4)
+			err = b.AResource(unique(host, hostTTL), dnsmessage.AResource{A: a})
+		} else {
+			var a [16]byte
+			copy(a[:], ip)
+			err = b.AAAAResource(unique(host, hostTTL), dnsmessage.AAAAResource{AAAA: a})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// enumeration responde à listagem de tipos de serviço
+func (r *Responder) enumeration() ([]byte, error) {
+	name, err := dnsmessage.NewName(servicesEnumeration)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := dnsmessage.NewName(r.svcName)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: serviceTTL}
+	if err := b.PTRResource(hdr, dnsmessage.PTRResource{PTR: svc}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// txt monta os registros TXT em ordem estável
+func (r *Responder) txt() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.text) == 0 {
+		return []string{""}
+	}
+	var records []string
+	for k, v := range r.text {
+		records = append(records, k+"="+v)
+	}
+	sort.Strings(records)
+	return records
+}
+
+// interfaceAddrs retorna os endereços da interface (ou de todas, se 0)
+func interfaceAddrs(ifIndex int) []net.IP {
+	var ifaces []net.Interface
+	if ifIndex != 0 {
+		if iface, err := net.InterfaceByIndex(ifIndex); err == nil {
+			ifaces = append(ifaces, *iface)
+		}
+	} else {
+		ifaces = multicastInterfaces()
+	}
+
+	var ips []net.IP
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,328 @@
+// Package mux multiplexa vários fluxos lógicos sobre uma única conexão, no
+// estilo do yamux: cada fluxo tem controle de fluxo próprio e uma prioridade
+// que decide qual quadro é escrito primeiro na conexão compartilhada.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Formato do cabeçalho (12 bytes):
+//
+//	tipo(1) flags(1) prioridade(1) reservado(1) id(4) tamanho(4)
+//
+// Em quadros de janela o campo tamanho é o incremento e não há payload.
+const (
+	headerSize = 12
+
+	typeData   = 0
+	typeWindow = 1
+
+	flagSYN = 1 << 0
+	flagFIN = 1 << 1
+	flagRST = 1 << 2
+)
+
+const (
+	// NumPriorities é o número de níveis de prioridade; 0 é o mais urgente
+	NumPriorities = 3
+
+	// MaxFrameSize limita o payload de cada quadro, para que um fluxo
+	// prioritário espere no máximo um quadro de outro fluxo
+	MaxFrameSize = 16 * 1024
+
+	// InitialWindow é quanto cada lado pode enviar antes de uma atualização de janela
+	InitialWindow = 256 * 1024
+
+	queueSize     = 16
+	acceptBacklog = 64
+)
+
+var (
+	// ErrSessionClosed é retornado por operações em uma sessão encerrada
+	ErrSessionClosed = errors.New("sessão multiplexada encerrada")
+	// ErrStreamClosed é retornado ao escrever em um fluxo já fechado
+	ErrStreamClosed = errors.New("fluxo fechado")
+	// ErrStreamReset é retornado quando o outro lado abortou o fluxo
+	ErrStreamReset = errors.New("fluxo abortado pelo peer")
+)
+
+type frame struct {
+	typ      byte
+	flags    byte
+	priority byte
+	id       uint32
+	length   uint32
+	payload  []byte
+}
+
+// Session multiplexa fluxos sobre uma conexão
+type Session struct {
+	conn   io.ReadWriteCloser
+	client bool
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+
+	accept chan *Stream
+	queues [NumPriorities]chan frame
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// NewSession inicia a sessão sobre conn. Os dois lados precisam usar valores
+// opostos de client, para que os IDs dos fluxos abertos não colidam.
+func NewSession(conn io.ReadWriteCloser, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		client:  client,
+		streams: make(map[uint32]*Stream),
+		accept:  make(chan *Stream, acceptBacklog),
+		done:    make(chan struct{}),
+	}
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan frame, queueSize)
+	}
+
+	go s.recvLoop()
+	go s.sendLoop()
+	return s
+}
+
+// Open abre um novo fluxo com a prioridade informada
+func (s *Session) Open(priority int) (*Stream, error) {
+	if priority < 0 {
+		priority = 0
+	}
+	if priority >= NumPriorities {
+		priority = NumPriorities - 1
+	}
+
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id, byte(priority))
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	// O SYN avisa o outro lado mesmo antes de qualquer dado
+	if err := s.enqueue(frame{typ: typeData, flags: flagSYN, priority: st.priority, id: id}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept aguarda o próximo fluxo aberto pelo outro lado
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.closeErr()
+	}
+}
+
+// Close encerra a sessão e todos os seus fluxos
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+// Done é fechado quando a sessão termina
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+
+		close(s.done)
+		s.conn.Close()
+		for _, st := range streams {
+			st.wake()
+		}
+	})
+}
+
+// enqueue coloca um quadro na fila da sua prioridade
+func (s *Session) enqueue(f frame) error {
+	select {
+	case s.queues[f.priority] <- f:
+		return nil
+	case <-s.done:
+		return ErrSessionClosed
+	}
+}
+
+// sendLoop escreve os quadros na conexão, sempre da fila mais prioritária
+func (s *Session) sendLoop() {
+	buf := make([]byte, headerSize+MaxFrameSize)
+	for {
+		f, ok := s.next()
+		if !ok {
+			return
+		}
+
+		buf[0] = f.typ
+		buf[1] = f.flags
+		buf[2] = f.priority
+		buf[3] = 0
+		binary.BigEndian.PutUint32(buf[4:8], f.id)
+		binary.BigEndian.PutUint32(buf[8:12], f.length)
+		n := copy(buf[headerSize:], f.payload)
+
+		if _, err := s.conn.Write(buf[:headerSize+n]); err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+// next retorna o próximo quadro a enviar respeitando as prioridades
+func (s *Session) next() (frame, bool) {
+	for _, q := range s.queues {
+		select {
+		case f := <-q:
+			return f, true
+		default:
+		}
+	}
+
+	// Nenhum quadro pronto: espera qualquer fila
+	select {
+	case f := <-s.queues[0]:
+		return f, true
+	case f := <-s.queues[1]:
+		return f, true
+	case f := <-s.queues[2]:
+		return f, true
+	case <-s.done:
+		return frame{}, false
+	}
+}
+
+// recvLoop lê os quadros da conexão e os entrega aos fluxos
+func (s *Session) recvLoop() {
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.closeWithError(err)
+			return
+		}
+
+		f := frame{
+			typ:      header[0],
+			flags:    header[1],
+			priority: header[2],
+			id:       binary.BigEndian.Uint32(header[4:8]),
+			length:   binary.BigEndian.Uint32(header[8:12]),
+		}
+		if f.priority >= NumPriorities {
+			f.priority = NumPriorities - 1
+		}
+
+		var err error
+		switch f.typ {
+		case typeData:
+			err = s.handleData(f)
+		case typeWindow:
+			s.handleWindow(f)
+		default:
+			err = fmt.Errorf("tipo de quadro desconhecido: %d", f.typ)
+		}
+		if err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleData(f frame) error {
+	if f.length > MaxFrameSize {
+		return fmt.Errorf("quadro grande demais: %d bytes", f.length)
+	}
+	payload := make([]byte, f.length)
+	if _, err := io.ReadFull(s.conn, payload); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	st, ok := s.streams[f.id]
+	if !ok && f.flags&flagSYN != 0 {
+		// IDs abertos pelo outro lado têm a paridade oposta à nossa
+		if (f.id%2 == 1) == s.client {
+			s.mu.Unlock()
+			return fmt.Errorf("ID de fluxo inválido: %d", f.id)
+		}
+		st = newStream(s, f.id, f.priority)
+		select {
+		case s.accept <- st:
+			s.streams[f.id] = st
+			ok = true
+		default:
+			// Fila de aceitação cheia: recusa o fluxo
+			s.mu.Unlock()
+			go s.enqueue(frame{typ: typeData, flags: flagRST, id: f.id})
+			return nil
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		// Fluxo já encerrado deste lado; descarta
+		return nil
+	}
+	return st.receive(payload, f.flags)
+}
+
+func (s *Session) handleWindow(f frame) {
+	s.mu.Lock()
+	st, ok := s.streams[f.id]
+	s.mu.Unlock()
+	if ok {
+		st.grow(f.length)
+	}
+}
+
+// forget remove um fluxo encerrado nos dois sentidos
+func (s *Session) forget(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
@@ -0,0 +1,264 @@
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream é um fluxo lógico bidirecional dentro de uma sessão
+type Stream struct {
+	id       uint32
+	priority byte
+	session  *Session
+
+	writeMu sync.Mutex // Mantém cada Write contíguo no fluxo
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	recvBuf    bytes.Buffer
+	recvWindow uint32 // Quanto o outro lado ainda pode nos enviar
+	consumed   uint32 // Lido desde a última atualização de janela
+	sendWindow uint32 // Quanto ainda podemos enviar
+	remoteFIN  bool
+	localFIN   bool
+	reset      bool
+
+	// Prazos como em net.Conn; o timer acorda quem estiver esperando
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
+}
+
+func newStream(s *Session, id uint32, priority byte) *Stream {
+	st := &Stream{
+		id:         id,
+		priority:   priority,
+		session:    s,
+		recvWindow: InitialWindow,
+		sendWindow: InitialWindow,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// ID retorna o identificador do fluxo na sessão
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Priority retorna a prioridade do fluxo (0 é a mais alta)
+func (st *Stream) Priority() int {
+	return int(st.priority)
+}
+
+// Read lê os dados recebidos, bloqueando até haver dados ou o fluxo terminar
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.recvBuf.Len() == 0 {
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteFIN:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case st.session.isClosed():
+			st.mu.Unlock()
+			return 0, ErrSessionClosed
+		case expired(st.readDeadline):
+			st.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		st.cond.Wait()
+	}
+
+	n, _ := st.recvBuf.Read(p)
+	st.consumed += uint32(n)
+
+	// Devolve a janela quando metade dela já foi consumida
+	var update uint32
+	if st.consumed >= InitialWindow/2 {
+		update = st.consumed
+		st.recvWindow += update
+		st.consumed = 0
+	}
+	st.mu.Unlock()
+
+	if update > 0 {
+		// Atualizações de janela passam à frente de qualquer dado
+		st.session.enqueue(frame{typ: typeWindow, id: st.id, length: update})
+	}
+	return n, nil
+}
+
+// Write envia p no fluxo, respeitando a janela concedida pelo outro lado
+func (st *Stream) Write(p []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && !st.reset && !st.localFIN && !st.session.isClosed() && !expired(st.writeDeadline) {
+			st.cond.Wait()
+		}
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return written, ErrStreamReset
+		case st.localFIN:
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		case st.session.isClosed():
+			st.mu.Unlock()
+			return written, ErrSessionClosed
+		case expired(st.writeDeadline):
+			st.mu.Unlock()
+			return written, os.ErrDeadlineExceeded
+		}
+
+		n := uint32(len(p))
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		if n > MaxFrameSize {
+			n = MaxFrameSize
+		}
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		payload := make([]byte, n)
+		copy(payload, p[:n])
+		err := st.session.enqueue(frame{typ: typeData, priority: st.priority, id: st.id, length: n, payload: payload})
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close encerra o envio neste fluxo; o outro lado recebe EOF depois dos dados pendentes
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localFIN || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localFIN = true
+	finished := st.remoteFIN
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	// Só é enfileirado após o último Write, pois usa a mesma fila de prioridade
+	st.writeMu.Lock()
+	err := st.session.enqueue(frame{typ: typeData, flags: flagFIN, priority: st.priority, id: st.id})
+	st.writeMu.Unlock()
+
+	if finished {
+		st.session.forget(st.id)
+	}
+	return err
+}
+
+// Reset aborta o fluxo nos dois sentidos
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	st.reset = true
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	st.session.forget(st.id)
+	return st.session.enqueue(frame{typ: typeData, flags: flagRST, priority: st.priority, id: st.id})
+}
+
+// SetDeadline define os prazos de leitura e escrita, como em net.Conn
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline faz Read retornar os.ErrDeadlineExceeded a partir de t;
+// o valor zero remove o prazo
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.readDeadline = t
+	st.readTimer = st.armDeadline(st.readTimer, t)
+	return nil
+}
+
+// SetWriteDeadline faz Write retornar os.ErrDeadlineExceeded a partir de t,
+// se ainda estiver esperando janela; o valor zero remove o prazo
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writeDeadline = t
+	st.writeTimer = st.armDeadline(st.writeTimer, t)
+	return nil
+}
+
+// armDeadline troca o timer de um prazo. Deve ser chamada com st.mu travado.
+func (st *Stream) armDeadline(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	if !t.IsZero() {
+		timer = time.AfterFunc(time.Until(t), st.wake)
+	}
+	// Quem já espera reavalia o novo prazo
+	st.cond.Broadcast()
+	return timer
+}
+
+// expired indica se um prazo definido já passou
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// receive trata um quadro de dados vindo do outro lado
+func (st *Stream) receive(payload []byte, flags byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if uint32(len(payload)) > st.recvWindow {
+		return fmt.Errorf("fluxo %d excedeu a janela de recebimento", st.id)
+	}
+	st.recvWindow -= uint32(len(payload))
+	st.recvBuf.Write(payload)
+
+	if flags&flagRST != 0 {
+		st.reset = true
+		st.session.forget(st.id)
+	}
+	if flags&flagFIN != 0 {
+		st.remoteFIN = true
+		if st.localFIN {
+			st.session.forget(st.id)
+		}
+	}
+	st.cond.Broadcast()
+	return nil
+}
+
+// grow aumenta a janela de envio após uma atualização do outro lado
+func (st *Stream) grow(n uint32) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// wake acorda quem estiver bloqueado quando a sessão termina ou um prazo vence
+func (st *Stream) wake() {
+	st.mu.Lock()
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
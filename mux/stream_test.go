@@ -0,0 +1,88 @@
+package mux
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func sessionPair(t *testing.T) (client, server *Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	client, server = NewSession(a, true), NewSession(b, false)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, _ := sessionPair(t)
+	st, err := client.Open(0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Prazo já vencido falha na hora
+	st.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("esperava prazo vencido, veio %v", err)
+	}
+
+	// Prazo futuro acorda o Read bloqueado
+	st.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err = st.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("esperava prazo vencido, veio %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Read retornou em %s", elapsed)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("o erro deveria ser um timeout de net.Error: %v", err)
+	}
+}
+
+func TestDeadlineClearedAllowsRead(t *testing.T) {
+	client, server := sessionPair(t)
+	st, err := client.Open(0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	go st.Write([]byte("x"))
+	remote, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	remote.SetReadDeadline(time.Now().Add(-time.Second))
+	remote.SetReadDeadline(time.Time{})
+	buf := make([]byte, 1)
+	if n, err := remote.Read(buf); err != nil || n != 1 || buf[0] != 'x' {
+		t.Fatalf("Read após remover o prazo: %d %v", n, err)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	client, server := sessionPair(t)
+	st, err := client.Open(0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// O outro lado nunca lê: a janela se esgota e o Write espera até o prazo
+	go server.Accept()
+	st.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := st.Write(make([]byte, 2*InitialWindow))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("esperava prazo vencido, veio %v", err)
+	}
+	if n != InitialWindow {
+		t.Errorf("escreveu %d bytes, esperava a janela inteira (%d)", n, InitialWindow)
+	}
+}
@@ -0,0 +1,185 @@
+package nat
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeIGD é um gateway UPnP mínimo que roda localmente, para testar o
+// mapeamento de portas sem roteador. Use NewUPnP(f.Location()) para falar com ele.
+type FakeIGD struct {
+	External net.IP
+
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	mappings map[string]string // "TCP/9000" → cliente interno "ip:porta"
+	leases   map[string]string // "TCP/9000" → NewLeaseDuration do último AddPortMapping
+	calls    map[string]int    // Ações recebidas
+	failing  bool
+}
+
+const fakeServiceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// NewFakeIGD inicia o gateway falso em uma porta local livre
+func NewFakeIGD(external net.IP) (*FakeIGD, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FakeIGD{
+		External: external,
+		listener: ln,
+		mappings: make(map[string]string),
+		leases:   make(map[string]string),
+		calls:    make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", f.serveDescription)
+	mux.HandleFunc("/ctl", f.serveControl)
+	f.server = &http.Server{Handler: mux}
+	go f.server.Serve(ln)
+	return f, nil
+}
+
+// Location é o endereço da descrição do dispositivo
+func (f *FakeIGD) Location() string {
+	return "http://" + f.listener.Addr().String() + "/desc.xml"
+}
+
+// Mappings retorna uma cópia dos mapeamentos ativos
+func (f *FakeIGD) Mappings() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := make(map[string]string, len(f.mappings))
+	for k, v := range f.mappings {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Lease retorna a duração (em segundos) pedida no último AddPortMapping do mapeamento
+func (f *FakeIGD) Lease(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leases[key]
+}
+
+// Calls conta quantas vezes a ação foi recebida
+func (f *FakeIGD) Calls(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[action]
+}
+
+// SetFailing faz o gateway recusar todas as ações, como um roteador que
+// reiniciou ou desligou o UPnP
+func (f *FakeIGD) SetFailing(failing bool) {
+	f.mu.Lock()
+	f.failing = failing
+	f.mu.Unlock()
+}
+
+// Close encerra o gateway falso
+func (f *FakeIGD) Close() error {
+	return f.server.Close()
+}
+
+func (f *FakeIGD) serveDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>%s</serviceType>
+                <controlURL>/ctl</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`, fakeServiceType)
+}
+
+func (f *FakeIGD) serveControl(w http.ResponseWriter, r *http.Request) {
+	action := r.Header.Get("SOAPAction")
+	action = strings.Trim(action, `"`)
+	if i := strings.Index(action, "#"); i >= 0 {
+		action = action[i+1:]
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, "SOAP inválido", http.StatusBadRequest)
+		return
+	}
+	args := parseSOAPArgs(body)
+
+	response := ""
+	f.mu.Lock()
+	f.calls[action]++
+	if f.failing {
+		f.mu.Unlock()
+		http.Error(w, "falha simulada", http.StatusInternalServerError)
+		return
+	}
+	switch action {
+	case "AddPortMapping":
+		key := args["NewProtocol"] + "/" + args["NewExternalPort"]
+		f.mappings[key] = args["NewInternalClient"] + ":" + args["NewInternalPort"]
+		f.leases[key] = args["NewLeaseDuration"]
+	case "DeletePortMapping":
+		delete(f.mappings, args["NewProtocol"]+"/"+args["NewExternalPort"])
+	case "GetExternalIPAddress":
+		response = "<NewExternalIPAddress>" + f.External.String() + "</NewExternalIPAddress>"
+	default:
+		f.mu.Unlock()
+		http.Error(w, "ação desconhecida", http.StatusInternalServerError)
+		return
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, fakeServiceType, response, action)
+}
+
+// parseSOAPArgs lê os argumentos simples (<Nome>valor</Nome>) do envelope
+func parseSOAPArgs(body []byte) map[string]string {
+	args := make(map[string]string)
+	dec := xml.NewDecoder(strings.NewReader(string(body)))
+	var name string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return args
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.CharData:
+			if name != "" {
+				args[name] = string(t)
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
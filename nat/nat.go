@@ -0,0 +1,178 @@
+// Package nat mapeia portas no roteador para que peers atrás de NAT possam
+// receber conexões, usando UPnP IGD, PCP ou NAT-PMP, o que estiver disponível.
+package nat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mapper cria mapeamentos de porta em um gateway
+type Mapper interface {
+	// Name identifica o protocolo ("UPnP", "PCP", "NAT-PMP")
+	Name() string
+	// AddMapping mapeia a porta interna e retorna a porta externa concedida
+	AddMapping(protocol string, internalPort, externalPort int, lifetime time.Duration) (int, error)
+	// DeleteMapping remove um mapeamento criado antes
+	DeleteMapping(protocol string, internalPort, externalPort int) error
+	// ExternalIP retorna o endereço público do gateway
+	ExternalIP() (net.IP, error)
+}
+
+// ErrNoGateway indica que nenhum protocolo de mapeamento respondeu
+var ErrNoGateway = errors.New("nenhum gateway com UPnP, PCP ou NAT-PMP encontrado")
+
+// Discover procura um gateway, tentando UPnP, PCP e NAT-PMP nessa ordem
+func Discover(timeout time.Duration) (Mapper, error) {
+	if m, err := DiscoverUPnP(timeout); err == nil {
+		return m, nil
+	}
+
+	gateway, err := DefaultGateway()
+	if err != nil {
+		return nil, ErrNoGateway
+	}
+	if m, err := NewPCP(gateway); err == nil {
+		return m, nil
+	}
+	if m, err := NewNATPMP(gateway); err == nil {
+		return m, nil
+	}
+	return nil, ErrNoGateway
+}
+
+// DefaultGateway lê o gateway padrão IPv4 da tabela de rotas do Linux e, na
+// falta dela, supõe o primeiro endereço da rede local (x.y.z.1)
+func DefaultGateway() (net.IP, error) {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			raw, err := hex.DecodeString(fields[2])
+			if err != nil || len(raw) != 4 {
+				continue
+			}
+			// A tabela guarda o endereço em ordem little-endian
+			ip := make(net.IP, 4)
+			binary.LittleEndian.PutUint32(ip, binary.BigEndian.Uint32(raw))
+			return ip, nil
+		}
+	}
+
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, fmt.Errorf("gateway desconhecido: %v", err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if local == nil {
+		return nil, fmt.Errorf("gateway desconhecido")
+	}
+	return net.IPv4(local[0], local[1], local[2], 1), nil
+}
+
+// localIPFor retorna o IP local usado para alcançar o host informado
+func localIPFor(host string) (net.IP, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Mapping é um mapeamento ativo, renovado em segundo plano até Close
+type Mapping struct {
+	Mapper       Mapper
+	Protocol     string
+	InternalPort int
+
+	mu           sync.Mutex
+	externalPort int
+	lastError    error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Map cria o mapeamento e o renova na metade de cada concessão
+func Map(m Mapper, protocol string, port int, lifetime time.Duration) (*Mapping, error) {
+	external, err := m.AddMapping(protocol, port, port, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := &Mapping{
+		Mapper:       m,
+		Protocol:     protocol,
+		InternalPort: port,
+		externalPort: external,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go mp.renew(lifetime)
+	return mp, nil
+}
+
+// ExternalPort retorna a porta externa atual
+func (mp *Mapping) ExternalPort() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.externalPort
+}
+
+// Err retorna o erro da última renovação, se houver
+func (mp *Mapping) Err() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.lastError
+}
+
+// Intervalo mínimo entre renovações, para não inundar o gateway com
+// concessões curtas (variável para os testes)
+var minRenewInterval = 30 * time.Second
+
+func (mp *Mapping) renew(lifetime time.Duration) {
+	defer close(mp.done)
+
+	interval := lifetime / 2
+	if interval < minRenewInterval {
+		interval = minRenewInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mp.stop:
+			return
+		case <-ticker.C:
+			external, err := mp.Mapper.AddMapping(mp.Protocol, mp.InternalPort, mp.ExternalPort(), lifetime)
+			mp.mu.Lock()
+			mp.lastError = err
+			if err == nil {
+				mp.externalPort = external
+			}
+			mp.mu.Unlock()
+		}
+	}
+}
+
+// Close para a renovação e remove o mapeamento do gateway
+func (mp *Mapping) Close() error {
+	close(mp.stop)
+	<-mp.done
+	return mp.Mapper.DeleteMapping(mp.Protocol, mp.InternalPort, mp.ExternalPort())
+}
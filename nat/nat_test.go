@@ -0,0 +1,139 @@
+package nat
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGateway sobe um IGD falso e o cliente UPnP apontado para ele
+func fakeGateway(t *testing.T) (*FakeIGD, *UPnP) {
+	t.Helper()
+	igd, err := NewFakeIGD(net.ParseIP("203.0.113.7"))
+	if err != nil {
+		t.Fatalf("NewFakeIGD: %v", err)
+	}
+	t.Cleanup(func() { igd.Close() })
+
+	u, err := NewUPnP(igd.Location())
+	if err != nil {
+		t.Fatalf("NewUPnP: %v", err)
+	}
+	return igd, u
+}
+
+// waitFor espera a condição por até dois segundos
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("tempo esgotado esperando %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUPnPExternalIP(t *testing.T) {
+	_, u := fakeGateway(t)
+
+	ip, err := u.ExternalIP()
+	if err != nil {
+		t.Fatalf("ExternalIP: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("203.0.113.7")) {
+		t.Errorf("IP externo %s, esperava 203.0.113.7", ip)
+	}
+	if u.Name() != "UPnP" {
+		t.Errorf("nome %q", u.Name())
+	}
+}
+
+func TestMapAddsMapping(t *testing.T) {
+	igd, u := fakeGateway(t)
+
+	mp, err := Map(u, "tcp", 9000, time.Hour)
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	defer mp.Close()
+
+	if mp.ExternalPort() != 9000 {
+		t.Errorf("porta externa %d, esperava 9000", mp.ExternalPort())
+	}
+	client, ok := igd.Mappings()["TCP/9000"]
+	if !ok {
+		t.Fatalf("mapeamento ausente: %v", igd.Mappings())
+	}
+	if !strings.HasSuffix(client, ":9000") || strings.HasPrefix(client, ":") {
+		t.Errorf("cliente interno inesperado: %q", client)
+	}
+	if lease := igd.Lease("TCP/9000"); lease != "3600" {
+		t.Errorf("concessão de %s s, esperava 3600", lease)
+	}
+}
+
+func TestMappingRenews(t *testing.T) {
+	defer func(old time.Duration) { minRenewInterval = old }(minRenewInterval)
+	minRenewInterval = 0
+
+	igd, u := fakeGateway(t)
+	mp, err := Map(u, "udp", 9001, 40*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	defer mp.Close()
+
+	// Renova na metade da concessão, repetindo a mesma porta externa
+	waitFor(t, "renovações", func() bool { return igd.Calls("AddPortMapping") >= 3 })
+	if _, ok := igd.Mappings()["UDP/9001"]; !ok {
+		t.Errorf("mapeamento sumiu após renovar: %v", igd.Mappings())
+	}
+	if err := mp.Err(); err != nil {
+		t.Errorf("renovação falhou: %v", err)
+	}
+
+	// Uma renovação recusada fica visível em Err e a seguinte a limpa
+	igd.SetFailing(true)
+	waitFor(t, "erro de renovação", func() bool { return mp.Err() != nil })
+	igd.SetFailing(false)
+	waitFor(t, "renovação bem-sucedida", func() bool { return mp.Err() == nil })
+	if mp.ExternalPort() != 9001 {
+		t.Errorf("porta externa mudou para %d", mp.ExternalPort())
+	}
+}
+
+func TestMappingCloseDeletes(t *testing.T) {
+	igd, u := fakeGateway(t)
+
+	mp, err := Map(u, "tcp", 9002, time.Hour)
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	if _, ok := igd.Mappings()["TCP/9002"]; !ok {
+		t.Fatalf("mapeamento ausente antes de Close: %v", igd.Mappings())
+	}
+
+	if err := mp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, ok := igd.Mappings()["TCP/9002"]; ok {
+		t.Errorf("Close não removeu o mapeamento: %v", igd.Mappings())
+	}
+	if n := igd.Calls("DeletePortMapping"); n != 1 {
+		t.Errorf("DeletePortMapping chamado %d vezes", n)
+	}
+}
+
+func TestMapFailsWhenGatewayRefuses(t *testing.T) {
+	igd, u := fakeGateway(t)
+	igd.SetFailing(true)
+
+	if _, err := Map(u, "tcp", 9003, time.Hour); err == nil {
+		t.Fatal("Map deveria falhar com o gateway recusando")
+	}
+	if len(igd.Mappings()) != 0 {
+		t.Errorf("mapeamentos inesperados: %v", igd.Mappings())
+	}
+}
@@ -0,0 +1,140 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Porta dos servidores NAT-PMP e PCP no gateway
+const pmpPort = 5351
+
+// Tentativas com espera dobrando a partir de 250ms (RFC 6886)
+const pmpAttempts = 4
+
+// NATPMP fala NAT-PMP (RFC 6886) com o gateway
+type NATPMP struct {
+	gateway net.IP
+}
+
+// NewNATPMP confirma que o gateway responde NAT-PMP
+func NewNATPMP(gateway net.IP) (*NATPMP, error) {
+	m := &NATPMP{gateway: gateway}
+	if _, err := m.ExternalIP(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Name implementa Mapper
+func (m *NATPMP) Name() string {
+	return "NAT-PMP"
+}
+
+// pmpRequest envia a mensagem e espera a resposta com o opcode esperado.
+// Respostas curtas demais (como "versão não suportada") viram erro.
+func pmpRequest(gateway net.IP, msg []byte, expectOp byte, minSize int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gateway, Port: pmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	wait := 250 * time.Millisecond
+	buf := make([]byte, 1100)
+	for attempt := 0; attempt < pmpAttempts; attempt++ {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if n < 4 || buf[1] != expectOp {
+				continue
+			}
+			if n < minSize {
+				if buf[0] != msg[0] {
+					return nil, fmt.Errorf("gateway não suporta a versão %d do protocolo", msg[0])
+				}
+				return nil, fmt.Errorf("resposta curta do gateway (%d bytes)", n)
+			}
+			return buf[:n], nil
+		}
+		wait *= 2
+	}
+	return nil, fmt.Errorf("gateway %s não respondeu", gateway)
+}
+
+func pmpResultError(code uint16) error {
+	messages := map[uint16]string{
+		1: "versão não suportada",
+		2: "não autorizado",
+		3: "falha de rede",
+		4: "sem recursos",
+		5: "operação não suportada",
+	}
+	if msg, ok := messages[code]; ok {
+		return fmt.Errorf("NAT-PMP: %s", msg)
+	}
+	return fmt.Errorf("NAT-PMP: erro %d", code)
+}
+
+// ExternalIP implementa Mapper
+func (m *NATPMP) ExternalIP() (net.IP, error) {
+	resp, err := pmpRequest(m.gateway, []byte{0, 0}, 128, 12)
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return nil, pmpResultError(code)
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func pmpOpcode(protocol string) byte {
+	if strings.EqualFold(protocol, "udp") {
+		return 1
+	}
+	return 2
+}
+
+// AddMapping implementa Mapper
+func (m *NATPMP) AddMapping(protocol string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	op := pmpOpcode(protocol)
+	msg := make([]byte, 12)
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(msg[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(msg[8:12], uint32(lifetime.Seconds()))
+
+	resp, err := pmpRequest(m.gateway, msg, 128+op, 16)
+	if err != nil {
+		return 0, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return 0, pmpResultError(code)
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// DeleteMapping implementa Mapper: tempo de vida zero remove o mapeamento
+func (m *NATPMP) DeleteMapping(protocol string, internalPort, externalPort int) error {
+	op := pmpOpcode(protocol)
+	msg := make([]byte, 12)
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:6], uint16(internalPort))
+
+	resp, err := pmpRequest(m.gateway, msg, 128+op, 16)
+	if err != nil {
+		return err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return pmpResultError(code)
+	}
+	return nil
+}
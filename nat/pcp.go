@@ -0,0 +1,129 @@
+package nat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pcpVersion = 2
+	pcpOpMap   = 1
+)
+
+// PCP fala Port Control Protocol (RFC 6887), sucessor do NAT-PMP
+type PCP struct {
+	gateway net.IP
+	localIP net.IP
+
+	mu         sync.Mutex
+	nonces     map[string][]byte // Por protocolo/porta; a renovação precisa do mesmo nonce
+	externalIP net.IP
+}
+
+// NewPCP confirma que o gateway responde PCP com um mapeamento de teste curto
+func NewPCP(gateway net.IP) (*PCP, error) {
+	localIP, err := localIPFor(gateway.String())
+	if err != nil {
+		return nil, err
+	}
+	m := &PCP{gateway: gateway, localIP: localIP, nonces: make(map[string][]byte)}
+
+	// Servidores só NAT-PMP respondem "versão não suportada"
+	if _, err := m.mapRequest("udp", 9, 0, 0, make([]byte, 12)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Name implementa Mapper
+func (m *PCP) Name() string {
+	return "PCP"
+}
+
+func pcpProtocol(protocol string) byte {
+	if strings.EqualFold(protocol, "udp") {
+		return 17
+	}
+	return 6
+}
+
+// mapRequest envia um MAP e retorna a porta externa concedida
+func (m *PCP) mapRequest(protocol string, internalPort, externalPort int, lifetime time.Duration, nonce []byte) (int, error) {
+	msg := make([]byte, 60)
+	msg[0] = pcpVersion
+	msg[1] = pcpOpMap
+	binary.BigEndian.PutUint32(msg[4:8], uint32(lifetime.Seconds()))
+	copy(msg[8:24], m.localIP.To16())
+
+	copy(msg[24:36], nonce)
+	msg[36] = pcpProtocol(protocol)
+	binary.BigEndian.PutUint16(msg[40:42], uint16(internalPort))
+	binary.BigEndian.PutUint16(msg[42:44], uint16(externalPort))
+	copy(msg[44:60], net.IPv4zero.To16())
+
+	resp, err := pmpRequest(m.gateway, msg, 128+pcpOpMap, 60)
+	if err != nil {
+		return 0, err
+	}
+	if resp[0] != pcpVersion {
+		return 0, fmt.Errorf("gateway não suporta PCP")
+	}
+	if code := resp[3]; code != 0 {
+		return 0, fmt.Errorf("PCP: erro %d", code)
+	}
+
+	m.mu.Lock()
+	m.externalIP = net.IP(append([]byte{}, resp[44:60]...))
+	m.mu.Unlock()
+	return int(binary.BigEndian.Uint16(resp[42:44])), nil
+}
+
+func (m *PCP) nonce(protocol string, internalPort int) ([]byte, error) {
+	key := fmt.Sprintf("%s/%d", strings.ToLower(protocol), internalPort)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n, ok := m.nonces[key]; ok {
+		return n, nil
+	}
+	n := make([]byte, 12)
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	m.nonces[key] = n
+	return n, nil
+}
+
+// AddMapping implementa Mapper
+func (m *PCP) AddMapping(protocol string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	nonce, err := m.nonce(protocol, internalPort)
+	if err != nil {
+		return 0, err
+	}
+	return m.mapRequest(protocol, internalPort, externalPort, lifetime, nonce)
+}
+
+// DeleteMapping implementa Mapper: tempo de vida zero com o mesmo nonce
+func (m *PCP) DeleteMapping(protocol string, internalPort, externalPort int) error {
+	nonce, err := m.nonce(protocol, internalPort)
+	if err != nil {
+		return err
+	}
+	_, err = m.mapRequest(protocol, internalPort, 0, 0, nonce)
+	return err
+}
+
+// ExternalIP implementa Mapper: o PCP informa o IP externo junto com cada mapeamento
+func (m *PCP) ExternalIP() (net.IP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.externalIP == nil {
+		return nil, fmt.Errorf("PCP ainda não informou o IP externo")
+	}
+	return m.externalIP, nil
+}
@@ -0,0 +1,235 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr   = "239.255.255.250:1900"
+	igdService = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+)
+
+// UPnP fala com o serviço WANIPConnection (ou WANPPPConnection) de um IGD
+type UPnP struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	client      *http.Client
+}
+
+// DiscoverUPnP procura um IGD na rede local por SSDP
+func DiscoverUPnP(timeout time.Duration) (*UPnP, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: " + igdService + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("nenhum IGD respondeu: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		resp.Body.Close()
+		if location == "" {
+			continue
+		}
+		if u, err := NewUPnP(location); err == nil {
+			return u, nil
+		}
+	}
+}
+
+// NewUPnP usa diretamente a descrição do dispositivo no endereço informado
+// (útil para apontar para um IGD conhecido ou para o FakeIGD dos testes)
+func NewUPnP(location string) (*UPnP, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler descrição do IGD: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("descrição do IGD inválida: %w", err)
+	}
+
+	svc, ok := desc.Device.findWANService()
+	if !ok {
+		return nil, fmt.Errorf("IGD sem serviço WANIPConnection")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	control, err := base.Parse(svc.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	localIP, err := localIPFor(base.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	return &UPnP{
+		controlURL:  control.String(),
+		serviceType: svc.ServiceType,
+		localIP:     localIP,
+		client:      client,
+	}, nil
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+// findWANService procura o serviço de conexão WAN na árvore de dispositivos
+func (d upnpDevice) findWANService() (upnpService, bool) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s, true
+		}
+	}
+	for _, child := range d.Devices {
+		if s, ok := child.findWANService(); ok {
+			return s, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// Name implementa Mapper
+func (u *UPnP) Name() string {
+	return "UPnP"
+}
+
+// soap executa uma ação no serviço WAN e retorna a resposta bruta
+func (u *UPnP) soap(action string, args [][2]string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, u.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest(http.MethodPost, u.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, u.serviceType, action))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IGD recusou %s: %s", action, resp.Status)
+	}
+	return data, nil
+}
+
+// AddMapping implementa Mapper
+func (u *UPnP) AddMapping(protocol string, internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	_, err := u.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", u.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "Magician"},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime.Seconds()))},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return externalPort, nil
+}
+
+// DeleteMapping implementa Mapper
+func (u *UPnP) DeleteMapping(protocol string, internalPort, externalPort int) error {
+	_, err := u.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+	})
+	return err
+}
+
+// ExternalIP implementa Mapper
+func (u *UPnP) ExternalIP() (net.IP, error) {
+	data, err := u.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("resposta do IGD inválida: %w", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(env.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("IGD não informou o IP externo")
+	}
+	return ip, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/tor"
+)
+
+// Arquivo com a chave do serviço onion, para manter o mesmo endereço entre
+// execuções
+const onionKeyFile = "onion.key"
+
+// Serviço onion efêmero criado pela porta de controle. Ele só existe
+// enquanto onionControl estiver aberta.
+var (
+	onionService *tor.OnionService
+	onionControl *tor.Controller
+	onionMutex   sync.Mutex
+)
+
+// startOnionService publica a porta do chat como um serviço onion, sem
+// precisar editar o torrc
+func startOnionService(port string) {
+	virtPort, err := strconv.Atoi(port)
+	if err != nil {
+		log.Printf("Serviço onion: porta inválida %q", port)
+		return
+	}
+
+	ctrl, err := tor.DialAuthenticated(nil, os.Getenv("MAGICIAN_TOR_PASSWORD"), torProbeTimeout)
+	if err != nil {
+		updateChatView(fmt.Sprintf("❌ Serviço onion indisponível: %v", err))
+		return
+	}
+
+	key, err := loadOnionKey()
+	if err != nil {
+		log.Printf("Chave onion ignorada: %v", err)
+	}
+	service, err := ctrl.AddOnion(key, virtPort, "127.0.0.1:"+port)
+	if err != nil {
+		ctrl.Close()
+		updateChatView(fmt.Sprintf("❌ %v", err))
+		return
+	}
+	if key == "" {
+		if err := os.WriteFile(onionKeyFile, []byte(service.PrivateKey+"\n"), 0600); err != nil {
+			log.Printf("Erro ao salvar chave onion: %v", err)
+		}
+	}
+
+	onionMutex.Lock()
+	onionService = service
+	onionControl = ctrl
+	onionMutex.Unlock()
+
+	updateChatView(fmt.Sprintf("🧅 Serviço onion ativo: %s:%d", service.Address(), service.Port))
+	logMessage(fmt.Sprintf("Serviço onion criado: %s", service.Address()))
+}
+
+// loadOnionKey lê a chave salva; vazio se ainda não existe
+func loadOnionKey() (string, error) {
+	data, err := os.ReadFile(onionKeyFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(key, "ED25519-V3:") {
+		return "", fmt.Errorf("formato desconhecido em %s", onionKeyFile)
+	}
+	return key, nil
+}
+
+// stopOnionService remove o serviço onion ao sair
+func stopOnionService() {
+	onionMutex.Lock()
+	defer onionMutex.Unlock()
+
+	if onionControl == nil {
+		return
+	}
+	onionControl.SetDeadline(time.Now().Add(torProbeTimeout))
+	if err := onionControl.DelOnion(onionService.ServiceID); err != nil {
+		log.Printf("%v", err)
+	}
+	onionControl.Close()
+	onionControl = nil
+	onionService = nil
+}
+
+// localOnionAddress é o endereço .onion deste peer: o serviço efêmero, se
+// foi criado, ou o configurado no torrc
+func localOnionAddress() string {
+	onionMutex.Lock()
+	service := onionService
+	onionMutex.Unlock()
+
+	if service != nil {
+		return service.Address()
+	}
+	return readOnionHostname()
+}
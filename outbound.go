@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+
+	"magician/mux"
+)
+
+// Prioridades dos fluxos abertos para um peer. Controle e chat sempre passam
+// à frente dos dados de arquivo na conexão compartilhada.
+const (
+	priorityControl = iota
+	priorityChat
+	priorityFile
+)
+
+// Fluxos padrão de cada peer, um por prioridade. Transferências usam fluxos
+// próprios, identificados pelo ID da transferência.
+var defaultStreams = [...]string{
+	priorityControl: "controle",
+	priorityChat:    "chat",
+	priorityFile:    "arquivos",
+}
+
+// peerConn é a sessão multiplexada com um peer e seus fluxos de saída
+type peerConn struct {
+	session *mux.Session
+	limit   *RateLimiter // Limite de download anunciado pelo peer
+	dialed  bool         // Conexão aberta por nós (o endereço aceita novas conexões)
+	id      string       // ID comprovado no handshake
+
+	mu      sync.Mutex
+	streams map[string]*mux.Stream
+}
+
+// Sessões dos peers conectados, protegidas por peersMutex
+var peerConns = make(map[string]*peerConn)
+
+// stream retorna o fluxo de saída com a chave informada, abrindo-o se preciso
+func (p *peerConn) stream(key string, priority int) (*mux.Stream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if st, ok := p.streams[key]; ok {
+		return st, nil
+	}
+	st, err := p.session.Open(priority)
+	if err != nil {
+		return nil, err
+	}
+	p.streams[key] = st
+	return st, nil
+}
+
+// bufferedConn reaproveita o reader usado na autenticação, que pode já
+// conter os primeiros quadros da sessão
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// registerPeer adiciona um peer autenticado e inicia a sessão multiplexada.
+// Quem discou é o cliente da sessão, para que os IDs dos fluxos não colidam.
+func registerPeer(addr, id string, conn net.Conn, reader *bufio.Reader, client bool) *mux.Session {
+	session := mux.NewSession(&bufferedConn{Conn: conn, reader: reader}, client)
+
+	peersMutex.Lock()
+	Peers[addr] = conn
+	peerAuthenticated[addr] = true // Marcar como autenticado
+	peerConns[addr] = &peerConn{
+		session: session,
+		limit:   NewRateLimiter(0),
+		dialed:  client,
+		id:      id,
+		streams: make(map[string]*mux.Stream),
+	}
+	peersMutex.Unlock()
+
+	return session
+}
+
+// peerDialed diz se a conexão com o peer foi aberta por nós
+func peerDialed(addr string) bool {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	p, ok := peerConns[addr]
+	return ok && p.dialed
+}
+
+// peerIdentity retorna o ID que o peer provou ter no handshake
+func peerIdentity(addr string) string {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	if p, ok := peerConns[addr]; ok {
+		return p.id
+	}
+	return ""
+}
+
+// removePeer remove o peer das tabelas e encerra sua sessão
+func removePeer(addr string) {
+	peersMutex.Lock()
+	delete(Peers, addr)
+	delete(peerAuthenticated, addr)
+	delete(peerInfos, addr)
+	p, ok := peerConns[addr]
+	delete(peerConns, addr)
+	peersMutex.Unlock()
+
+	if ok {
+		p.session.Close()
+	}
+}
+
+// peerSend envia uma linha no fluxo padrão da prioridade. Retorna false se o
+// peer não estiver conectado.
+func peerSend(peer string, priority int, line string) bool {
+	return peerSendOn(peer, defaultStreams[priority], priority, line)
+}
+
+// peerSendOn envia uma linha no fluxo identificado por key. Bloqueia enquanto
+// a janela do fluxo estiver esgotada, sem atrasar os demais fluxos do peer.
+func peerSendOn(peer string, key string, priority int, line string) bool {
+	peersMutex.Lock()
+	p, ok := peerConns[peer]
+	peersMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	st, err := p.stream(key, priority)
+	if err != nil {
+		return false
+	}
+
+	if priority == priorityFile {
+		// Dados de arquivo respeitam o limite global e o do peer
+		uploadLimiter.Wait(len(line))
+		p.limit.Wait(len(line))
+	}
+
+	if _, err := fmt.Fprintf(st, "%s\n", line); err != nil {
+		logMessage(fmt.Sprintf("Erro ao enviar para %s: %v", peer, err))
+		return false
+	}
+	return true
+}
+
+// closePeerStream encerra um fluxo de saída após o envio dos dados pendentes
+func closePeerStream(peer string, key string) {
+	peersMutex.Lock()
+	p, ok := peerConns[peer]
+	peersMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	st, ok := p.streams[key]
+	delete(p.streams, key)
+	p.mu.Unlock()
+
+	if ok {
+		st.Close()
+	}
+}
+
+// connectedPeers retorna as chaves dos peers conectados
+func connectedPeers() []string {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	peers := make([]string, 0, len(Peers))
+	for addr := range Peers {
+		peers = append(peers, addr)
+	}
+	return peers
+}
+
+// setPeerDownloadRate aplica o limite anunciado por um peer aos envios para ele
+func setPeerDownloadRate(peer string, rate int64) {
+	peersMutex.Lock()
+	p, ok := peerConns[peer]
+	peersMutex.Unlock()
+	if ok {
+		p.limit.SetRate(rate)
+	}
+}
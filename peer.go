@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"magician/tor"
+	"magician/mux"
+	"magician/transport"
 	"net"
 	"os"
 	"strings"
@@ -19,6 +22,97 @@ var peersMutex sync.Mutex
 // Mapa para controlar quais peers já passaram pela autenticação
 var peerAuthenticated = make(map[string]bool)
 
+// Fluxos recebidos de um mesmo peer sendo lidos ao mesmo tempo: os três
+// padrão mais as transferências em andamento
+const maxPeerStreams = 64
+
+// Funcionalidades opcionais suportadas por este peer, anunciadas no HELLO
+var localFeatures = []string{featureGzip, featureDHT}
+
+// Hello é trocado pelos dois lados logo após a autenticação
+type Hello struct {
+	Nickname    string
+	ID          string // ID da identidade do peer
+	Features    []string
+	MaxDownload int64 // Limite de download em bytes/s (0 = ilimitado)
+	UDPPort     int   // Porta UDP (QUIC e hole punching), 0 se indisponível
+}
+
+// PeerInfo guarda o que o peer anunciou no HELLO
+type PeerInfo struct {
+	Nickname string
+	ID       string
+	Features map[string]bool
+	UDPPort  int
+}
+
+// Informações dos peers conectados, protegidas por peersMutex
+var peerInfos = make(map[string]*PeerInfo)
+
+// sendHello anuncia nosso nickname e funcionalidades ao peer
+func sendHello(peer string) {
+	data, err := json.Marshal(Hello{
+		Nickname:    Nickname,
+		ID:          localIdentity.ID,
+		Features:    localFeatures,
+		MaxDownload: downloadLimiter.Rate(),
+		UDPPort:     quicTransport.LocalPort(),
+	})
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityControl, "[HELLO]"+string(data))
+}
+
+// handleHello registra as funcionalidades anunciadas por um peer
+func handleHello(remote string, data string) {
+	var hello Hello
+	if err := json.Unmarshal([]byte(data), &hello); err != nil {
+		logMessage(fmt.Sprintf("HELLO inválido de %s: %v", remote, err))
+		return
+	}
+
+	// O ID vale só se for o mesmo provado no handshake
+	verified := peerIdentity(remote)
+	if hello.ID != verified {
+		logMessage(fmt.Sprintf("HELLO de %s declara o ID %s, mas o handshake provou %s; desconectando", remote, hello.ID, verified))
+		updateChatView(fmt.Sprintf("⚠️ %s declarou um ID diferente do comprovado. Conexão encerrada.", remote))
+		removePeer(remote)
+		return
+	}
+
+	info := &PeerInfo{
+		Nickname: hello.Nickname,
+		ID:       verified,
+		Features: make(map[string]bool),
+		UDPPort:  hello.UDPPort,
+	}
+	for _, f := range hello.Features {
+		info.Features[f] = true
+	}
+
+	peersMutex.Lock()
+	peerInfos[remote] = info
+	peersMutex.Unlock()
+
+	addDHTContact(remote, info)
+
+	if hello.MaxDownload > 0 {
+		setPeerDownloadRate(remote, hello.MaxDownload)
+	}
+
+	logMessage(fmt.Sprintf("Peer %s (%s) suporta: %s", remote, hello.Nickname, strings.Join(hello.Features, ", ")))
+}
+
+// peerSupports indica se o peer anunciou uma funcionalidade
+func peerSupports(peer, feature string) bool {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	info, ok := peerInfos[peer]
+	return ok && info.Features[feature]
+}
+
 func loadTLSConfig() (*tls.Config, error) {
 	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
 	if err != nil {
@@ -46,23 +140,99 @@ func loadTLSConfig() (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
-func listenForPeers(port string) {
+// Transporte QUIC, que também leva os datagramas de hole punching
+var quicTransport *transport.QUICTransport
+
+// initTransports registra os transportes disponíveis para peers
+func initTransports() error {
 	tlsConfig, err := loadTLSConfig()
 	if err != nil {
-		log.Fatal("Erro ao configurar TLS:", err)
+		return fmt.Errorf("erro ao configurar TLS: %v", err)
 	}
 
-	ln, err := tls.Listen("tcp", ":"+port, tlsConfig)
+	// Configuração TLS para cliente
+	insecureTlsConfig := &tls.Config{
+		InsecureSkipVerify: true, // ⚠️ aceita certificado autossinado (apenas para dev)
+	}
+
+	transport.Register(transport.NewTCP(tlsConfig, insecureTlsConfig))
+	transport.Register(transport.NewTor(tlsConfig, insecureTlsConfig))
+	transport.Register(transport.NewWebSocket(true, tlsConfig, insecureTlsConfig))
+	transport.Register(transport.NewWebSocket(false, tlsConfig, insecureTlsConfig))
+	transport.Register(transport.NewRelay(localIdentity.Private, tlsConfig, insecureTlsConfig))
+
+	quicTransport = transport.NewQUIC(tlsConfig, insecureTlsConfig)
+	quicTransport.OnPunch(handlePunch)
+	transport.Register(quicTransport)
+	return nil
+}
+
+func listenForPeers(port string) {
+	t, ok := transport.Get("tcp")
+	if !ok {
+		log.Fatal("Transporte TCP não registrado")
+	}
+
+	// Sem host, o socket aceita IPv4 e IPv6 (dual stack). No modo somente
+	// Tor, só o serviço onion (local) alcança a porta.
+	host, desc := "", " (IPv4 e IPv6)"
+	if torOnly {
+		host, desc = "127.0.0.1", " (somente local, para o serviço onion)"
+	}
+	ln, err := t.Listen(net.JoinHostPort(host, port))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer ln.Close()
-	log.Println("Escutando em :", port)
-	updateChatView("Sistema: Escutando na porta " + port)
+	log.Println("Escutando em", net.JoinHostPort(host, port))
+	updateChatView("Sistema: Escutando na porta " + port + desc)
+
+	acceptPeers(ln)
+}
 
+// listenQUIC abre o socket UDP na mesma porta do TCP e aceita peers via QUIC.
+// O socket é aberto antes de retornar, para que a porta já vá no HELLO.
+func listenQUIC(port string) {
+	ln, err := quicTransport.Listen(":" + port)
+	if err != nil {
+		log.Println("Erro ao escutar via QUIC:", err)
+		return
+	}
+	log.Println("Escutando via QUIC (UDP) em :", port)
+
+	go acceptPeers(ln)
+}
+
+// listenWebSocket aceita peers também por WebSocket, em um endereço como
+// "wss://:8443/magician" (ou "ws://..." atrás de um proxy reverso com TLS)
+func listenWebSocket(spec string) {
+	t, addr, err := transport.ForAddress(spec)
+	if err != nil || (t.Name() != "ws" && t.Name() != "wss") {
+		updateChatView("Sistema: Endereço WebSocket inválido: " + spec)
+		return
+	}
+
+	ln, err := t.Listen(addr)
+	if err != nil {
+		log.Println("Erro ao escutar via WebSocket:", err)
+		updateChatView(fmt.Sprintf("Sistema: Erro ao escutar via WebSocket: %v", err))
+		return
+	}
+	defer ln.Close()
+	log.Println("Escutando via WebSocket em", spec)
+	updateChatView("Sistema: Escutando via WebSocket em " + spec)
+
+	acceptPeers(ln)
+}
+
+// acceptPeers trata as conexões recebidas por um listener de qualquer transporte
+func acceptPeers(ln net.Listener) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Println("Erro na conexão:", err)
 			continue
 		}
@@ -71,6 +241,14 @@ func listenForPeers(port string) {
 }
 
 func connectToPeer(address string) {
+	// Endereços IPv6 precisam de colchetes: [2001:db8::1]:9000
+	normalized, err := normalizePeerAddress(address)
+	if err != nil {
+		updateChatView("Sistema: " + err.Error())
+		return
+	}
+	address = normalized
+
 	// Evita conexões redundantes
 	peersMutex.Lock()
 	_, exists := Peers[address]
@@ -83,180 +261,202 @@ func connectToPeer(address string) {
 
 	updateChatView("Sistema: Tentando conectar a " + address)
 
-	// Carrega configuração segura
-	//tlsConfig, err := loadTLSConfig()
-	//if err != nil {
-	//	updateChatView(fmt.Sprintf("Erro TLS: %v", err))
-	//	return
-	//}
-
-	// Usar corretamente o tor.DialOrDirect e salvar o resultado
-	rawConn, err := tor.DialOrDirect(address)
+	// O transporte é escolhido pelo endereço (TCP, Tor, mem://...)
+	conn, err := transport.Dial(address)
 	if err != nil {
-		log.Printf("Erro ao conectar (via Tor): %v", err)
-		return
-	}
-
-	// Configuração TLS para cliente
-	insecureTlsConfig := &tls.Config{
-		InsecureSkipVerify: true, // ⚠️ aceita certificado autossinado (apenas para dev)
-	}
-
-	// Configurar conexão TLS
-	conn := tls.Client(rawConn, insecureTlsConfig)
-
-	// Verificação de erro após conectar
-	if err := conn.Handshake(); err != nil {
-		log.Println("Erro ao conectar. Tentando novamente em 5s...")
+		log.Printf("Erro ao conectar a %s: %v", address, err)
 		updateChatView("Sistema: Falha ao conectar a " + address + ". Tentando novamente em 5s...")
-		conn.Close()
 		time.Sleep(5 * time.Second)
 		return
 	}
 
-	// AQUI É A PARTE CRÍTICA: Envia a senha como um comando especial para identificação
-	fmt.Fprintf(conn, "AUTH %s\n", Password)
-
+	// Prova a senha sem enviá-la e confere que o outro lado também a conhece
 	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
+	peerID, err := clientHandshake(conn, reader)
 	if err != nil {
-		log.Printf("Erro ao ler resposta de autenticação: %v", err)
+		log.Printf("Autenticação com %s falhou: %v", address, err)
+		updateChatView(fmt.Sprintf("Sistema: Autenticação com %s falhou (%v). Conexão rejeitada.", address, err))
 		conn.Close()
 		return
 	}
 
-	if strings.TrimSpace(response) != "OK" {
-		log.Println("Senha incorreta. Conexão rejeitada.")
-		updateChatView("Sistema: Senha incorreta para " + address + ". Conexão rejeitada.")
+	if !checkContactFingerprint(address, peerID) {
 		conn.Close()
 		return
 	}
 
-	peersMutex.Lock()
-	Peers[address] = conn
-	peerAuthenticated[address] = true // Marcar como autenticado
-	peersMutex.Unlock()
-
+	session := registerPeer(address, peerID, conn, reader, true)
+	sendHello(address)
 	updateChatView("Sistema: Conectado com sucesso a " + address)
 
-	// Depois da autenticação, continuar com a rotina normal de tratamento
-	go handlePeerMessages(conn)
+	// Depois da autenticação, a conexão passa a transportar fluxos multiplexados
+	go servePeerSession(address, conn, session, false)
 }
 
 func handleConnection(conn net.Conn) {
 	remote := conn.RemoteAddr().String()
 
 	reader := bufio.NewReader(conn)
-	authenticated := false
 
-	for {
-		message, err := reader.ReadString('\n')
-		if err != nil {
-			log.Println("Peer desconectado:", remote)
-			updateChatView("Sistema: Peer desconectado: " + remote)
-			logMessage("Peer desconectado: " + remote)
+	message, err := reader.ReadString('\n')
+	if err != nil {
+		log.Println("Peer desconectado:", remote)
+		conn.Close()
+		return
+	}
 
-			peersMutex.Lock()
-			delete(Peers, remote)
-			delete(peerAuthenticated, remote)
-			peersMutex.Unlock()
+	trimmedMsg := strings.TrimSpace(message)
 
-			conn.Close()
-			return
-		}
+	// Consultas à DHT usam o mesmo listener, sem autenticação
+	if trimmedMsg == dhtHandshake {
+		serveDHT(conn, reader)
+		return
+	}
 
-		trimmedMsg := strings.TrimSpace(message)
+	// Se não for um comando de autenticação, rejeita
+	if !strings.HasPrefix(trimmedMsg, "AUTH ") {
+		fmt.Fprintln(conn, "DENIED")
+		fmt.Printf(">>> Tentativa de comunicação sem autenticação: %s\n", remote)
+		conn.Close()
+		return
+	}
 
-		// Verificar se é um comando de autenticação
-		if !authenticated && strings.HasPrefix(trimmedMsg, "AUTH ") {
-			// Extrair a senha da mensagem de autenticação
-			receivedPassword := strings.TrimPrefix(trimmedMsg, "AUTH ")
-			expectedPassword := Password
+	peerID, err := serverHandshake(conn, reader, trimmedMsg)
+	if err != nil {
+		fmt.Printf(">>> Autenticação recusada de %s: %v\n", remote, err)
+		conn.Close()
+		return
+	}
 
-			//fmt.Printf("DEBUG - Senha recebida: [%s] (len: %d)\n", receivedPassword, len(receivedPassword))
-			//fmt.Printf("DEBUG - Senha esperada: [%s] (len: %d)\n", expectedPassword, len(expectedPassword))
+	if !checkContactFingerprint(remote, peerID) {
+		conn.Close()
+		return
+	}
 
-			if receivedPassword != expectedPassword {
-				fmt.Fprintln(conn, "DENIED")
-				fmt.Printf(">>> Senha inválida de %s: [%s] (esperada: [%s])\n",
-					conn.RemoteAddr(), receivedPassword, expectedPassword)
-				conn.Close()
-				return
-			}
+	session := registerPeer(remote, peerID, conn, reader, false)
+	sendHello(remote)
 
-			// Senha correta
-			fmt.Fprintln(conn, "OK")
-			authenticated = true
+	updateChatView("Sistema: Novo peer conectado de " + remote)
+	logMessage("Novo peer conectado: " + remote)
 
-			peersMutex.Lock()
-			Peers[remote] = conn
-			peerAuthenticated[remote] = true
-			peersMutex.Unlock()
+	servePeerSession(remote, conn, session, true)
+}
 
-			updateChatView("Sistema: Novo peer conectado de " + remote)
-			logMessage("Novo peer conectado: " + remote)
+// servePeerSession aceita os fluxos abertos pelo peer e lê cada um de forma
+// independente, para que uma transferência não atrase o chat. O endereço
+// usado como chave em Peers é recebido de quem discou, para que remoções e
+// tabelas de transferência usem a mesma chave.
+func servePeerSession(remote string, conn net.Conn, session *mux.Session, showRemote bool) {
+	// Cada fluxo aceito ocupa uma goroutine; acima do limite, o fluxo é
+	// recusado em vez de acumular goroutines
+	slots := make(chan struct{}, maxPeerStreams)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			logMessage(fmt.Sprintf("Fluxo recusado de %s: limite de %d fluxos simultâneos", remote, maxPeerStreams))
+			stream.Reset()
 			continue
 		}
+		go func() {
+			defer func() { <-slots }()
+			readPeerStream(remote, stream, showRemote)
+		}()
+	}
 
-		// Se não estiver autenticado e não for um comando AUTH, rejeita
-		if !authenticated {
-			fmt.Fprintln(conn, "DENIED")
-			fmt.Printf(">>> Tentativa de comunicação sem autenticação: %s\n", remote)
-			conn.Close()
-			return
-		}
+	log.Println("Peer desconectado:", remote)
+	updateChatView("Sistema: Peer desconectado: " + remote)
+	logMessage("Peer desconectado: " + remote)
 
-		// Processamento normal de mensagens após autenticação
-		if strings.HasPrefix(trimmedMsg, "[FILE_TRANSFER]") {
-			data := strings.TrimPrefix(trimmedMsg, "[FILE_TRANSFER]")
-			handleFileChunk(strings.TrimSpace(data))
-		} else if strings.HasPrefix(trimmedMsg, "[PRIVADO]") {
-			privateMsg := strings.TrimPrefix(trimmedMsg, "[PRIVADO]")
-			updateChatView(fmt.Sprintf("🔒 [Mensagem privada de %s] %s", remote, privateMsg))
-			logMessage(fmt.Sprintf("[PRIVADO de %s] %s", remote, privateMsg))
-		} else {
-			// Mensagem normal
-			updateChatView(fmt.Sprintf("[%s] %s", remote, trimmedMsg))
-			logMessage(fmt.Sprintf("[%s] %s", remote, trimmedMsg))
-		}
-	}
+	forgetSyncPeer(remote)
+	removePeer(remote)
+
+	failPeerTransfers(remote)
+	forgetSwarmPeer(remote)
+	conn.Close()
 }
 
-// Nova função para lidar com as mensagens de peers já autenticados
-func handlePeerMessages(conn net.Conn) {
-	remote := conn.RemoteAddr().String()
-	reader := bufio.NewReader(conn)
+// readPeerStream processa as linhas do protocolo recebidas em um fluxo
+func readPeerStream(remote string, stream *mux.Stream, showRemote bool) {
+	// Fecha o nosso lado também, para que a sessão esqueça o fluxo
+	defer stream.Close()
 
+	reader := bufio.NewReader(stream)
 	for {
 		message, err := reader.ReadString('\n')
 		if err != nil {
-			log.Println("Peer desconectado:", remote)
-			updateChatView("Sistema: Peer desconectado: " + remote)
-			logMessage("Peer desconectado: " + remote)
-
-			peersMutex.Lock()
-			delete(Peers, remote)
-			delete(peerAuthenticated, remote)
-			peersMutex.Unlock()
-
-			conn.Close()
 			return
 		}
 
 		trimmedMsg := strings.TrimSpace(message)
 
-		// Processamento normal de mensagens
-		if strings.HasPrefix(trimmedMsg, "[FILE_TRANSFER]") {
-			data := strings.TrimPrefix(trimmedMsg, "[FILE_TRANSFER]")
-			handleFileChunk(strings.TrimSpace(data))
-		} else if strings.HasPrefix(trimmedMsg, "[PRIVADO]") {
-			privateMsg := strings.TrimPrefix(trimmedMsg, "[PRIVADO]")
-			updateChatView(fmt.Sprintf("🔒 [Mensagem privada de %s] %s", remote, privateMsg))
-			logMessage(fmt.Sprintf("[PRIVADO de %s] %s", remote, privateMsg))
-		} else {
+		// Processamento normal de mensagens após autenticação
+		trimmedMsg, err = expandLine(trimmedMsg)
+		if err != nil {
+			logMessage(fmt.Sprintf("Mensagem descartada de %s: %v", remote, err))
+			continue
+		}
+		if !handleProtocolMessage(remote, trimmedMsg) {
 			// Mensagem normal
-			updateChatView(trimmedMsg)
+			if showRemote {
+				updateChatView(fmt.Sprintf("[%s] %s", remote, trimmedMsg))
+			} else {
+				updateChatView(trimmedMsg)
+			}
 			logMessage(fmt.Sprintf("[%s] %s", remote, trimmedMsg))
 		}
 	}
 }
+
+// handleProtocolMessage trata as mensagens especiais do protocolo.
+// Retorna false se a mensagem for texto comum de chat.
+func handleProtocolMessage(remote string, trimmedMsg string) bool {
+	switch {
+	case strings.HasPrefix(trimmedMsg, "[HELLO]"):
+		handleHello(remote, strings.TrimPrefix(trimmedMsg, "[HELLO]"))
+	case strings.HasPrefix(trimmedMsg, "[RATE]"):
+		handleRateNotice(remote, strings.TrimPrefix(trimmedMsg, "[RATE]"))
+	case strings.HasPrefix(trimmedMsg, "[SWARM_ANNOUNCE]"):
+		handleSwarmAnnounce(remote, strings.TrimPrefix(trimmedMsg, "[SWARM_ANNOUNCE]"))
+	case strings.HasPrefix(trimmedMsg, "[RDV_REQ]"):
+		handleRendezvousRequest(remote, strings.TrimPrefix(trimmedMsg, "[RDV_REQ]"))
+	case strings.HasPrefix(trimmedMsg, "[RDV_PROBE]"):
+		handleRendezvousProbe(remote, strings.TrimPrefix(trimmedMsg, "[RDV_PROBE]"))
+	case strings.HasPrefix(trimmedMsg, "[RDV_PEER]"):
+		handleRendezvousPeer(remote, strings.TrimPrefix(trimmedMsg, "[RDV_PEER]"))
+	case strings.HasPrefix(trimmedMsg, "[RDV_FAIL]"):
+		handleRendezvousFail(remote, strings.TrimPrefix(trimmedMsg, "[RDV_FAIL]"))
+	case strings.HasPrefix(trimmedMsg, "[SWARM_WHO]"):
+		handleSwarmWho(remote, strings.TrimPrefix(trimmedMsg, "[SWARM_WHO]"))
+	case strings.HasPrefix(trimmedMsg, "[SWARM_HAVE]"):
+		handleSwarmHave(remote, strings.TrimPrefix(trimmedMsg, "[SWARM_HAVE]"))
+	case strings.HasPrefix(trimmedMsg, "[SWARM_REQ]"):
+		handleSwarmRequest(remote, strings.TrimPrefix(trimmedMsg, "[SWARM_REQ]"))
+	case strings.HasPrefix(trimmedMsg, "[SWARM_DATA]"):
+		handleSwarmData(remote, strings.TrimPrefix(trimmedMsg, "[SWARM_DATA]"))
+	case strings.HasPrefix(trimmedMsg, "[SYNC_REQ]"):
+		handleSyncRequest(remote)
+	case strings.HasPrefix(trimmedMsg, "[SYNC_MANIFEST]"):
+		handleSyncManifest(remote, strings.TrimPrefix(trimmedMsg, "[SYNC_MANIFEST]"))
+	case strings.HasPrefix(trimmedMsg, "[FILE_OFFER]"):
+		data := strings.TrimPrefix(trimmedMsg, "[FILE_OFFER]")
+		handleFileOffer(remote, strings.TrimSpace(data))
+	case strings.HasPrefix(trimmedMsg, "[FILE_CONTROL]"):
+		data := strings.TrimPrefix(trimmedMsg, "[FILE_CONTROL]")
+		handleFileControl(remote, strings.TrimSpace(data))
+	case strings.HasPrefix(trimmedMsg, "[FILE_TRANSFER]"):
+		data := strings.TrimPrefix(trimmedMsg, "[FILE_TRANSFER]")
+		handleFileChunk(remote, strings.TrimSpace(data))
+	case strings.HasPrefix(trimmedMsg, "[PRIVADO]"):
+		privateMsg := strings.TrimPrefix(trimmedMsg, "[PRIVADO]")
+		updateChatView(fmt.Sprintf("🔒 [Mensagem privada de %s] %s", remote, privateMsg))
+		logMessage(fmt.Sprintf("[PRIVADO de %s] %s", remote, privateMsg))
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"magician/nat"
+)
+
+const (
+	portMappingLifetime = time.Hour
+	gatewayTimeout      = 3 * time.Second
+)
+
+// Mapeamentos ativos no roteador e o endereço externo obtido
+var (
+	portMappings      []*nat.Mapping
+	externalAddress   string
+	portMappingMethod string
+	portMappingMutex  sync.Mutex
+)
+
+// startPortMapping mapeia a porta de escuta (TCP e UDP) no roteador.
+// Os mapeamentos são renovados em segundo plano até stopPortMapping.
+func startPortMapping(port string) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+
+	mapper, err := nat.Discover(gatewayTimeout)
+	if err != nil {
+		updateChatView("Sistema: Mapeamento de porta indisponível: " + err.Error())
+		logMessage(fmt.Sprintf("Mapeamento de porta falhou: %v", err))
+		return
+	}
+
+	var mapped []*nat.Mapping
+	for _, protocol := range []string{"tcp", "udp"} {
+		m, err := nat.Map(mapper, protocol, p, portMappingLifetime)
+		if err != nil {
+			log.Printf("Erro ao mapear %s/%d via %s: %v", protocol, p, mapper.Name(), err)
+			continue
+		}
+		mapped = append(mapped, m)
+	}
+	if len(mapped) == 0 {
+		updateChatView(fmt.Sprintf("Sistema: %s recusou o mapeamento da porta %s", mapper.Name(), port))
+		return
+	}
+
+	address := ""
+	if ip, err := mapper.ExternalIP(); err == nil {
+		address = net.JoinHostPort(ip.String(), strconv.Itoa(mapped[0].ExternalPort()))
+	}
+
+	portMappingMutex.Lock()
+	portMappings = mapped
+	externalAddress = address
+	portMappingMethod = mapper.Name()
+	portMappingMutex.Unlock()
+
+	if address != "" {
+		updateChatView(fmt.Sprintf("🌍 Porta %s mapeada via %s. Endereço externo: %s", port, mapper.Name(), address))
+	} else {
+		updateChatView(fmt.Sprintf("🌍 Porta %s mapeada via %s", port, mapper.Name()))
+	}
+	logMessage(fmt.Sprintf("Porta %s mapeada via %s (%s)", port, mapper.Name(), address))
+}
+
+// stopPortMapping remove os mapeamentos do roteador ao sair
+func stopPortMapping() {
+	portMappingMutex.Lock()
+	mapped := portMappings
+	portMappings = nil
+	externalAddress = ""
+	portMappingMutex.Unlock()
+
+	for _, m := range mapped {
+		if err := m.Close(); err != nil {
+			log.Printf("Erro ao remover mapeamento %s/%d: %v", m.Protocol, m.InternalPort, err)
+		}
+	}
+}
+
+// portMappingInfo descreve o endereço externo para o /info
+func portMappingInfo() string {
+	portMappingMutex.Lock()
+	defer portMappingMutex.Unlock()
+
+	if len(portMappings) == 0 {
+		return "não mapeado"
+	}
+	info := fmt.Sprintf("%s (%s)", externalAddress, portMappingMethod)
+	for _, m := range portMappings {
+		if err := m.Err(); err != nil {
+			info += fmt.Sprintf(" ⚠️ renovação %s falhou: %v", m.Protocol, err)
+		}
+	}
+	return info
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jroimartin/gocui"
+)
+
+const (
+	thumbnailCols     = 32
+	thumbnailRows     = 12
+	maxThumbnailPixel = 8000 // Maior dimensão aceita antes de decodificar a imagem
+	previewTextLines  = 5
+	previewLineWidth  = 80
+	maxPopupFileSize  = 2 * 1024 * 1024
+)
+
+// Arquivos recebidos nesta sessão, por ID de transferência
+var (
+	receivedFiles      = make(map[string]string)
+	receivedFilesMutex sync.Mutex
+)
+
+// Conteúdo do popup aberto com /abrir (nil quando fechado)
+var (
+	popupLines []string
+	popupName  string
+)
+
+// registerReceivedFile guarda o caminho de um arquivo recebido para /abrir
+func registerReceivedFile(id, path string) {
+	receivedFilesMutex.Lock()
+	receivedFiles[id] = path
+	receivedFilesMutex.Unlock()
+}
+
+// detectFileType identifica o tipo do arquivo pelos primeiros bytes
+func detectFileType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// sanitizeTerminalText remove caracteres de controle (inclusive sequências
+// de escape) para que o conteúdo recebido não manipule o terminal
+func sanitizeTerminalText(s string) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// truncateRunes corta uma linha no número de caracteres informado
+func truncateRunes(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// ansi256 converte uma cor RGB de 8 bits para o cubo de 256 cores do xterm
+func ansi256(r, g, b uint32) int {
+	scale := func(c uint32) int {
+		return int((c>>8)*5+127) / 255
+	}
+	return 16 + 36*scale(r) + 6*scale(g) + scale(b)
+}
+
+// renderThumbnail desenha a imagem com meio-blocos ("▀"): cada célula mostra
+// dois pixels, o de cima na cor do texto e o de baixo na cor de fundo
+func renderThumbnail(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxThumbnailPixel || cfg.Height > maxThumbnailPixel {
+		return "", fmt.Errorf("dimensões não suportadas: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	scale := float64(w) / thumbnailCols
+	if s := float64(h) / (thumbnailRows * 2); s > scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	cols := int(float64(w) / scale)
+	rows := int(float64(h) / scale / 2)
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+
+	pixel := func(x, y int) int {
+		px := bounds.Min.X + int(float64(x)*scale)
+		py := bounds.Min.Y + int(float64(y)*scale)
+		if py >= bounds.Max.Y {
+			py = bounds.Max.Y - 1
+		}
+		r, g, b, _ := img.At(px, py).RGBA()
+		return ansi256(r, g, b)
+	}
+
+	var sb strings.Builder
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			// O modo 256 cores do gocui interpreta uma cor por sequência
+			fmt.Fprintf(&sb, "\x1b[38;5;%dm\x1b[48;5;%dm▀", pixel(col, row*2), pixel(col, row*2+1))
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	return sb.String(), nil
+}
+
+// textPreview retorna as primeiras linhas de um arquivo de texto
+func textPreview(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(f, 64*1024))
+	for scanner.Scan() && len(lines) < previewTextLines {
+		line := sanitizeTerminalText(scanner.Text())
+		lines = append(lines, "  │ "+truncateRunes(line, previewLineWidth))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// showFilePreview exibe no chat o tipo, o tamanho e uma prévia do arquivo recebido
+func showFilePreview(id, path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	mime, err := detectFileType(path)
+	if err != nil {
+		return
+	}
+
+	header := fmt.Sprintf("🔎 %s — %s, %s", filepath.Base(path), formatBytes(info.Size()), mime)
+
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		thumb, err := renderThumbnail(path)
+		if err != nil {
+			updateChatView(header)
+			return
+		}
+		updateChatView(header + "\n" + thumb)
+	case strings.HasPrefix(mime, "text/"):
+		preview, err := textPreview(path)
+		if err != nil {
+			updateChatView(header)
+			return
+		}
+		updateChatView(fmt.Sprintf("%s — use /abrir %s para ler\n%s", header, id[:6], preview))
+	default:
+		updateChatView(header)
+	}
+}
+
+// resolveReceivedFile encontra um arquivo recebido pelo prefixo do ID ou pelo nome
+func resolveReceivedFile(arg string) (string, error) {
+	receivedFilesMutex.Lock()
+	var matches []string
+	for id, path := range receivedFiles {
+		if strings.HasPrefix(id, arg) {
+			matches = append(matches, path)
+		}
+	}
+	receivedFilesMutex.Unlock()
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		// Aceita também o nome de um arquivo em 'recebidos/'
+		if name, ok := sanitizeFileName(arg); ok {
+			path := filepath.Join(receivedDir, name)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path, nil
+			}
+		}
+		return "", fmt.Errorf("nenhum arquivo recebido com ID '%s'", arg)
+	default:
+		return "", fmt.Errorf("ID '%s' é ambíguo, informe mais caracteres", arg)
+	}
+}
+
+// cmdOpen abre um arquivo de texto recebido em um popup paginado
+func cmdOpen(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /abrir <id>"
+	}
+
+	path, err := resolveReceivedFile(args[0])
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+
+	mime, err := detectFileType(path)
+	if err != nil {
+		return fmt.Sprintf("❌ Erro ao abrir arquivo: %v", err)
+	}
+	if !strings.HasPrefix(mime, "text/") {
+		return fmt.Sprintf("❌ '%s' não é um arquivo de texto (%s)", filepath.Base(path), mime)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Sprintf("❌ Erro ao abrir arquivo: %v", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxPopupFileSize))
+	if err != nil {
+		return fmt.Sprintf("❌ Erro ao ler arquivo: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = sanitizeTerminalText(line)
+	}
+
+	openPopup(filepath.Base(path), lines)
+	return fmt.Sprintf("📖 Abrindo '%s' (Esc para fechar)", filepath.Base(path))
+}
+
+// openPopup exibe as linhas em uma janela sobre o chat
+func openPopup(name string, lines []string) {
+	if G == nil {
+		return
+	}
+	G.Update(func(g *gocui.Gui) error {
+		g.DeleteView("popup")
+		popupName = name
+		popupLines = lines
+		return nil
+	})
+}
+
+// layoutPopup posiciona o popup, se houver um aberto
+func layoutPopup(g *gocui.Gui, maxX, maxY int) error {
+	if popupLines == nil {
+		return nil
+	}
+
+	v, err := g.SetView("popup", maxX/10, maxY/10, maxX*9/10, maxY*9/10)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Wrap = false
+		for _, line := range popupLines {
+			fmt.Fprintln(v, line)
+		}
+		if _, err := g.SetCurrentView("popup"); err != nil {
+			return err
+		}
+		g.SetViewOnTop("popup")
+	}
+
+	_, height := v.Size()
+	_, oy := v.Origin()
+	last := oy + height
+	if last > len(popupLines) {
+		last = len(popupLines)
+	}
+	v.Title = fmt.Sprintf("📖 %s — linhas %d-%d de %d (↑/↓, PgUp/PgDn, Esc)",
+		popupName, oy+1, last, len(popupLines))
+	return nil
+}
+
+// scrollPopup move o popup em delta linhas
+func scrollPopup(v *gocui.View, delta int) error {
+	_, height := v.Size()
+	ox, oy := v.Origin()
+	oy += delta
+
+	maxOrigin := len(popupLines) - height
+	if oy > maxOrigin {
+		oy = maxOrigin
+	}
+	if oy < 0 {
+		oy = 0
+	}
+	return v.SetOrigin(ox, oy)
+}
+
+// closePopup fecha o popup e devolve o foco ao input
+func closePopup(g *gocui.Gui, v *gocui.View) error {
+	popupLines = nil
+	popupName = ""
+	if err := g.DeleteView("popup"); err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+	_, err := g.SetCurrentView("input")
+	return err
+}
+
+// popupKeybindings configura a navegação do popup
+func popupKeybindings(g *gocui.Gui) error {
+	page := func(g *gocui.Gui) int {
+		if v, err := g.View("popup"); err == nil {
+			_, h := v.Size()
+			return h - 1
+		}
+		return 10
+	}
+
+	bindings := []struct {
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{gocui.KeyArrowUp, func(g *gocui.Gui, v *gocui.View) error { return scrollPopup(v, -1) }},
+		{gocui.KeyArrowDown, func(g *gocui.Gui, v *gocui.View) error { return scrollPopup(v, 1) }},
+		{gocui.KeyPgup, func(g *gocui.Gui, v *gocui.View) error { return scrollPopup(v, -page(g)) }},
+		{gocui.KeyPgdn, func(g *gocui.Gui, v *gocui.View) error { return scrollPopup(v, page(g)) }},
+		{gocui.KeySpace, func(g *gocui.Gui, v *gocui.View) error { return scrollPopup(v, page(g)) }},
+		{gocui.KeyEsc, closePopup},
+		{'q', closePopup},
+	}
+
+	for _, b := range bindings {
+		if err := g.SetKeybinding("popup", b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"magician/identity"
+	"magician/relay"
+	"magician/transport"
+)
+
+// Arquivo com a chave que identifica este peer entre sessões
+const identityFile = "identity.key"
+
+// Identidade deste peer (ID estável usado por relays)
+var localIdentity *identity.Identity
+
+// Relays em que este peer está registrado para receber circuitos
+var relayListens = make(map[string]bool)
+
+// loadIdentity carrega ou cria a identidade deste peer
+func loadIdentity() error {
+	id, err := identity.Load(identityFile)
+	if err != nil {
+		return err
+	}
+	localIdentity = id
+	return nil
+}
+
+// runRelay executa o modo relay, sem interface: aceita peers autenticados e
+// encaminha circuitos entre eles, com cotas e um endpoint de status
+func runRelay(args []string) {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	port := fs.String("porta", "9443", "porta TLS para os peers")
+	password := fs.String("senha", "", "senha exigida dos peers")
+	open := fs.Bool("aberto", false, "aceita qualquer peer sem senha (relay aberto)")
+	status := fs.String("status", "127.0.0.1:9444", "endereço HTTP do endpoint /status (vazio desativa)")
+	wsListen := fs.String("ws", "", "escuta também via WebSocket (ex: wss://:443/relay)")
+	quotaMB := fs.Int64("cota", 1024, "MB que cada peer pode enviar por janela (0 = ilimitado)")
+	window := fs.Duration("janela", 24*time.Hour, "duração da janela da cota")
+	circuits := fs.Int("circuitos", 16, "circuitos simultâneos por peer (0 = ilimitado)")
+	fs.Parse(args)
+
+	if *password == "" && !*open {
+		log.Fatal("Defina -senha ou, para um relay aberto a qualquer peer, use -aberto")
+	}
+	if *password != "" && *open {
+		log.Fatal("Use -senha ou -aberto, não os dois")
+	}
+
+	if err := initLogSystem(); err != nil {
+		log.Fatalf("Erro ao inicializar sistema de logs: %v", err)
+	}
+	if err := loadIdentity(); err != nil {
+		log.Fatalf("Erro ao carregar identidade: %v", err)
+	}
+	if err := initTransports(); err != nil {
+		log.Fatalf("Erro ao inicializar transportes: %v", err)
+	}
+
+	server := relay.NewServer(relay.Config{
+		Password:    *password,
+		Open:        *open,
+		Quota:       *quotaMB * 1024 * 1024,
+		QuotaWindow: *window,
+		MaxCircuits: *circuits,
+	})
+
+	t, _ := transport.Get("tcp")
+	ln, err := t.Listen(":" + *port)
+	if err != nil {
+		log.Fatalf("Erro ao escutar na porta %s: %v", *port, err)
+	}
+	log.Printf("Relay escutando na porta %s", *port)
+	logMessage(fmt.Sprintf("--- Relay iniciado na porta %s ---", *port))
+
+	if *wsListen != "" {
+		wt, addr, err := transport.ForAddress(*wsListen)
+		if err != nil {
+			log.Fatalf("Endereço WebSocket inválido: %v", err)
+		}
+		wln, err := wt.Listen(addr)
+		if err != nil {
+			log.Fatalf("Erro ao escutar via WebSocket: %v", err)
+		}
+		log.Printf("Relay escutando via WebSocket em %s", *wsListen)
+		go server.Serve(wln)
+	}
+
+	if *status != "" {
+		statusMux := http.NewServeMux()
+		statusMux.Handle("/status", server.StatusHandler())
+		go func() {
+			log.Printf("Status do relay em http://%s/status", *status)
+			if err := http.ListenAndServe(*status, statusMux); err != nil {
+				log.Printf("Erro no endpoint de status: %v", err)
+			}
+		}()
+	}
+
+	log.Fatal(server.Serve(ln))
+}
+
+// cmdRelay registra este peer em um relay ou mostra os relays em uso
+func cmdRelay(args []string) string {
+	if len(args) == 0 {
+		result := fmt.Sprintf("🆔 Seu ID: %s\n", localIdentity.ID)
+		peersMutex.Lock()
+		for addr := range relayListens {
+			result += fmt.Sprintf("📡 relay://%s/%s\n", addr, localIdentity.ID)
+		}
+		peersMutex.Unlock()
+		result += "Uso: /relay [senha@]host:porta — registra-se para receber conexões pelo relay"
+		return result
+	}
+
+	spec := strings.TrimPrefix(args[0], "relay://")
+	hostPort := spec
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		hostPort = spec[i+1:]
+	}
+
+	peersMutex.Lock()
+	already := relayListens[hostPort]
+	peersMutex.Unlock()
+	if already {
+		return fmt.Sprintf("Já registrado no relay %s", hostPort)
+	}
+
+	go listenRelay(spec, hostPort)
+	return fmt.Sprintf("📡 Registrando no relay %s...", hostPort)
+}
+
+// listenRelay aceita peers que chegam por um relay enquanto a sessão durar
+func listenRelay(spec, hostPort string) {
+	t, ok := transport.Get("relay")
+	if !ok {
+		return
+	}
+
+	ln, err := t.Listen(spec)
+	if err != nil {
+		updateChatView(fmt.Sprintf("❌ Erro ao registrar no relay: %v", err))
+		return
+	}
+
+	peersMutex.Lock()
+	relayListens[hostPort] = true
+	peersMutex.Unlock()
+
+	address := fmt.Sprintf("relay://%s/%s", hostPort, localIdentity.ID)
+	updateChatView("📡 Registrado no relay. Outros peers podem conectar com: " + address)
+	logMessage("Registrado no relay " + hostPort)
+
+	acceptPeers(ln)
+
+	peersMutex.Lock()
+	delete(relayListens, hostPort)
+	peersMutex.Unlock()
+	updateChatView("Sistema: Sessão com o relay " + hostPort + " encerrada")
+}
+
+// cmdConnect conecta a um peer por qualquer endereço suportado
+func cmdConnect(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /conectar <endereço|n> (IP:porta, quic://..., wss://..., relay://host:porta/ID, número de /descobertos, nome na agenda, ID de peer)"
+	}
+
+	address := args[0]
+	if c, ok := findContact(address); ok {
+		go dialContact(c)
+		return fmt.Sprintf("🔌 Conectando a %s...", c.Name)
+	}
+	if id := strings.ToLower(address); identity.ValidID(id) {
+		go connectByID(id)
+		return fmt.Sprintf("🔎 Procurando %s na DHT...", identity.Short(id))
+	}
+	if n, err := strconv.Atoi(address); err == nil {
+		if address, err = discoveredAddr(n); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+	}
+
+	go connectToPeer(address)
+	return fmt.Sprintf("🔌 Conectando a %s...", address)
+}
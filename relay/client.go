@@ -0,0 +1,267 @@
+package relay
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+	"magician/mux"
+)
+
+// Tamanho do material exportado do TLS que cada lado assina no circuito
+const exporterSize = 32
+
+// Client é a sessão de um peer com um relay
+type Client struct {
+	relay   string // Endereço do relay, usado nos endereços dos circuitos
+	ID      string // Nosso ID, confirmado pelo relay
+	session *mux.Session
+
+	key    ed25519.PrivateKey
+	server *tls.Config // TLS de ponta a ponta quando recebemos um circuito
+	client *tls.Config // TLS de ponta a ponta quando discamos
+
+	mu       sync.Mutex
+	listener *Listener
+}
+
+// Connect autentica no relay pela conexão conn (já cifrada até o relay)
+func Connect(conn net.Conn, relayAddr, password string, key ed25519.PrivateKey, server, client *tls.Config) (*Client, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	fmt.Fprintf(conn, "RELAY %s\n", password)
+	line, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao falar com o relay: %w", err)
+	}
+	if !strings.HasPrefix(line, "CHALLENGE ") {
+		conn.Close()
+		return nil, fmt.Errorf("relay recusou: %s", line)
+	}
+	nonce, err := decodeHex(strings.TrimPrefix(line, "CHALLENGE "))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	fmt.Fprint(conn, identLine(key, challengeContext, nonce))
+	line, err = readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao falar com o relay: %w", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("relay recusou: %s", line)
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		relay:   relayAddr,
+		ID:      strings.TrimPrefix(line, "OK "),
+		session: mux.NewSession(conn, true),
+		key:     key,
+		server:  server,
+		client:  client,
+	}
+	go c.acceptCircuits()
+	return c, nil
+}
+
+// Done é fechado quando a sessão com o relay termina
+func (c *Client) Done() <-chan struct{} {
+	return c.session.Done()
+}
+
+// Close encerra a sessão com o relay
+func (c *Client) Close() error {
+	return c.session.Close()
+}
+
+// Dial abre um circuito até o peer e negocia o TLS de ponta a ponta,
+// confirmando que do outro lado está mesmo o dono do ID
+func (c *Client) Dial(peerID string) (net.Conn, error) {
+	stream, err := c.session.Open(mux.NumPriorities / 2)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(stream, "CONNECT %s\n", peerID)
+	line, err := readLine(stream)
+	if err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("relay encerrou o circuito: %w", err)
+	}
+	if line != "OK" {
+		stream.Close()
+		return nil, fmt.Errorf("relay: %s", strings.TrimPrefix(line, "ERR "))
+	}
+
+	conn := tls.Client(c.circuitConn(stream, peerID), c.client)
+	if err := c.verifyPeer(conn, peerID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Listen passa a aceitar circuitos abertos por outros peers
+func (c *Client) Listen() (net.Listener, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.listener != nil {
+		return nil, fmt.Errorf("já aceitando circuitos do relay %s", c.relay)
+	}
+	c.listener = &Listener{
+		client: c,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	return c.listener, nil
+}
+
+// acceptCircuits recebe os circuitos abertos pelo relay em nosso nome
+func (c *Client) acceptCircuits() {
+	for {
+		stream, err := c.session.Accept()
+		if err != nil {
+			c.mu.Lock()
+			if c.listener != nil {
+				c.listener.Close()
+			}
+			c.mu.Unlock()
+			return
+		}
+		go c.handleCircuit(stream)
+	}
+}
+
+func (c *Client) handleCircuit(stream *mux.Stream) {
+	line, err := readLine(stream)
+	if err != nil || !strings.HasPrefix(line, "FROM ") {
+		stream.Reset()
+		return
+	}
+	peerID := strings.TrimPrefix(line, "FROM ")
+
+	c.mu.Lock()
+	l := c.listener
+	c.mu.Unlock()
+	if l == nil {
+		// Ninguém aceitando circuitos nesta sessão
+		stream.Reset()
+		return
+	}
+
+	conn := tls.Server(c.circuitConn(stream, peerID), c.server)
+	if err := c.verifyPeer(conn, peerID); err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// verifyPeer conclui o handshake TLS e troca assinaturas sobre o material
+// exportado da sessão TLS. Um relay que tentasse interceptar o circuito
+// teria sessões TLS diferentes de cada lado e as assinaturas não confeririam.
+func (c *Client) verifyPeer(conn *tls.Conn, peerID string) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("erro no handshake TLS do circuito: %w", err)
+	}
+	state := conn.ConnectionState()
+	material, err := state.ExportKeyingMaterial(circuitContext, nil, exporterSize)
+	if err != nil {
+		return fmt.Errorf("erro ao exportar chave do circuito: %w", err)
+	}
+
+	if _, err := fmt.Fprint(conn, identLine(c.key, circuitContext, material)); err != nil {
+		return err
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("peer não se identificou: %w", err)
+	}
+	id, err := verifyIdent(line, circuitContext, material)
+	if err != nil {
+		return err
+	}
+	if id != peerID {
+		return fmt.Errorf("identidade do peer não confere (esperado %s, recebido %s)",
+			identity.Short(peerID), identity.Short(id))
+	}
+	return nil
+}
+
+// circuitConn adapta um fluxo do relay para net.Conn. O endereço remoto é
+// o próprio endereço relay://, que serve para discar de volta ao peer.
+func (c *Client) circuitConn(stream *mux.Stream, peerID string) net.Conn {
+	return &circuitConn{
+		Stream: stream,
+		local:  Addr(c.relay + "/" + c.ID),
+		remote: Addr(c.relay + "/" + peerID),
+	}
+}
+
+// Listener entrega os circuitos recebidos pelo relay
+type Listener struct {
+	client *Client
+	conns  chan net.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.client.mu.Lock()
+		if l.client.listener == l {
+			l.client.listener = nil
+		}
+		l.client.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return Addr(l.client.relay + "/" + l.client.ID)
+}
+
+// circuitConn é um fluxo do relay visto como conexão; os prazos são os do
+// próprio fluxo
+type circuitConn struct {
+	*mux.Stream
+	local, remote net.Addr
+}
+
+func (c *circuitConn) LocalAddr() net.Addr  { return c.local }
+func (c *circuitConn) RemoteAddr() net.Addr { return c.remote }
+
+// Addr é um endereço relay://host:porta/ID
+type Addr string
+
+func (a Addr) Network() string { return "relay" }
+func (a Addr) String() string  { return "relay://" + string(a) }
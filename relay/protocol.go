@@ -0,0 +1,88 @@
+// Package relay implementa o modo relay: um servidor que encaminha fluxos
+// opacos entre peers que não alcançam um ao outro diretamente. O conteúdo
+// de cada circuito é protegido por TLS de ponta a ponta, atrelado às
+// identidades dos peers, então o relay só enxerga bytes cifrados.
+//
+// Handshake com o relay (linhas de texto, antes da sessão multiplexada):
+//
+//	cliente: RELAY <senha>
+//	relay:   CHALLENGE <nonce hex>
+//	cliente: IDENT <chave pública base64> <assinatura base64>
+//	relay:   OK <ID do peer>  (ou DENIED <motivo>)
+//
+// Depois disso cada circuito é um fluxo da sessão, iniciado com
+// "CONNECT <ID>" por quem disca e "FROM <ID>" para quem recebe.
+package relay
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"magician/identity"
+)
+
+const (
+	maxLineSize = 4096
+
+	// Contextos das assinaturas, para que uma não sirva no lugar da outra
+	challengeContext = "magician-relay-challenge:"
+	circuitContext   = "magician-relay-circuit:"
+)
+
+// readLine lê uma linha byte a byte, sem consumir nada além do '\n'
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxLineSize {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimRight(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("linha longa demais")
+}
+
+// identLine assina a mensagem e monta a linha "IDENT <pub> <sig>"
+func identLine(key ed25519.PrivateKey, context string, message []byte) string {
+	pub := key.Public().(ed25519.PublicKey)
+	sig := ed25519.Sign(key, append([]byte(context), message...))
+	return fmt.Sprintf("IDENT %s %s\n",
+		base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(sig))
+}
+
+// verifyIdent confere uma linha IDENT e retorna o ID do peer que assinou
+func verifyIdent(line string, context string, message []byte) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "IDENT" {
+		return "", errors.New("identificação ausente")
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", errors.New("chave pública inválida")
+	}
+	sig, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", errors.New("assinatura inválida")
+	}
+	if !ed25519.Verify(pub, append([]byte(context), message...), sig) {
+		return "", errors.New("assinatura não confere")
+	}
+	return identity.IDFromPublicKey(pub), nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("desafio inválido")
+	}
+	return b, nil
+}
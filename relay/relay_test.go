@@ -0,0 +1,411 @@
+package relay_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"magician/identity"
+	"magician/mux"
+	"magician/relay"
+	"magician/transport"
+)
+
+// testTLS gera o certificado do TLS de ponta a ponta. A identidade é
+// conferida pelas assinaturas, então o certificado não é verificado.
+func testTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}
+}
+
+// recorder guarda tudo o que passa pelas conexões do relay
+type recorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(b)
+}
+
+func (r *recorder) Contains(s string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return bytes.Contains(r.buf.Bytes(), []byte(s))
+}
+
+type recordedConn struct {
+	net.Conn
+	rec *recorder
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.rec.Write(b[:n])
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	c.rec.Write(b)
+	return c.Conn.Write(b)
+}
+
+type recordedListener struct {
+	net.Listener
+	rec *recorder
+}
+
+func (l *recordedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &recordedConn{Conn: conn, rec: l.rec}, nil
+}
+
+// testRelay sobe um relay no transporte em memória
+type testRelay struct {
+	mem    *transport.MemoryTransport
+	name   string
+	server *relay.Server
+	rec    *recorder
+}
+
+func startRelay(t *testing.T, cfg relay.Config) *testRelay {
+	t.Helper()
+	mem := transport.NewMemory()
+	ln, err := mem.Listen("relay")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	r := &testRelay{mem: mem, name: "relay", server: relay.NewServer(cfg), rec: &recorder{}}
+	go r.server.Serve(&recordedListener{Listener: ln, rec: r.rec})
+	return r
+}
+
+// connect registra um peer novo no relay
+func (r *testRelay) connect(t *testing.T, password string) (*relay.Client, error) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := r.mem.Dial(r.name)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	config := testTLS(t)
+	c, err := relay.Connect(conn, "mem", password, key, config, config)
+	if err == nil {
+		t.Cleanup(func() { c.Close() })
+	}
+	return c, err
+}
+
+func (r *testRelay) mustConnect(t *testing.T, password string) *relay.Client {
+	t.Helper()
+	c, err := r.connect(t, password)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return c
+}
+
+// accepting faz o peer aceitar circuitos e entrega as conexões recebidas
+func accepting(t *testing.T, c *relay.Client) <-chan net.Conn {
+	t.Helper()
+	ln, err := c.Listen()
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return conns
+}
+
+func TestRelayPassword(t *testing.T) {
+	r := startRelay(t, relay.Config{Password: "segredo"})
+	if _, err := r.connect(t, "errada"); err == nil || !strings.Contains(err.Error(), "senha incorreta") {
+		t.Errorf("senha errada: %v", err)
+	}
+	if _, err := r.connect(t, ""); err == nil {
+		t.Error("entrou sem senha")
+	}
+	r.mustConnect(t, "segredo")
+}
+
+// Sem senha o relay só aceita peers se for explicitamente aberto
+func TestRelayOpenFlag(t *testing.T) {
+	closed := startRelay(t, relay.Config{})
+	if _, err := closed.connect(t, ""); err == nil || !strings.Contains(err.Error(), "não aberto") {
+		t.Errorf("relay sem senha nem -aberto aceitou: %v", err)
+	}
+
+	open := startRelay(t, relay.Config{Open: true})
+	c := open.mustConnect(t, "")
+	if !identity.ValidID(c.ID) {
+		t.Errorf("ID confirmado inválido: %q", c.ID)
+	}
+}
+
+// O IDENT precisa assinar o desafio desta conexão com a chave anunciada
+func TestRelayRejectsMismatchedIdent(t *testing.T) {
+	r := startRelay(t, relay.Config{Open: true})
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	cases := map[string]func(nonce []byte) string{
+		"outro desafio": func([]byte) string {
+			return ident(pub, ed25519.Sign(key, append([]byte("magician-relay-challenge:"), make([]byte, 32)...)))
+		},
+		"outra chave": func(nonce []byte) string {
+			return ident(otherPub, ed25519.Sign(key, append([]byte("magician-relay-challenge:"), nonce...)))
+		},
+		"contexto do circuito": func(nonce []byte) string {
+			return ident(pub, ed25519.Sign(key, append([]byte("magician-relay-circuit:"), nonce...)))
+		},
+	}
+	for name, line := range cases {
+		conn, err := r.mem.Dial(r.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(conn, "RELAY \n")
+		var hexNonce string
+		if _, err := fmt.Fscanf(conn, "CHALLENGE %s\n", &hexNonce); err != nil {
+			t.Fatalf("%s: desafio: %v", name, err)
+		}
+		var nonce []byte
+		fmt.Sscanf(hexNonce, "%x", &nonce)
+		fmt.Fprint(conn, line(nonce))
+
+		reply, _ := io.ReadAll(io.LimitReader(conn, 200))
+		if !strings.HasPrefix(string(reply), "DENIED") {
+			t.Errorf("%s: relay respondeu %q", name, reply)
+		}
+		conn.Close()
+	}
+}
+
+func ident(pub ed25519.PublicKey, sig []byte) string {
+	return fmt.Sprintf("IDENT %s %s\n", base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(sig))
+}
+
+// O relay só vê o TLS de ponta a ponta, nunca o conteúdo
+func TestRelayCannotReadPayload(t *testing.T) {
+	r := startRelay(t, relay.Config{Open: true})
+	a, b := r.mustConnect(t, ""), r.mustConnect(t, "")
+	incoming := accepting(t, b)
+
+	conn, err := a.Dial(b.ID)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	remote := <-incoming
+	defer remote.Close()
+
+	secret := "mensagem secreta que o relay não pode ler"
+	go fmt.Fprintln(conn, secret)
+	buf := make([]byte, len(secret))
+	if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != secret {
+		t.Fatalf("leitura: %q %v", buf, err)
+	}
+	if remote.RemoteAddr().String() != "relay://mem/"+a.ID {
+		t.Errorf("endereço remoto %s", remote.RemoteAddr())
+	}
+	if !r.rec.Contains("CONNECT "+b.ID) || !r.rec.Contains("FROM "+a.ID) {
+		t.Fatal("o gravador não viu o tráfego do relay")
+	}
+	if r.rec.Contains(secret) || r.rec.Contains("secreta") {
+		t.Error("o relay viu o conteúdo do circuito")
+	}
+}
+
+// Um relay que entrega o circuito a outro peer é descoberto pelo IDENT de
+// ponta a ponta
+func TestRelayCircuitIdentityMismatch(t *testing.T) {
+	mem := transport.NewMemory()
+	ln, err := mem.Listen("desonesto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// O relay desonesto aceita qualquer um, confirma o ID pedido e liga o
+	// circuito ao último peer registrado, seja ele quem for
+	var mu sync.Mutex
+	var sessions []*mux.Session
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var line string
+			fmt.Fscanln(conn, &line)
+			fmt.Fprintf(conn, "CHALLENGE %x\n", make([]byte, 32))
+			buf := make([]byte, 1)
+			for buf[0] != '\n' {
+				conn.Read(buf)
+			}
+			// A sessão entra na lista antes do OK, para o peer já estar
+			// disponível quando o Connect dele retornar
+			s := mux.NewSession(conn, false)
+			mu.Lock()
+			sessions = append(sessions, s)
+			mu.Unlock()
+			fmt.Fprintf(conn, "OK peer%d\n", i)
+			go func() {
+				for {
+					stream, err := s.Accept()
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					last := sessions[len(sessions)-1]
+					mu.Unlock()
+					out, _ := last.Open(0)
+					for buf[0] = 0; buf[0] != '\n'; {
+						stream.Read(buf)
+					}
+					fmt.Fprintf(out, "FROM peer0\n")
+					fmt.Fprintf(stream, "OK\n")
+					go io.Copy(out, stream)
+					go io.Copy(stream, out)
+				}
+			}()
+		}
+	}()
+
+	dial := func() *relay.Client {
+		conn, err := mem.Dial("desonesto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, key, _ := ed25519.GenerateKey(rand.Reader)
+		config := testTLS(t)
+		c, err := relay.Connect(conn, "mem", "", key, config, config)
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	a := dial()
+	impostor := dial()
+	accepting(t, impostor)
+
+	// A pede um ID qualquer e o relay entrega o circuito ao impostor
+	_, want, _ := ed25519.GenerateKey(rand.Reader)
+	wantID := identity.IDFromPublicKey(want.Public().(ed25519.PublicKey))
+	if _, err := a.Dial(wantID); err == nil || !strings.Contains(err.Error(), "não confere") {
+		t.Errorf("circuito desviado aceito: %v", err)
+	}
+}
+
+// A cota esgotada encerra o circuito em andamento e recusa os próximos
+func TestRelayQuotaClosesCircuit(t *testing.T) {
+	const quota = 32 * 1024
+	r := startRelay(t, relay.Config{Open: true, Quota: quota, QuotaWindow: time.Hour})
+	a, b := r.mustConnect(t, ""), r.mustConnect(t, "")
+	incoming := accepting(t, b)
+
+	conn, err := a.Dial(b.ID)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	remote := <-incoming
+	defer remote.Close()
+
+	go conn.Write(make([]byte, 8*quota))
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := io.Copy(io.Discard, remote)
+	if err == nil && n >= 8*quota {
+		t.Fatalf("recebeu %d bytes além da cota", n)
+	}
+	if n > 2*quota {
+		t.Errorf("recebeu %d bytes com cota de %d", n, quota)
+	}
+	if err != nil && strings.Contains(err.Error(), "deadline") {
+		t.Error("o circuito não foi encerrado ao esgotar a cota")
+	}
+
+	if _, err := a.Dial(b.ID); err == nil || !strings.Contains(err.Error(), "cota") {
+		t.Errorf("novo circuito com a cota esgotada: %v", err)
+	}
+}
+
+func TestRelayCircuitLimit(t *testing.T) {
+	r := startRelay(t, relay.Config{Open: true, MaxCircuits: 1})
+	a, b := r.mustConnect(t, ""), r.mustConnect(t, "")
+	incoming := accepting(t, b)
+
+	first, err := a.Dial(b.ID)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	remote := <-incoming
+
+	if _, err := a.Dial(b.ID); err == nil || !strings.Contains(err.Error(), "limite de circuitos") {
+		t.Errorf("segundo circuito: %v", err)
+	}
+
+	// Fechado o primeiro, a vaga volta
+	first.Close()
+	remote.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := a.Dial(b.ID)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("vaga não foi liberada: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,341 @@
+package relay
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+	"magician/mux"
+)
+
+// Tempo máximo para um cliente concluir o handshake com o relay
+const handshakeTimeout = 30 * time.Second
+
+// Config define o acesso e as cotas do relay
+type Config struct {
+	Password    string        // Senha exigida dos peers
+	Open        bool          // Aceita qualquer peer quando Password é vazia; sem isso, ninguém entra
+	Quota       int64         // Bytes que cada peer pode enviar por QuotaWindow (0 = ilimitado)
+	QuotaWindow time.Duration // Janela da cota
+	MaxCircuits int           // Circuitos simultâneos por peer (0 = ilimitado)
+}
+
+// Server encaminha circuitos entre os peers registrados
+type Server struct {
+	cfg     Config
+	started time.Time
+
+	mu            sync.Mutex
+	peers         map[string]*relayPeer
+	totalBytes    int64
+	totalCircuits int64
+}
+
+type relayPeer struct {
+	id        string
+	addr      string
+	session   *mux.Session
+	connected time.Time
+
+	// Protegidos por Server.mu
+	circuits    int
+	bytes       int64
+	quotaUsed   int64
+	quotaWindow time.Time
+}
+
+// NewServer cria um relay com a configuração informada
+func NewServer(cfg Config) *Server {
+	if cfg.QuotaWindow <= 0 {
+		cfg.QuotaWindow = 24 * time.Hour
+	}
+	return &Server{
+		cfg:     cfg,
+		started: time.Now(),
+		peers:   make(map[string]*relayPeer),
+	}
+}
+
+// Serve aceita peers no listener até que ele seja fechado
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle autentica um peer e atende os circuitos que ele abrir
+func (s *Server) handle(conn net.Conn) {
+	remote := conn.RemoteAddr().String()
+
+	id, err := s.authenticate(conn)
+	if err != nil {
+		log.Printf("Relay: peer %s recusado: %v", remote, err)
+		fmt.Fprintf(conn, "DENIED %v\n", err)
+		conn.Close()
+		return
+	}
+	fmt.Fprintf(conn, "OK %s\n", id)
+
+	peer := &relayPeer{
+		id:          id,
+		addr:        remote,
+		session:     mux.NewSession(conn, false),
+		connected:   time.Now(),
+		quotaWindow: time.Now(),
+	}
+
+	s.mu.Lock()
+	old, exists := s.peers[id]
+	s.peers[id] = peer
+	s.mu.Unlock()
+	if exists {
+		// A mesma identidade reconectou: a sessão antiga é descartada
+		old.session.Close()
+	}
+	log.Printf("Relay: peer %s registrado de %s", identity.Short(id), remote)
+
+	for {
+		stream, err := peer.session.Accept()
+		if err != nil {
+			break
+		}
+		go s.handleCircuit(peer, stream)
+	}
+
+	s.mu.Lock()
+	if s.peers[id] == peer {
+		delete(s.peers, id)
+	}
+	s.mu.Unlock()
+	log.Printf("Relay: peer %s desconectado", identity.Short(id))
+}
+
+// authenticate executa o desafio de identidade descrito no pacote
+func (s *Server) authenticate(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := readLine(conn)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "RELAY ") && line != "RELAY" {
+		return "", fmt.Errorf("comando inesperado")
+	}
+	password := strings.TrimPrefix(strings.TrimPrefix(line, "RELAY"), " ")
+	if s.cfg.Password == "" && !s.cfg.Open {
+		return "", fmt.Errorf("relay sem senha e não aberto")
+	}
+	if s.cfg.Password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.Password)) != 1 {
+		return "", fmt.Errorf("senha incorreta")
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(conn, "CHALLENGE %s\n", hex.EncodeToString(nonce))
+
+	line, err = readLine(conn)
+	if err != nil {
+		return "", err
+	}
+	return verifyIdent(line, challengeContext, nonce)
+}
+
+// handleCircuit liga um fluxo aberto por src ao peer pedido
+func (s *Server) handleCircuit(src *relayPeer, stream *mux.Stream) {
+	line, err := readLine(stream)
+	if err != nil || !strings.HasPrefix(line, "CONNECT ") {
+		stream.Reset()
+		return
+	}
+	targetID := strings.TrimPrefix(line, "CONNECT ")
+
+	dst, err := s.openCircuit(src, targetID)
+	if err != nil {
+		fmt.Fprintf(stream, "ERR %v\n", err)
+		stream.Close()
+		return
+	}
+	defer s.closeCircuit(src, dst)
+
+	out, err := dst.session.Open(mux.NumPriorities / 2)
+	if err != nil {
+		fmt.Fprintf(stream, "ERR peer indisponível\n")
+		stream.Close()
+		return
+	}
+	fmt.Fprintf(out, "FROM %s\n", src.id)
+	fmt.Fprintf(stream, "OK\n")
+
+	log.Printf("Relay: circuito %s → %s aberto", identity.Short(src.id), identity.Short(dst.id))
+
+	done := make(chan struct{}, 2)
+	go s.pipe(src, stream, out, done)
+	go s.pipe(dst, out, stream, done)
+	<-done
+	<-done
+
+	log.Printf("Relay: circuito %s → %s encerrado", identity.Short(src.id), identity.Short(dst.id))
+}
+
+// openCircuit reserva um circuito para os dois peers, respeitando os limites
+func (s *Server) openCircuit(src *relayPeer, targetID string) (*relayPeer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dst, ok := s.peers[targetID]
+	if !ok {
+		return nil, fmt.Errorf("peer %s não está registrado neste relay", identity.Short(targetID))
+	}
+	if dst == src {
+		return nil, fmt.Errorf("não é possível conectar a si mesmo")
+	}
+	if s.cfg.MaxCircuits > 0 && (src.circuits >= s.cfg.MaxCircuits || dst.circuits >= s.cfg.MaxCircuits) {
+		return nil, fmt.Errorf("limite de circuitos atingido")
+	}
+	if !s.withinQuota(src) {
+		return nil, fmt.Errorf("cota de tráfego esgotada")
+	}
+
+	src.circuits++
+	dst.circuits++
+	s.totalCircuits++
+	return dst, nil
+}
+
+func (s *Server) closeCircuit(src, dst *relayPeer) {
+	s.mu.Lock()
+	src.circuits--
+	dst.circuits--
+	s.mu.Unlock()
+}
+
+// withinQuota renova a janela da cota se preciso; chamar com s.mu travado
+func (s *Server) withinQuota(p *relayPeer) bool {
+	if s.cfg.Quota <= 0 {
+		return true
+	}
+	if time.Since(p.quotaWindow) >= s.cfg.QuotaWindow {
+		p.quotaWindow = time.Now()
+		p.quotaUsed = 0
+	}
+	return p.quotaUsed < s.cfg.Quota
+}
+
+// charge contabiliza bytes enviados por um peer; retorna false se a cota acabou
+func (s *Server) charge(p *relayPeer, n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.bytes += int64(n)
+	p.quotaUsed += int64(n)
+	s.totalBytes += int64(n)
+	return s.withinQuota(p)
+}
+
+// pipe copia os bytes de from para to, cobrando de sender. O conteúdo é
+// opaco: o relay não interpreta nada depois das linhas CONNECT/FROM.
+func (s *Server) pipe(sender *relayPeer, from, to *mux.Stream, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := from.Read(buf)
+		if n > 0 {
+			if !s.charge(sender, n) {
+				log.Printf("Relay: cota de %s esgotada, circuito encerrado", identity.Short(sender.id))
+				from.Reset()
+				to.Reset()
+				return
+			}
+			if _, werr := to.Write(buf[:n]); werr != nil {
+				from.Reset()
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				to.Close()
+			} else {
+				to.Reset()
+			}
+			return
+		}
+	}
+}
+
+// PeerStatus descreve um peer registrado no endpoint de status
+type PeerStatus struct {
+	ID             string
+	Address        string
+	ConnectedSince time.Time
+	Circuits       int
+	BytesRelayed   int64
+	QuotaUsed      int64
+}
+
+// Status é a resposta do endpoint de status
+type Status struct {
+	Uptime        string
+	Peers         []PeerStatus
+	TotalCircuits int64
+	BytesRelayed  int64
+	Quota         int64
+	QuotaWindow   string
+	MaxCircuits   int
+}
+
+// Snapshot retorna o estado atual do relay
+func (s *Server) Snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Uptime:        time.Since(s.started).Round(time.Second).String(),
+		TotalCircuits: s.totalCircuits,
+		BytesRelayed:  s.totalBytes,
+		Quota:         s.cfg.Quota,
+		QuotaWindow:   s.cfg.QuotaWindow.String(),
+		MaxCircuits:   s.cfg.MaxCircuits,
+	}
+	for _, p := range s.peers {
+		status.Peers = append(status.Peers, PeerStatus{
+			ID:             p.id,
+			Address:        p.addr,
+			ConnectedSince: p.connected,
+			Circuits:       p.circuits,
+			BytesRelayed:   p.bytes,
+			QuotaUsed:      p.quotaUsed,
+		})
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].ConnectedSince.Before(status.Peers[j].ConnectedSince)
+	})
+	return status
+}
+
+// StatusHandler serve o estado do relay em JSON
+func (s *Server) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.Snapshot())
+	})
+}
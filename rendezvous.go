@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+)
+
+// Hole punching com ajuda de um peer acessível (rendezvous):
+//
+//  1. A pede a R, por [RDV_REQ], uma conexão direta com o peer de ID B.
+//  2. R envia a A e B um token por [RDV_PROBE]; cada um manda o token por
+//     UDP a R, que assim descobre o endereço público (após o NAT) de ambos.
+//  3. R informa a cada um o endereço do outro com [RDV_PEER], repetindo o
+//     token daquele peer: só é aceito o [RDV_PEER] do mesmo peer que enviou
+//     o [RDV_PROBE], então ninguém faz um peer mandar datagramas a endereços
+//     arbitrários.
+//  4. Os dois enviam datagramas um ao outro ao mesmo tempo, abrindo os NATs,
+//     e A disca para B via QUIC pelo mesmo socket UDP.
+const (
+	rendezvousTimeout = 30 * time.Second
+	punchDuration     = 10 * time.Second
+	punchInterval     = 200 * time.Millisecond
+	probeAttempts     = 5
+)
+
+// RendezvousRequest pede a um peer que apresente dois peers atrás de NAT
+type RendezvousRequest struct {
+	Target string // ID do peer desejado
+}
+
+// RendezvousProbe pede que o token seja enviado por UDP à porta do rendezvous
+type RendezvousProbe struct {
+	Token string
+	Port  int
+}
+
+// RendezvousPeer informa o endereço público do outro peer
+type RendezvousPeer struct {
+	ID    string
+	Addr  string
+	Dial  bool   // Quem pediu a conexão disca; o outro só abre o NAT
+	Token string // Token do [RDV_PROBE] enviado a quem recebe
+}
+
+// RendezvousFail informa que o rendezvous não pôde apresentar os peers
+type RendezvousFail struct {
+	Target string
+	Reason string
+}
+
+// rdvIntro é uma apresentação em andamento no papel de rendezvous
+type rdvIntro struct {
+	peers   [2]string
+	ids     [2]string
+	tokens  [2]string
+	addrs   [2]netip.AddrPort
+	created time.Time
+}
+
+// rdvProbe é um [RDV_PROBE] recebido, à espera do [RDV_PEER] correspondente
+type rdvProbe struct {
+	remote  string
+	created time.Time
+}
+
+// pendingPunch é um pedido de conexão direta feito por nós
+type pendingPunch struct {
+	asked   int
+	failed  int
+	created time.Time
+}
+
+var (
+	rdvIntros  = make(map[string]*rdvIntro)     // Por token
+	rdvProbes  = make(map[string]rdvProbe)      // Por token
+	rdvPunches = make(map[string]*pendingPunch) // Por ID, válidos por rendezvousTimeout
+	rdvMutex   sync.Mutex
+)
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// findPeerByID retorna a chave do peer conectado com o ID informado
+func findPeerByID(id string) (string, bool) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	for addr, info := range peerInfos {
+		if info.ID == id {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// cmdDirect tenta uma conexão direta (hole punching) com o peer de ID informado
+func cmdDirect(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /direto <ID> [peer rendezvous]"
+	}
+	target := strings.ToLower(args[0])
+	if !identity.ValidID(target) {
+		return fmt.Sprintf("❌ ID inválido: '%s'", args[0])
+	}
+	if target == localIdentity.ID {
+		return "❌ Este é o seu próprio ID"
+	}
+	if torOnly {
+		return "❌ Conexão direta indisponível no modo somente Tor"
+	}
+	if quicTransport.LocalPort() == 0 {
+		return "❌ Socket UDP indisponível para hole punching"
+	}
+
+	var candidates []string
+	if len(args) > 1 {
+		peer, err := findConnectedPeer(args[1])
+		if err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		candidates = []string{peer}
+	} else {
+		peersMutex.Lock()
+		for addr, info := range peerInfos {
+			if info.UDPPort > 0 && info.ID != target {
+				candidates = append(candidates, addr)
+			}
+		}
+		peersMutex.Unlock()
+	}
+	if len(candidates) == 0 {
+		return "❌ Nenhum peer conectado pode servir de rendezvous"
+	}
+
+	data, err := json.Marshal(RendezvousRequest{Target: target})
+	if err != nil {
+		return fmt.Sprintf("❌ Erro ao montar pedido: %v", err)
+	}
+
+	rdvMutex.Lock()
+	for id, old := range rdvPunches {
+		if time.Since(old.created) > rendezvousTimeout {
+			delete(rdvPunches, id)
+		}
+	}
+	rdvPunches[target] = &pendingPunch{asked: len(candidates), created: time.Now()}
+	rdvMutex.Unlock()
+
+	for _, peer := range candidates {
+		peerSend(peer, priorityControl, "[RDV_REQ]"+string(data))
+	}
+	return fmt.Sprintf("🕳️ Pedindo conexão direta com %s a %d peer(s)...", identity.Short(target), len(candidates))
+}
+
+// handleRendezvousRequest atua como rendezvous entre quem pediu e o alvo
+func handleRendezvousRequest(remote string, data string) {
+	var req RendezvousRequest
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		logMessage(fmt.Sprintf("Pedido de rendezvous inválido de %s", remote))
+		return
+	}
+
+	fail := func(reason string) {
+		reply, _ := json.Marshal(RendezvousFail{Target: req.Target, Reason: reason})
+		peerSend(remote, priorityControl, "[RDV_FAIL]"+string(reply))
+	}
+
+	target, ok := findPeerByID(req.Target)
+	if !ok {
+		fail("peer desconhecido")
+		return
+	}
+	if quicTransport.LocalPort() == 0 {
+		fail("sem socket UDP")
+		return
+	}
+
+	peersMutex.Lock()
+	var requesterID string
+	if info, ok := peerInfos[remote]; ok {
+		requesterID = info.ID
+	}
+	peersMutex.Unlock()
+
+	intro := &rdvIntro{
+		peers:   [2]string{remote, target},
+		ids:     [2]string{requesterID, req.Target},
+		created: time.Now(),
+	}
+	for i := range intro.tokens {
+		token, err := newToken()
+		if err != nil {
+			fail("erro interno")
+			return
+		}
+		intro.tokens[i] = token
+	}
+
+	rdvMutex.Lock()
+	for token, old := range rdvIntros {
+		if time.Since(old.created) > rendezvousTimeout {
+			delete(rdvIntros, token)
+		}
+	}
+	for _, token := range intro.tokens {
+		rdvIntros[token] = intro
+	}
+	rdvMutex.Unlock()
+
+	for i, peer := range intro.peers {
+		probe, _ := json.Marshal(RendezvousProbe{Token: intro.tokens[i], Port: quicTransport.LocalPort()})
+		peerSend(peer, priorityControl, "[RDV_PROBE]"+string(probe))
+	}
+	logMessage(fmt.Sprintf("Rendezvous: apresentando %s a %s", remote, target))
+}
+
+// handleRendezvousProbe envia o token por UDP ao rendezvous
+func handleRendezvousProbe(remote string, data string) {
+	var probe RendezvousProbe
+	if err := json.Unmarshal([]byte(data), &probe); err != nil || probe.Port <= 0 {
+		return
+	}
+
+	// Só conexões diretas revelam o IP do rendezvous
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(remote, "quic://"))
+	if err != nil || strings.Contains(host, "://") {
+		logMessage(fmt.Sprintf("Rendezvous %s não tem endereço IP utilizável", remote))
+		return
+	}
+	addr := net.JoinHostPort(host, fmt.Sprint(probe.Port))
+
+	rdvMutex.Lock()
+	for token, old := range rdvProbes {
+		if time.Since(old.created) > rendezvousTimeout {
+			delete(rdvProbes, token)
+		}
+	}
+	rdvProbes[probe.Token] = rdvProbe{remote: remote, created: time.Now()}
+	rdvMutex.Unlock()
+
+	go func() {
+		for i := 0; i < probeAttempts; i++ {
+			quicTransport.SendPunch(addr, []byte("PROBE "+probe.Token))
+			time.Sleep(punchInterval)
+		}
+	}()
+}
+
+// handlePunch trata os datagramas de hole punching. Chamado pelo leitor do
+// socket UDP, então não pode bloquear.
+func handlePunch(msg []byte, from netip.AddrPort) {
+	token, ok := strings.CutPrefix(string(msg), "PROBE ")
+	if !ok {
+		// "HELLO" só serve para abrir o NAT
+		return
+	}
+
+	rdvMutex.Lock()
+	intro, ok := rdvIntros[token]
+	if !ok {
+		rdvMutex.Unlock()
+		return
+	}
+	for i, t := range intro.tokens {
+		if t == token {
+			intro.addrs[i] = from
+		}
+	}
+	ready := intro.addrs[0].IsValid() && intro.addrs[1].IsValid()
+	if ready {
+		for _, t := range intro.tokens {
+			delete(rdvIntros, t)
+		}
+	}
+	rdvMutex.Unlock()
+
+	if ready {
+		go introducePeers(intro)
+	}
+}
+
+// introducePeers envia a cada peer o endereço público do outro
+func introducePeers(intro *rdvIntro) {
+	for i, peer := range intro.peers {
+		other := 1 - i
+		data, err := json.Marshal(RendezvousPeer{
+			ID:    intro.ids[other],
+			Addr:  intro.addrs[other].String(),
+			Dial:  i == 0,
+			Token: intro.tokens[i],
+		})
+		if err != nil {
+			return
+		}
+		peerSend(peer, priorityControl, "[RDV_PEER]"+string(data))
+	}
+	logMessage(fmt.Sprintf("Rendezvous: %s ↔ %s", intro.addrs[0], intro.addrs[1]))
+}
+
+// handleRendezvousPeer abre o NAT para o outro peer e, se fomos nós que
+// pedimos, disca via QUIC
+func handleRendezvousPeer(remote string, data string) {
+	var peer RendezvousPeer
+	if err := json.Unmarshal([]byte(data), &peer); err != nil {
+		return
+	}
+	if !acceptRendezvousPeer(remote, peer) {
+		return
+	}
+
+	if peer.Dial {
+		updateChatView(fmt.Sprintf("🕳️ Endereço público de %s: %s (via %s). Abrindo caminho...",
+			identity.Short(peer.ID), peer.Addr, remote))
+	} else {
+		logMessage(fmt.Sprintf("Abrindo NAT para %s a pedido de %s", peer.Addr, remote))
+	}
+
+	go punch(peer.Addr)
+	if peer.Dial {
+		go connectToPeer("quic://" + peer.Addr)
+	}
+}
+
+// acceptRendezvousPeer confere um [RDV_PEER]: o endereço precisa ser
+// IP:porta, o token o de um [RDV_PROBE] recente do mesmo peer e, para discar,
+// o ID o de um pedido nosso ainda válido
+func acceptRendezvousPeer(remote string, peer RendezvousPeer) bool {
+	if _, err := netip.ParseAddrPort(peer.Addr); err != nil {
+		return false
+	}
+
+	// Só vale a resposta a um [RDV_PROBE] que este mesmo peer nos enviou
+	rdvMutex.Lock()
+	probe, ok := rdvProbes[peer.Token]
+	if ok && probe.remote == remote {
+		delete(rdvProbes, peer.Token)
+	}
+	rdvMutex.Unlock()
+	if !ok || probe.remote != remote || time.Since(probe.created) > rendezvousTimeout {
+		logMessage(fmt.Sprintf("[RDV_PEER] de %s sem [RDV_PROBE] correspondente; ignorado", remote))
+		return false
+	}
+	if !peer.Dial {
+		return true
+	}
+
+	// Só discamos se pedimos esse peer há pouco; um pedido sem resposta
+	// não pode ser aproveitado dias depois
+	rdvMutex.Lock()
+	pending, requested := rdvPunches[peer.ID]
+	delete(rdvPunches, peer.ID)
+	rdvMutex.Unlock()
+	return requested && time.Since(pending.created) <= rendezvousTimeout
+}
+
+// punch envia datagramas ao outro peer por alguns segundos
+func punch(addr string) {
+	deadline := time.Now().Add(punchDuration)
+	for time.Now().Before(deadline) {
+		if err := quicTransport.SendPunch(addr, []byte("HELLO "+localIdentity.ID)); err != nil {
+			return
+		}
+		time.Sleep(punchInterval)
+	}
+}
+
+// handleRendezvousFail avisa quando nenhum rendezvous conseguiu apresentar os peers
+func handleRendezvousFail(remote string, data string) {
+	var fail RendezvousFail
+	if err := json.Unmarshal([]byte(data), &fail); err != nil {
+		return
+	}
+	logMessage(fmt.Sprintf("Rendezvous %s falhou para %s: %s", remote, fail.Target, fail.Reason))
+
+	rdvMutex.Lock()
+	pending, ok := rdvPunches[fail.Target]
+	allFailed := false
+	if ok {
+		pending.failed++
+		if pending.failed >= pending.asked {
+			delete(rdvPunches, fail.Target)
+			allFailed = true
+		}
+	}
+	rdvMutex.Unlock()
+
+	if allFailed {
+		updateChatView(fmt.Sprintf("❌ Nenhum peer conseguiu apresentar %s (%s)", identity.Short(fail.Target), fail.Reason))
+	}
+}
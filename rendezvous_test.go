@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"magician/transport"
+)
+
+// testQUIC cria um transporte QUIC com certificado autoassinado
+func testQUIC(t *testing.T) *transport.QUICTransport {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}
+	return transport.NewQUIC(config, config)
+}
+
+// resetRendezvous limpa o estado do hole punching e grava os logs em um
+// diretório temporário
+func resetRendezvous(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	rdvMutex.Lock()
+	rdvIntros = make(map[string]*rdvIntro)
+	rdvProbes = make(map[string]rdvProbe)
+	rdvPunches = make(map[string]*pendingPunch)
+	rdvMutex.Unlock()
+}
+
+// No papel de rendezvous, o nó aprende o endereço UDP de cada lado pelo
+// token que chega no datagrama, e só apresenta os dois quando ambos chegam
+func TestRendezvousLearnsPublicAddresses(t *testing.T) {
+	resetRendezvous(t)
+
+	old := quicTransport
+	quicTransport = testQUIC(t)
+	quicTransport.OnPunch(handlePunch)
+	t.Cleanup(func() { quicTransport = old })
+	ln, err := quicTransport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	idA, idB := strings.Repeat("a", 52), strings.Repeat("b", 52)
+	peersMutex.Lock()
+	peerInfos["10.0.0.1:9000"] = &PeerInfo{ID: idA}
+	peerInfos["10.0.0.2:9000"] = &PeerInfo{ID: idB}
+	peersMutex.Unlock()
+	t.Cleanup(func() {
+		peersMutex.Lock()
+		delete(peerInfos, "10.0.0.1:9000")
+		delete(peerInfos, "10.0.0.2:9000")
+		peersMutex.Unlock()
+	})
+
+	req, _ := json.Marshal(RendezvousRequest{Target: idB})
+	handleRendezvousRequest("10.0.0.1:9000", string(req))
+
+	rdvMutex.Lock()
+	var intro *rdvIntro
+	for _, i := range rdvIntros {
+		intro = i
+	}
+	rdvMutex.Unlock()
+	if intro == nil || intro.ids != [2]string{idA, idB} {
+		t.Fatalf("apresentação não registrada: %+v", intro)
+	}
+
+	// Os dois lados mandam o token pelo próprio socket UDP
+	rdvAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(quicTransport.LocalPort()))
+	var sides [2]*transport.QUICTransport
+	for i := range sides {
+		sides[i] = testQUIC(t)
+		side, err := sides[i].Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer side.Close()
+	}
+	sides[0].SendPunch(rdvAddr, []byte("PROBE "+intro.tokens[0]))
+
+	// Com um lado só, ainda não há apresentação
+	time.Sleep(100 * time.Millisecond)
+	rdvMutex.Lock()
+	_, waiting := rdvIntros[intro.tokens[1]]
+	rdvMutex.Unlock()
+	if !waiting {
+		t.Fatal("apresentação feita com um lado só")
+	}
+
+	sides[1].SendPunch(rdvAddr, []byte("PROBE "+intro.tokens[1]))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rdvMutex.Lock()
+		_, waiting = rdvIntros[intro.tokens[0]]
+		addrs := intro.addrs
+		rdvMutex.Unlock()
+		if !waiting {
+			for i, side := range sides {
+				want := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(side.LocalPort()))
+				if addrs[i] != want {
+					t.Errorf("lado %d: endereço %s, esperava %s", i, addrs[i], want)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rendezvous não recebeu os dois tokens")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Um [RDV_PEER] só vale com o token de um [RDV_PROBE] recente do mesmo peer,
+// uma vez só, e só faz discar se o pedido ainda não expirou
+func TestRendezvousPeerAcceptance(t *testing.T) {
+	resetRendezvous(t)
+	const rdv, other = "10.0.0.5:9000", "10.0.0.6:9000"
+	id := strings.Repeat("c", 52)
+
+	probe := func(token string, age time.Duration) {
+		rdvMutex.Lock()
+		rdvProbes[token] = rdvProbe{remote: rdv, created: time.Now().Add(-age)}
+		rdvMutex.Unlock()
+	}
+	request := func(age time.Duration) {
+		rdvMutex.Lock()
+		rdvPunches[id] = &pendingPunch{asked: 1, created: time.Now().Add(-age)}
+		rdvMutex.Unlock()
+	}
+	peer := func(token string, dial bool) RendezvousPeer {
+		return RendezvousPeer{ID: id, Addr: "198.51.100.9:4000", Dial: dial, Token: token}
+	}
+
+	if acceptRendezvousPeer(rdv, peer("sem-probe", false)) {
+		t.Error("aceitou sem [RDV_PROBE]")
+	}
+	probe("t1", 0)
+	if acceptRendezvousPeer(other, peer("t1", false)) {
+		t.Error("aceitou token de outro peer")
+	}
+	if !acceptRendezvousPeer(rdv, peer("t1", false)) {
+		t.Error("recusou [RDV_PEER] válido")
+	}
+	if acceptRendezvousPeer(rdv, peer("t1", false)) {
+		t.Error("aceitou o mesmo token duas vezes")
+	}
+	probe("t2", 2*rendezvousTimeout)
+	if acceptRendezvousPeer(rdv, peer("t2", false)) {
+		t.Error("aceitou [RDV_PROBE] expirado")
+	}
+	bad := peer("t3", false)
+	bad.Addr = "relay://198.51.100.9:4000"
+	probe("t3", 0)
+	if acceptRendezvousPeer(rdv, bad) {
+		t.Error("aceitou endereço que não é IP:porta")
+	}
+
+	// Discar exige um pedido nosso ainda válido
+	probe("t4", 0)
+	if acceptRendezvousPeer(rdv, peer("t4", true)) {
+		t.Error("discou sem pedido")
+	}
+	probe("t5", 0)
+	request(2 * rendezvousTimeout)
+	if acceptRendezvousPeer(rdv, peer("t5", true)) {
+		t.Error("discou por um pedido expirado")
+	}
+	probe("t6", 0)
+	request(0)
+	if !acceptRendezvousPeer(rdv, peer("t6", true)) {
+		t.Error("recusou discar por um pedido válido")
+	}
+}
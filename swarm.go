@@ -0,0 +1,793 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSwarmSize         = 1024 * 1024 * 1024 // 1 GB
+	swarmPerPeerInFlight = 4                  // Pedidos simultâneos por fonte
+	swarmRequestTimeout  = 20 * time.Second
+	swarmDiscoveryWait   = 2 * time.Second
+	swarmMaxServing      = 8 // Chunks servidos em paralelo para todos os peers
+
+	// Manifestos de outros peers guardados em memória, por peer e no total
+	swarmMaxManifestsPerPeer = 64
+	swarmMaxManifests        = 1024
+)
+
+// SwarmManifest descreve um arquivo compartilhado por conteúdo. O hash do
+// arquivo é calculado sobre a lista de hashes dos chunks, então qualquer
+// fonte pode enviar o manifesto e ele é verificável pelo hash anunciado.
+type SwarmManifest struct {
+	Hash      string
+	Name      string
+	Size      int64
+	ChunkSize int
+	Chunks    []string
+}
+
+// SwarmRequest pede um chunk de um arquivo compartilhado
+type SwarmRequest struct {
+	Hash  string
+	Index int
+}
+
+// SwarmData carrega um chunk pedido com SwarmRequest
+type SwarmData struct {
+	Hash  string
+	Index int
+	Data  string
+}
+
+// sharedFile é um arquivo local que podemos servir aos peers
+type sharedFile struct {
+	Path     string
+	Manifest SwarmManifest
+}
+
+// swarmDownload acompanha o download paralelo de um arquivo
+type swarmDownload struct {
+	manifest  SwarmManifest
+	file      *os.File
+	holders   map[string]bool
+	pending   []int
+	inFlight  map[int]swarmRequestInfo
+	done      []bool
+	doneCount int
+	startedAt time.Time
+	started   bool
+	finished  bool
+
+	// Chamado ao final do download. Recebe o caminho salvo em 'recebidos/'
+	// (ou o erro) e retorna o caminho definitivo do arquivo.
+	callback func(path string, err error) string
+}
+
+type swarmRequestInfo struct {
+	peer string
+	sent time.Time
+}
+
+var (
+	sharedFiles    = make(map[string]*sharedFile)     // hash -> arquivo local
+	knownManifests = make(map[string]SwarmManifest)   // hash -> manifesto anunciado
+	swarmHolders   = make(map[string]map[string]bool) // hash -> peers que têm o arquivo
+	swarmDownloads = make(map[string]*swarmDownload)  // hash -> download em andamento
+	swarmMutex     sync.Mutex
+	swarmServing   = make(chan struct{}, swarmMaxServing)
+)
+
+// manifestRoot calcula o hash do arquivo a partir dos hashes dos chunks
+func manifestRoot(size int64, chunkSize int, chunks []string) string {
+	h := sha256.New()
+	var header [16]byte
+	binary.BigEndian.PutUint64(header[:8], uint64(size))
+	binary.BigEndian.PutUint64(header[8:], uint64(chunkSize))
+	h.Write(header[:])
+	for _, c := range chunks {
+		raw, _ := hex.DecodeString(c)
+		h.Write(raw)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validate confere a consistência do manifesto com o hash anunciado
+func (m SwarmManifest) validate() error {
+	if m.ChunkSize != chunkSize {
+		return fmt.Errorf("tamanho de chunk não suportado: %d", m.ChunkSize)
+	}
+	if m.Size < 0 || m.Size > maxSwarmSize {
+		return fmt.Errorf("tamanho inválido: %d", m.Size)
+	}
+	expected := int((m.Size + int64(m.ChunkSize) - 1) / int64(m.ChunkSize))
+	if len(m.Chunks) != expected {
+		return fmt.Errorf("quantidade de chunks inválida")
+	}
+	for _, c := range m.Chunks {
+		if len(c) != sha256.Size*2 {
+			return fmt.Errorf("hash de chunk inválido")
+		}
+		if _, err := hex.DecodeString(c); err != nil {
+			return fmt.Errorf("hash de chunk inválido")
+		}
+	}
+	if manifestRoot(m.Size, m.ChunkSize, m.Chunks) != m.Hash {
+		return fmt.Errorf("manifesto não corresponde ao hash %s", m.Hash)
+	}
+	if _, ok := sanitizeFileName(m.Name); !ok {
+		return fmt.Errorf("nome de arquivo inválido")
+	}
+	return nil
+}
+
+// chunkLength retorna o tamanho esperado de um chunk do arquivo
+func (m SwarmManifest) chunkLength(index int) int {
+	if index == len(m.Chunks)-1 {
+		return int(m.Size - int64(index)*int64(m.ChunkSize))
+	}
+	return m.ChunkSize
+}
+
+// buildManifest lê o arquivo e calcula o hash de cada chunk
+func buildManifest(path string) (SwarmManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SwarmManifest{}, fmt.Errorf("erro ao abrir arquivo: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return SwarmManifest{}, fmt.Errorf("erro ao obter informações do arquivo: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return SwarmManifest{}, fmt.Errorf("'%s' não é um arquivo regular", path)
+	}
+	if info.Size() > maxSwarmSize {
+		return SwarmManifest{}, fmt.Errorf("arquivo muito grande (limite: 1GB)")
+	}
+
+	m := SwarmManifest{Name: filepath.Base(path), ChunkSize: chunkSize}
+	buffer := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(f, buffer)
+		if n > 0 {
+			sum := sha256.Sum256(buffer[:n])
+			m.Chunks = append(m.Chunks, hex.EncodeToString(sum[:]))
+			m.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return SwarmManifest{}, fmt.Errorf("erro ao ler arquivo: %v", err)
+		}
+	}
+
+	m.Hash = manifestRoot(m.Size, m.ChunkSize, m.Chunks)
+	return m, nil
+}
+
+// shareFile registra um arquivo local para ser servido por hash
+func shareFile(path string) (SwarmManifest, error) {
+	m, err := buildManifest(path)
+	if err != nil {
+		return m, err
+	}
+
+	swarmMutex.Lock()
+	sharedFiles[m.Hash] = &sharedFile{Path: path, Manifest: m}
+	knownManifests[m.Hash] = m
+	swarmMutex.Unlock()
+
+	return m, nil
+}
+
+// announceManifest avisa os peers que temos o arquivo
+func announceManifest(m SwarmManifest) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	for _, peer := range connectedPeers() {
+		peerSend(peer, priorityControl, "[SWARM_ANNOUNCE]"+string(data))
+	}
+}
+
+// addHolder registra um manifesto válido e o peer que o possui. Retorna
+// false se o manifesto for novo e o peer (ou o total) já estiver no limite.
+func addHolder(peer string, m SwarmManifest) bool {
+	swarmMutex.Lock()
+	defer swarmMutex.Unlock()
+
+	if _, known := knownManifests[m.Hash]; !known {
+		if len(knownManifests) >= swarmMaxManifests {
+			return false
+		}
+		held := 0
+		for _, holders := range swarmHolders {
+			if holders[peer] {
+				held++
+			}
+		}
+		if held >= swarmMaxManifestsPerPeer {
+			return false
+		}
+		knownManifests[m.Hash] = m
+	}
+	if swarmHolders[m.Hash] == nil {
+		swarmHolders[m.Hash] = make(map[string]bool)
+	}
+	swarmHolders[m.Hash][peer] = true
+
+	if d, ok := swarmDownloads[m.Hash]; ok && !d.finished {
+		d.holders[peer] = true
+	}
+	return true
+}
+
+// handleSwarmAnnounce trata o anúncio de um arquivo disponível em um peer
+func handleSwarmAnnounce(peer string, data string) {
+	var m SwarmManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		logMessage(fmt.Sprintf("Anúncio de arquivo inválido de %s: %v", peer, err))
+		return
+	}
+	if err := m.validate(); err != nil {
+		logMessage(fmt.Sprintf("Anúncio de arquivo rejeitado de %s: %v", peer, err))
+		return
+	}
+
+	swarmMutex.Lock()
+	_, known := knownManifests[m.Hash]
+	swarmMutex.Unlock()
+
+	if !addHolder(peer, m) {
+		logMessage(fmt.Sprintf("Anúncio de arquivo ignorado de %s: limite de manifestos atingido", peer))
+		return
+	}
+	if !known {
+		updateChatView(fmt.Sprintf("📢 %s compartilhou '%s' (%s) — use /baixar %s",
+			peer, m.Name, formatBytes(m.Size), m.Hash[:12]))
+	}
+	dispatchSwarm(m.Hash)
+}
+
+// handleSwarmHave registra um peer que respondeu ter o arquivo procurado
+func handleSwarmHave(peer string, data string) {
+	var m SwarmManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return
+	}
+	if err := m.validate(); err != nil {
+		logMessage(fmt.Sprintf("Resposta de arquivo rejeitada de %s: %v", peer, err))
+		return
+	}
+
+	if !addHolder(peer, m) {
+		return
+	}
+	dispatchSwarm(m.Hash)
+}
+
+// handleSwarmWho responde se temos o arquivo pedido
+func handleSwarmWho(peer string, hash string) {
+	swarmMutex.Lock()
+	sf, ok := sharedFiles[hash]
+	swarmMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(sf.Manifest)
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityControl, "[SWARM_HAVE]"+string(data))
+}
+
+// handleSwarmRequest serve um chunk de um arquivo compartilhado
+func handleSwarmRequest(peer string, data string) {
+	var req SwarmRequest
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		return
+	}
+
+	swarmMutex.Lock()
+	sf, ok := sharedFiles[req.Hash]
+	swarmMutex.Unlock()
+	if !ok || req.Index < 0 || req.Index >= len(sf.Manifest.Chunks) {
+		return
+	}
+
+	// Limita quantos chunks servimos ao mesmo tempo; pedidos excedentes são
+	// descartados e o solicitante os refaz após o timeout
+	select {
+	case swarmServing <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-swarmServing }()
+		serveSwarmChunk(peer, sf, req.Index)
+	}()
+}
+
+func serveSwarmChunk(peer string, sf *sharedFile, index int) {
+	f, err := os.Open(sf.Path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	buffer := make([]byte, sf.Manifest.chunkLength(index))
+	if _, err := f.ReadAt(buffer, int64(index)*int64(sf.Manifest.ChunkSize)); err != nil {
+		return
+	}
+
+	// Se o arquivo mudou desde o anúncio, deixamos de compartilhá-lo
+	sum := sha256.Sum256(buffer)
+	if hex.EncodeToString(sum[:]) != sf.Manifest.Chunks[index] {
+		swarmMutex.Lock()
+		delete(sharedFiles, sf.Manifest.Hash)
+		swarmMutex.Unlock()
+		logMessage(fmt.Sprintf("Arquivo '%s' mudou e deixou de ser compartilhado", sf.Path))
+		return
+	}
+
+	data, err := json.Marshal(SwarmData{
+		Hash:  sf.Manifest.Hash,
+		Index: index,
+		Data:  base64.StdEncoding.EncodeToString(buffer),
+	})
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityFile, "[SWARM_DATA]"+string(data))
+}
+
+// handleSwarmData verifica e grava um chunk recebido
+func handleSwarmData(peer string, data string) {
+	var msg SwarmData
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return
+	}
+
+	swarmMutex.Lock()
+	d, ok := swarmDownloads[msg.Hash]
+	if !ok || d.finished || msg.Index < 0 || msg.Index >= len(d.done) {
+		swarmMutex.Unlock()
+		return
+	}
+
+	req, requested := d.inFlight[msg.Index]
+	if !requested || req.peer != peer || d.done[msg.Index] {
+		swarmMutex.Unlock()
+		return
+	}
+	delete(d.inFlight, msg.Index)
+
+	chunk, err := base64.StdEncoding.DecodeString(msg.Data)
+	sum := sha256.Sum256(chunk)
+	if err != nil || len(chunk) != d.manifest.chunkLength(msg.Index) ||
+		hex.EncodeToString(sum[:]) != d.manifest.Chunks[msg.Index] {
+		// Fonte enviou dados que não batem com o hash: descarta a fonte
+		delete(d.holders, peer)
+		d.pending = append(d.pending, msg.Index)
+		swarmMutex.Unlock()
+		logMessage(fmt.Sprintf("Chunk %d de '%s' inválido vindo de %s; fonte descartada", msg.Index, d.manifest.Name, peer))
+		updateChatView(fmt.Sprintf("⚠️ %s enviou dados inválidos para '%s' e foi descartado como fonte", peer, d.manifest.Name))
+		dispatchSwarm(msg.Hash)
+		return
+	}
+
+	if _, err := d.file.WriteAt(chunk, int64(msg.Index)*int64(d.manifest.ChunkSize)); err != nil {
+		d.finished = true
+		swarmMutex.Unlock()
+		failSwarmDownload(d, fmt.Sprintf("erro ao gravar: %v", err))
+		return
+	}
+
+	d.done[msg.Index] = true
+	d.doneCount++
+	complete := d.doneCount == len(d.done)
+	if complete {
+		d.finished = true
+	}
+	swarmMutex.Unlock()
+
+	if complete {
+		finishSwarmDownload(d)
+		return
+	}
+	dispatchSwarm(msg.Hash)
+}
+
+// startSwarmDownload inicia o download de um arquivo pelo hash (ou prefixo conhecido)
+func startSwarmDownload(hashPrefix string) (string, error) {
+	hash, err := resolveSwarmHash(hashPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	swarmMutex.Lock()
+	_, exists := sharedFiles[hash]
+	swarmMutex.Unlock()
+	if exists {
+		return "", fmt.Errorf("você já possui este arquivo")
+	}
+
+	if err := fetchByHash(hash, nil); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// fetchByHash baixa um arquivo de todas as fontes que responderem ao pedido
+func fetchByHash(hash string, callback func(path string, err error) string) error {
+	swarmMutex.Lock()
+	if _, exists := swarmDownloads[hash]; exists {
+		swarmMutex.Unlock()
+		return fmt.Errorf("download já em andamento")
+	}
+	d := &swarmDownload{
+		holders:   make(map[string]bool),
+		inFlight:  make(map[int]swarmRequestInfo),
+		startedAt: time.Now(),
+		callback:  callback,
+	}
+	for peer := range swarmHolders[hash] {
+		d.holders[peer] = true
+	}
+	d.manifest.Hash = hash
+	swarmDownloads[hash] = d
+	swarmMutex.Unlock()
+
+	// Pergunta a todos quem possui o arquivo
+	for _, peer := range connectedPeers() {
+		peerSend(peer, priorityControl, "[SWARM_WHO]"+hash)
+	}
+
+	go func() {
+		time.Sleep(swarmDiscoveryWait)
+		if err := beginSwarmTransfer(hash); err != nil {
+			swarmMutex.Lock()
+			delete(swarmDownloads, hash)
+			swarmMutex.Unlock()
+			updateChatView(fmt.Sprintf("❌ Download %s: %v", hash[:12], err))
+			if d.callback != nil {
+				d.callback("", err)
+			}
+			return
+		}
+		go watchSwarmDownload(hash)
+		dispatchSwarm(hash)
+	}()
+
+	return nil
+}
+
+// resolveSwarmHash completa um prefixo de hash com os manifestos conhecidos
+func resolveSwarmHash(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) == sha256.Size*2 {
+		return prefix, nil
+	}
+
+	swarmMutex.Lock()
+	defer swarmMutex.Unlock()
+
+	var found []string
+	for hash := range knownManifests {
+		if strings.HasPrefix(hash, prefix) {
+			found = append(found, hash)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("nenhum arquivo conhecido com hash '%s'", prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("hash '%s' é ambíguo, informe mais caracteres", prefix)
+	}
+}
+
+// beginSwarmTransfer prepara o arquivo parcial assim que houver manifesto e fontes
+func beginSwarmTransfer(hash string) error {
+	swarmMutex.Lock()
+	defer swarmMutex.Unlock()
+
+	d := swarmDownloads[hash]
+	m, ok := knownManifests[hash]
+	if !ok {
+		return fmt.Errorf("nenhum peer possui o arquivo")
+	}
+	d.manifest = m
+	if len(d.holders) == 0 {
+		return fmt.Errorf("nenhuma fonte disponível")
+	}
+
+	os.MkdirAll(receivedDir, 0755)
+	f, err := os.OpenFile(filepath.Join(receivedDir, ".swarm-"+hash[:16]+".part"),
+		os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("erro ao criar arquivo parcial: %v", err)
+	}
+
+	d.file = f
+	d.done = make([]bool, len(d.manifest.Chunks))
+	d.pending = make([]int, len(d.manifest.Chunks))
+	for i := range d.pending {
+		d.pending[i] = i
+	}
+	d.started = true
+
+	updateChatView(fmt.Sprintf("📥 Baixando '%s' (%s) de %d fonte(s)...",
+		d.manifest.Name, formatBytes(d.manifest.Size), len(d.holders)))
+
+	if len(d.done) == 0 {
+		d.finished = true
+		go finishSwarmDownload(d)
+	}
+	return nil
+}
+
+// dispatchSwarm distribui chunks pendentes entre as fontes com capacidade livre
+func dispatchSwarm(hash string) {
+	type request struct {
+		peer  string
+		index int
+	}
+	var requests []request
+
+	swarmMutex.Lock()
+	d, ok := swarmDownloads[hash]
+	if !ok || !d.started || d.finished {
+		swarmMutex.Unlock()
+		return
+	}
+
+	load := make(map[string]int)
+	for _, info := range d.inFlight {
+		load[info.peer]++
+	}
+
+	holders := make([]string, 0, len(d.holders))
+	for peer := range d.holders {
+		holders = append(holders, peer)
+	}
+	sort.Strings(holders)
+
+	// Round-robin entre as fontes até esgotar os pendentes ou a capacidade
+	for len(d.pending) > 0 {
+		assigned := false
+		for _, peer := range holders {
+			if len(d.pending) == 0 {
+				break
+			}
+			if load[peer] >= swarmPerPeerInFlight {
+				continue
+			}
+			index := d.pending[0]
+			d.pending = d.pending[1:]
+			if d.done[index] {
+				continue
+			}
+			d.inFlight[index] = swarmRequestInfo{peer: peer, sent: time.Now()}
+			load[peer]++
+			requests = append(requests, request{peer, index})
+			assigned = true
+		}
+		if !assigned {
+			break
+		}
+	}
+	swarmMutex.Unlock()
+
+	for _, r := range requests {
+		data, _ := json.Marshal(SwarmRequest{Hash: hash, Index: r.index})
+		if !peerSend(r.peer, priorityControl, "[SWARM_REQ]"+string(data)) {
+			// Peer desconectado: devolve o chunk para a fila e remove a fonte
+			swarmMutex.Lock()
+			delete(d.inFlight, r.index)
+			delete(d.holders, r.peer)
+			d.pending = append(d.pending, r.index)
+			swarmMutex.Unlock()
+		}
+	}
+}
+
+// watchSwarmDownload refaz pedidos que expiraram e detecta downloads sem fontes
+func watchSwarmDownload(hash string) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		swarmMutex.Lock()
+		d, ok := swarmDownloads[hash]
+		if !ok || d.finished {
+			swarmMutex.Unlock()
+			return
+		}
+
+		now := time.Now()
+		for index, info := range d.inFlight {
+			if now.Sub(info.sent) > swarmRequestTimeout {
+				delete(d.inFlight, index)
+				d.pending = append(d.pending, index)
+			}
+		}
+
+		noSources := len(d.holders) == 0
+		if noSources {
+			d.finished = true
+		}
+		swarmMutex.Unlock()
+
+		if noSources {
+			failSwarmDownload(d, "todas as fontes foram perdidas")
+			return
+		}
+		dispatchSwarm(hash)
+	}
+}
+
+// failSwarmDownload descarta um download que não pode continuar
+func failSwarmDownload(d *swarmDownload, reason string) {
+	swarmMutex.Lock()
+	delete(swarmDownloads, d.manifest.Hash)
+	swarmMutex.Unlock()
+
+	if d.file != nil {
+		d.file.Close()
+		os.Remove(d.file.Name())
+	}
+	updateChatView(fmt.Sprintf("❌ Download de '%s' falhou: %s", d.manifest.Name, reason))
+	logMessage(fmt.Sprintf("Download por hash %s falhou: %s", d.manifest.Hash, reason))
+	if d.callback != nil {
+		d.callback("", fmt.Errorf("%s", reason))
+	}
+}
+
+// finishSwarmDownload move o arquivo completo para 'recebidos/' e passa a compartilhá-lo
+func finishSwarmDownload(d *swarmDownload) {
+	name, _ := sanitizeFileName(d.manifest.Name)
+	partialPath := d.file.Name()
+	d.file.Close()
+
+	finalPath := uniqueReceivedPath(name)
+	if err := os.Rename(partialPath, finalPath); err != nil {
+		os.Remove(partialPath)
+		swarmMutex.Lock()
+		delete(swarmDownloads, d.manifest.Hash)
+		swarmMutex.Unlock()
+		updateChatView(fmt.Sprintf("❌ Erro ao salvar '%s': %v", name, err))
+		if d.callback != nil {
+			d.callback("", err)
+		}
+		return
+	}
+	os.Chmod(finalPath, 0644)
+
+	if d.callback != nil {
+		finalPath = d.callback(finalPath, nil)
+	}
+
+	swarmMutex.Lock()
+	delete(swarmDownloads, d.manifest.Hash)
+	sharedFiles[d.manifest.Hash] = &sharedFile{Path: finalPath, Manifest: d.manifest}
+	sources := len(d.holders)
+	swarmMutex.Unlock()
+
+	elapsed := time.Since(d.startedAt).Round(time.Second)
+	updateChatView(fmt.Sprintf("✅ '%s' baixado de %d fonte(s) em %s e salvo em '%s'",
+		name, sources, elapsed, finalPath))
+	logMessage(fmt.Sprintf("Arquivo '%s' (%s) baixado por hash e salvo em %s", name, d.manifest.Hash, finalPath))
+
+	// Agora também somos fonte do arquivo
+	announceManifest(d.manifest)
+}
+
+// forgetSwarmPeer remove um peer desconectado das fontes conhecidas. Os
+// manifestos que ficam sem fonte, e que não são locais nem estão sendo
+// baixados, são esquecidos.
+func forgetSwarmPeer(peer string) {
+	swarmMutex.Lock()
+	defer swarmMutex.Unlock()
+
+	for hash, holders := range swarmHolders {
+		delete(holders, peer)
+		if len(holders) > 0 {
+			continue
+		}
+		delete(swarmHolders, hash)
+		_, local := sharedFiles[hash]
+		_, downloading := swarmDownloads[hash]
+		if !local && !downloading {
+			delete(knownManifests, hash)
+		}
+	}
+	for _, d := range swarmDownloads {
+		delete(d.holders, peer)
+		for index, info := range d.inFlight {
+			if info.peer == peer {
+				delete(d.inFlight, index)
+				d.pending = append(d.pending, index)
+			}
+		}
+	}
+}
+
+// cmdShare compartilha um arquivo local por hash
+func cmdShare(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /compartilhar <arquivo>"
+	}
+
+	m, err := shareFile(args[0])
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	announceManifest(m)
+	logMessage(fmt.Sprintf("Compartilhando '%s' com hash %s", m.Name, m.Hash))
+	return fmt.Sprintf("📢 Compartilhando '%s' (%s)\nHash: %s", m.Name, formatBytes(m.Size), m.Hash)
+}
+
+// cmdDownload baixa um arquivo compartilhado de todas as fontes disponíveis
+func cmdDownload(args []string) string {
+	if len(args) < 1 {
+		return "Uso: /baixar <hash>"
+	}
+
+	hash, err := startSwarmDownload(args[0])
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	return fmt.Sprintf("🔎 Procurando fontes para %s...", hash[:12])
+}
+
+// cmdListShared lista arquivos compartilhados, anunciados e downloads em andamento
+func cmdListShared(args []string) string {
+	swarmMutex.Lock()
+	defer swarmMutex.Unlock()
+
+	if len(knownManifests) == 0 && len(swarmDownloads) == 0 {
+		return "Nenhum arquivo compartilhado."
+	}
+
+	hashes := make([]string, 0, len(knownManifests))
+	for hash := range knownManifests {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	result := "📢 Arquivos compartilhados:\n"
+	for _, hash := range hashes {
+		m := knownManifests[hash]
+		status := fmt.Sprintf("%d fonte(s)", len(swarmHolders[hash]))
+		if _, local := sharedFiles[hash]; local {
+			status = "local, " + status
+		}
+		if d, ok := swarmDownloads[hash]; ok && d.started && len(d.done) > 0 {
+			status = fmt.Sprintf("baixando %.1f%% de %d fonte(s)",
+				float64(d.doneCount)/float64(len(d.done))*100, len(d.holders))
+		}
+		result += fmt.Sprintf("%s  %s (%s) — %s\n", hash[:12], m.Name, formatBytes(m.Size), status)
+	}
+	return result
+}
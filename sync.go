@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/identity"
+)
+
+const (
+	syncScanInterval = 5 * time.Second
+	syncStateFile    = ".magician-sync.json" // Estado local, nunca sincronizado
+	maxSyncEntries   = 10000
+)
+
+// SyncEntry descreve um arquivo da pasta sincronizada
+type SyncEntry struct {
+	Path string // Caminho relativo, sempre com '/'
+	Hash string // Hash de conteúdo (o mesmo usado por /baixar)
+	Size int64
+}
+
+// SyncManifest é enviado aos peers confiáveis quando a pasta muda
+type SyncManifest struct {
+	Files []SyncEntry
+}
+
+// syncLocalFile guarda o que sabemos de um arquivo local entre varreduras
+type syncLocalFile struct {
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+var (
+	syncDir     string                           // Pasta sincronizada ("" = desativado)
+	syncFiles   = make(map[string]syncLocalFile) // Última varredura
+	syncBase    = make(map[string]string)        // Último hash sincronizado de cada arquivo
+	syncTrusted = make(map[string]string)        // ID comprovado -> conexão em que foi autorizado
+	syncPulling = make(map[string]string)        // Caminho -> hash sendo baixado
+	syncStop    chan struct{}
+	syncMutex   sync.Mutex
+)
+
+// startSync ativa a sincronização da pasta informada
+func startSync(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir pasta: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' não é uma pasta", dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	syncMutex.Lock()
+	if syncDir != "" {
+		syncMutex.Unlock()
+		return fmt.Errorf("já sincronizando '%s'; use /sync parar antes", syncDir)
+	}
+	syncDir = abs
+	syncFiles = make(map[string]syncLocalFile)
+	syncBase = loadSyncState(abs)
+	syncStop = make(chan struct{})
+	stop := syncStop
+	syncMutex.Unlock()
+
+	scanSyncFolder()
+	go watchSyncFolder(stop)
+
+	// Pede os manifestos atuais dos peers confiáveis
+	for _, peer := range trustedSyncPeers() {
+		peerSend(peer, priorityControl, "[SYNC_REQ]")
+	}
+
+	logMessage("Sincronização ativada para " + abs)
+	return nil
+}
+
+// stopSync desativa a sincronização
+func stopSync() bool {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	if syncDir == "" {
+		return false
+	}
+	close(syncStop)
+	saveSyncState(syncDir, syncBase)
+	logMessage("Sincronização desativada para " + syncDir)
+	syncDir = ""
+	return true
+}
+
+// loadSyncState lê os hashes da última sincronização gravados na pasta
+func loadSyncState(dir string) map[string]string {
+	base := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, syncStateFile))
+	if err == nil {
+		json.Unmarshal(data, &base)
+	}
+	return base
+}
+
+// saveSyncState grava os hashes da última sincronização.
+// Deve ser chamada com syncMutex travado.
+func saveSyncState(dir string, base map[string]string) {
+	data, err := json.MarshalIndent(base, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, syncStateFile), data, 0600); err != nil {
+		logMessage(fmt.Sprintf("Erro ao salvar estado da sincronização: %v", err))
+	}
+}
+
+// watchSyncFolder varre a pasta periodicamente até a sincronização ser desativada
+func watchSyncFolder(stop chan struct{}) {
+	ticker := time.NewTicker(syncScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			scanSyncFolder()
+		}
+	}
+}
+
+// scanSyncFolder detecta arquivos novos ou alterados, compartilha-os por hash
+// e anuncia o novo manifesto aos peers confiáveis
+func scanSyncFolder() {
+	syncMutex.Lock()
+	dir := syncDir
+	previous := syncFiles
+	syncMutex.Unlock()
+	if dir == "" {
+		return
+	}
+
+	current := make(map[string]syncLocalFile)
+	changed := false
+
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || len(current) >= maxSyncEntries {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == syncStateFile || strings.HasSuffix(rel, ".magician-tmp") {
+			return nil
+		}
+
+		// Reaproveita o hash se tamanho e data não mudaram
+		if old, ok := previous[rel]; ok && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+			current[rel] = old
+			return nil
+		}
+
+		m, err := shareFile(p)
+		if err != nil {
+			return nil
+		}
+		current[rel] = syncLocalFile{Size: info.Size(), ModTime: info.ModTime(), Hash: m.Hash}
+		if old, ok := previous[rel]; !ok || old.Hash != m.Hash {
+			changed = true
+		}
+		return nil
+	})
+
+	if len(current) != len(previous) {
+		changed = true
+	}
+
+	syncMutex.Lock()
+	if syncDir != dir {
+		syncMutex.Unlock()
+		return
+	}
+	syncFiles = current
+	syncMutex.Unlock()
+
+	if changed {
+		broadcastSyncManifest()
+	}
+}
+
+// localSyncManifest monta o manifesto a partir da última varredura
+func localSyncManifest() SyncManifest {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	var manifest SyncManifest
+	for rel, f := range syncFiles {
+		manifest.Files = append(manifest.Files, SyncEntry{Path: rel, Hash: f.Hash, Size: f.Size})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest
+}
+
+// trustedSyncPeers retorna os peers confiáveis atualmente conectados. As
+// identidades são lidas antes de travar syncMutex, que nunca é travado junto
+// com peersMutex.
+func trustedSyncPeers() []string {
+	ids := make(map[string]string)
+	for _, peer := range connectedPeers() {
+		ids[peer] = peerIdentity(peer)
+	}
+
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	var peers []string
+	for peer, id := range ids {
+		if _, ok := syncTrusted[id]; ok && id != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// forgetSyncPeer retira a confiança dada na conexão que foi encerrada
+func forgetSyncPeer(peer string) {
+	id := peerIdentity(peer)
+
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+	if id != "" && syncTrusted[id] == peer {
+		delete(syncTrusted, id)
+	}
+}
+
+// sendSyncManifest envia nosso manifesto a um peer
+func sendSyncManifest(peer string) {
+	data, err := json.Marshal(localSyncManifest())
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityControl, "[SYNC_MANIFEST]"+string(data))
+}
+
+func broadcastSyncManifest() {
+	for _, peer := range trustedSyncPeers() {
+		sendSyncManifest(peer)
+	}
+}
+
+// syncAllowed indica se um peer pode trocar manifestos conosco
+func syncAllowed(peer string) bool {
+	id := peerIdentity(peer)
+
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+	_, ok := syncTrusted[id]
+	return syncDir != "" && id != "" && ok
+}
+
+// handleSyncRequest responde a um pedido de manifesto de um peer confiável
+func handleSyncRequest(peer string) {
+	if syncAllowed(peer) {
+		sendSyncManifest(peer)
+	}
+}
+
+// handleSyncManifest compara o manifesto de um peer confiável com a pasta local
+// e baixa o que for novo ou alterado
+func handleSyncManifest(peer string, data string) {
+	if !syncAllowed(peer) {
+		logMessage(fmt.Sprintf("Manifesto de sincronização ignorado de peer não confiável %s", peer))
+		return
+	}
+
+	var manifest SyncManifest
+	if err := json.Unmarshal([]byte(data), &manifest); err != nil || len(manifest.Files) > maxSyncEntries {
+		logMessage(fmt.Sprintf("Manifesto de sincronização inválido de %s", peer))
+		return
+	}
+
+	for _, entry := range manifest.Files {
+		planSyncPull(peer, entry)
+	}
+}
+
+// planSyncPull decide o que fazer com uma entrada do manifesto remoto
+func planSyncPull(peer string, entry SyncEntry) {
+	syncMutex.Lock()
+	dir := syncDir
+	if dir == "" || len(entry.Hash) != 64 || entry.Size < 0 || entry.Size > maxSwarmSize {
+		syncMutex.Unlock()
+		return
+	}
+
+	target, ok := safeArchivePath(dir, entry.Path)
+	if !ok || filepath.Base(target) == syncStateFile {
+		syncMutex.Unlock()
+		logMessage(fmt.Sprintf("Caminho inseguro no manifesto de %s: %q", peer, entry.Path))
+		return
+	}
+	rel := filepath.ToSlash(strings.TrimPrefix(target, dir+string(filepath.Separator)))
+
+	local, exists := syncFiles[rel]
+	base := syncBase[rel]
+	if pulling, busy := syncPulling[rel]; busy && pulling == entry.Hash {
+		syncMutex.Unlock()
+		return
+	}
+
+	conflict := false
+	switch {
+	case !exists:
+		// Arquivo novo
+	case local.Hash == entry.Hash:
+		// Já sincronizado
+		syncBase[rel] = entry.Hash
+		syncMutex.Unlock()
+		return
+	case entry.Hash == base:
+		// O peer ainda tem a versão antiga; ele vai buscar a nossa
+		syncMutex.Unlock()
+		return
+	case local.Hash == base:
+		// Só o peer alterou: substituímos a cópia local
+	default:
+		// Os dois lados alteraram desde a última sincronização
+		conflict = true
+	}
+	syncPulling[rel] = entry.Hash
+	syncMutex.Unlock()
+
+	destination := target
+	if conflict {
+		destination = conflictPath(target, peer)
+		updateChatView(fmt.Sprintf("⚠️ Conflito em '%s': a versão de %s será salva como '%s'",
+			rel, peer, filepath.Base(destination)))
+	}
+
+	// Se já temos o conteúdo em outro lugar, copiamos sem usar a rede
+	swarmMutex.Lock()
+	sf, haveLocally := sharedFiles[entry.Hash]
+	swarmMutex.Unlock()
+	if haveLocally {
+		err := copyFile(sf.Path, destination)
+		completeSyncPull(rel, entry.Hash, destination, conflict, err)
+		return
+	}
+
+	err := fetchByHash(entry.Hash, func(path string, err error) string {
+		if err == nil {
+			// O arquivo chega por 'recebidos/' e é movido para a pasta sincronizada
+			err = moveFile(path, destination)
+		}
+		completeSyncPull(rel, entry.Hash, destination, conflict, err)
+		if err != nil {
+			return path
+		}
+		return destination
+	})
+	if err != nil {
+		syncMutex.Lock()
+		delete(syncPulling, rel)
+		syncMutex.Unlock()
+	}
+}
+
+// completeSyncPull registra o resultado de um arquivo sincronizado
+func completeSyncPull(rel, hash, destination string, conflict bool, err error) {
+	syncMutex.Lock()
+	delete(syncPulling, rel)
+	if err == nil && !conflict {
+		syncBase[rel] = hash
+		if syncDir != "" {
+			saveSyncState(syncDir, syncBase)
+		}
+	}
+	syncMutex.Unlock()
+
+	if err != nil {
+		updateChatView(fmt.Sprintf("❌ Falha ao sincronizar '%s': %v", rel, err))
+		logMessage(fmt.Sprintf("Falha ao sincronizar '%s': %v", rel, err))
+		return
+	}
+	updateChatView(fmt.Sprintf("🔄 '%s' sincronizado", filepath.Base(destination)))
+	logMessage(fmt.Sprintf("Arquivo sincronizado: %s (%s)", destination, hash))
+}
+
+// conflictPath gera o nome da cópia de conflito ao lado do arquivo original
+func conflictPath(target, peer string) string {
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	label := strings.NewReplacer(":", "_", "/", "_", "\\", "_", "[", "", "]", "").Replace(peer)
+	return fmt.Sprintf("%s.conflito-%s-%s%s", base, label, time.Now().Format("20060102-150405"), ext)
+}
+
+// moveFile move um arquivo, copiando quando a origem está em outro sistema de arquivos
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copia src para dst através de um arquivo temporário
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".magician-tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// findConnectedPeer procura um peer conectado pelo endereço exato, pelo IP
+// ou pelo ID. Serve a decisões de confiança, então trechos do endereço não
+// valem e mais de um peer correspondente é um erro.
+func findConnectedPeer(target string) (string, error) {
+	var matches []string
+	for _, peer := range connectedPeers() {
+		if peerAddressEquals(peer, target) || peerIdentity(peer) == strings.ToLower(target) {
+			matches = append(matches, peer)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("peer '%s' não encontrado (use host:porta ou o ID)", target)
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("'%s' corresponde a mais de um peer (%s); use host:porta ou o ID", target, strings.Join(matches, ", "))
+}
+
+// cmdSync controla a sincronização de pasta
+func cmdSync(args []string) string {
+	usage := "Uso: /sync <pasta> | /sync parar | /sync confiar <peer> | /sync desconfiar <peer>"
+
+	if len(args) == 0 {
+		syncMutex.Lock()
+		defer syncMutex.Unlock()
+		if syncDir == "" {
+			return "🔄 Sincronização desativada.\n" + usage
+		}
+		result := fmt.Sprintf("🔄 Sincronizando '%s' (%d arquivos)\n", syncDir, len(syncFiles))
+		result += "Peers confiáveis:"
+		if len(syncTrusted) == 0 {
+			result += " nenhum"
+		}
+		for id, peer := range syncTrusted {
+			result += fmt.Sprintf(" %s (%s)", peer, identity.Short(id))
+		}
+		return result
+	}
+
+	switch args[0] {
+	case "parar", "stop":
+		if !stopSync() {
+			return "Sincronização não está ativa."
+		}
+		return "🔄 Sincronização desativada."
+	case "confiar", "trust":
+		if len(args) < 2 {
+			return usage
+		}
+		peer, err := findConnectedPeer(args[1])
+		if err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		id := peerIdentity(peer)
+		if id == "" {
+			return fmt.Sprintf("Peer '%s' não encontrado.", args[1])
+		}
+		syncMutex.Lock()
+		syncTrusted[id] = peer
+		active := syncDir != ""
+		syncMutex.Unlock()
+		if active {
+			sendSyncManifest(peer)
+			peerSend(peer, priorityControl, "[SYNC_REQ]")
+		}
+		logMessage("Peer confiável para sincronização: " + peer)
+		return fmt.Sprintf("🤝 %s agora pode sincronizar a pasta com você", peer)
+	case "desconfiar", "untrust":
+		if len(args) < 2 {
+			return usage
+		}
+		peer, err := findConnectedPeer(args[1])
+		if err != nil {
+			peer = args[1]
+		}
+		id := peerIdentity(peer)
+		syncMutex.Lock()
+		for trustedID, addr := range syncTrusted {
+			if trustedID == id || trustedID == args[1] || addr == peer {
+				delete(syncTrusted, trustedID)
+			}
+		}
+		syncMutex.Unlock()
+		return fmt.Sprintf("%s não sincroniza mais com você", peer)
+	default:
+		if err := startSync(args[0]); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		return fmt.Sprintf("🔄 Sincronizando '%s' com os peers confiáveis", args[0])
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakePeers registra peers conectados só nas tabelas, sem conexão real
+func fakePeers(t *testing.T, ids map[string]string) {
+	t.Helper()
+	peersMutex.Lock()
+	oldPeers, oldConns := Peers, peerConns
+	Peers = make(map[string]net.Conn)
+	peerConns = make(map[string]*peerConn)
+	for addr, id := range ids {
+		Peers[addr] = nil
+		peerConns[addr] = &peerConn{id: id}
+	}
+	peersMutex.Unlock()
+	t.Cleanup(func() {
+		peersMutex.Lock()
+		Peers, peerConns = oldPeers, oldConns
+		peersMutex.Unlock()
+	})
+}
+
+// Confiar em um peer exige o endereço exato, o IP de um único peer ou o ID
+func TestFindConnectedPeerExact(t *testing.T) {
+	idA, idB, idC := strings.Repeat("a", 52), strings.Repeat("b", 52), strings.Repeat("c", 52)
+	fakePeers(t, map[string]string{
+		"10.0.0.1:9000":  idA,
+		"10.0.0.10:9000": idB,
+		"10.0.0.7:9000":  idC,
+		"10.0.0.7:9001":  strings.Repeat("d", 52),
+	})
+
+	found := map[string]string{
+		"10.0.0.1:9000":      "10.0.0.1:9000",
+		"10.0.0.1":           "10.0.0.1:9000",
+		"[10.0.0.10]":        "10.0.0.10:9000",
+		idB:                  "10.0.0.10:9000",
+		strings.ToUpper(idC): "10.0.0.7:9000",
+	}
+	for target, want := range found {
+		if got, err := findConnectedPeer(target); err != nil || got != want {
+			t.Errorf("%q: %q %v, esperava %q", target, got, err, want)
+		}
+	}
+
+	// Trechos não valem, e um IP com dois peers é ambíguo
+	for _, target := range []string{"10.0.0", "0.0.1", ":9000", "10.0.0.7", "aaaa"} {
+		if got, err := findConnectedPeer(target); err == nil {
+			t.Errorf("%q escolheu %q", target, got)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"magician/tor"
+)
+
+const (
+	torCheckInterval = time.Minute
+	torProbeTimeout  = 2 * time.Second
+
+	// torrc conferido por /tor setup quando nenhum é informado
+	defaultTorrcPath = "/etc/tor/torrc"
+
+	torUsage = "Uso: /tor [setup [torrc]] [tudo sim|nao] [isolar sim|nao] [socks host:porta] [timeout 60s]"
+)
+
+// Última verificação do daemon do Tor
+var (
+	torStatus      tor.Status
+	torStatusMutex sync.Mutex
+
+	// O endereço SOCKS foi fixado em MAGICIAN_TOR_SOCKS ou com /tor socks;
+	// senão o dialer segue o proxy encontrado pela verificação
+	torSOCKSFixed bool
+)
+
+// Modo somente Tor: nada sai fora do Tor. Definido na inicialização e só
+// lido depois, por isso sem mutex.
+var torOnly bool
+
+// enableTorOnly liga o modo somente Tor e falha fechado: sem o Tor pronto,
+// o programa não continua
+func enableTorOnly() error {
+	torOnly = true
+
+	config := tor.Default().Config()
+	config.Everything = true
+	tor.SetDefault(config)
+
+	status := checkTor()
+	if !status.Ready() {
+		return fmt.Errorf("Tor não está pronto: %s; %s", status.Summary(), status.CircuitSummary())
+	}
+	return nil
+}
+
+// configureTorDialer monta a configuração do dialer do Tor a partir do
+// ambiente:
+//
+//	MAGICIAN_TOR_SOCKS      endereço do proxy (padrão 127.0.0.1:9050)
+//	MAGICIAN_TOR_SOCKS_USER usuário SOCKS, se o proxy exigir
+//	MAGICIAN_TOR_SOCKS_PASS senha SOCKS
+//	MAGICIAN_TOR_TUDO       "1" manda todas as conexões pelo Tor
+//	MAGICIAN_TOR_ISOLAR     "0" desliga o isolamento de circuitos por peer
+//	MAGICIAN_TOR_TIMEOUT    limite das conexões via Tor (ex: 90s)
+func configureTorDialer() error {
+	config := tor.DialerConfig{
+		SOCKSAddr: os.Getenv("MAGICIAN_TOR_SOCKS"),
+		Username:  os.Getenv("MAGICIAN_TOR_SOCKS_USER"),
+		Password:  os.Getenv("MAGICIAN_TOR_SOCKS_PASS"),
+		Isolate:   os.Getenv("MAGICIAN_TOR_ISOLAR") != "0",
+	}
+	torStatusMutex.Lock()
+	torSOCKSFixed = config.SOCKSAddr != ""
+	torStatusMutex.Unlock()
+
+	switch os.Getenv("MAGICIAN_TOR_TUDO") {
+	case "", "0":
+	case "1":
+		config.Everything = true
+	default:
+		return fmt.Errorf("MAGICIAN_TOR_TUDO deve ser 0 ou 1")
+	}
+
+	if s := os.Getenv("MAGICIAN_TOR_TIMEOUT"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("MAGICIAN_TOR_TIMEOUT inválido: %q", s)
+		}
+		config.TorTimeout = timeout
+	}
+
+	tor.SetDefault(config)
+	return nil
+}
+
+// checkTor verifica o proxy SOCKS e a porta de controle. A senha da porta de
+// controle, se o torrc usar HashedControlPassword, vem de MAGICIAN_TOR_PASSWORD.
+func checkTor() tor.Status {
+	torStatusMutex.Lock()
+	fixed := torSOCKSFixed
+	torStatusMutex.Unlock()
+
+	config := tor.Default().Config()
+	var socksAddrs []string
+	if fixed {
+		socksAddrs = []string{config.SOCKSAddr}
+	}
+	status := tor.Detect(socksAddrs, nil, os.Getenv("MAGICIAN_TOR_PASSWORD"), torProbeTimeout)
+
+	// Sem endereço fixo, usa o proxy encontrado (ex: o do Tor Browser)
+	if !fixed && status.SOCKSAddr != "" && status.SOCKSAddr != config.SOCKSAddr {
+		config.SOCKSAddr = status.SOCKSAddr
+		tor.SetDefault(config)
+	}
+
+	torStatusMutex.Lock()
+	torStatus = status
+	torStatusMutex.Unlock()
+	return status
+}
+
+// currentTorStatus retorna a última verificação, sem bloquear a interface
+func currentTorStatus() tor.Status {
+	torStatusMutex.Lock()
+	defer torStatusMutex.Unlock()
+	return torStatus
+}
+
+// monitorTor verifica o Tor periodicamente e avisa quando ele cai ou volta
+func monitorTor() {
+	prev := checkTor()
+	log.Printf("Tor: %s; %s", prev.Summary(), prev.CircuitSummary())
+
+	ticker := time.NewTicker(torCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		status := checkTor()
+		switch {
+		case status.Ready() && !prev.Ready():
+			updateChatView("🧅 Tor disponível: " + status.Summary())
+		case !status.Ready() && prev.Ready() && torOnly:
+			updateChatView("⚠️ Tor indisponível: " + status.Summary() + "; nenhuma conexão será feita até ele voltar")
+		case !status.Ready() && prev.Ready():
+			updateChatView("⚠️ Tor indisponível: " + status.Summary() + "; " + status.CircuitSummary())
+		}
+		prev = status
+	}
+}
+
+// torServiceDir é o HiddenServiceDir do chat no torrc, alterável com
+// MAGICIAN_TOR_SERVICE_DIR
+func torServiceDir() string {
+	if dir := os.Getenv("MAGICIAN_TOR_SERVICE_DIR"); dir != "" {
+		return dir
+	}
+	return tor.DefaultServiceDir
+}
+
+// torSetupReport gera o trecho de torrc para a porta e confere o torrc
+// existente, explicando o que falta
+func torSetupReport(port int, torrcPath, serviceDir string) string {
+	config := tor.TorrcConfig{Port: port, ServiceDir: serviceDir}
+
+	resp := fmt.Sprintf("🧅 Trecho de torrc para a porta %d:\n\n%s", port, tor.GenerateTorrc(config))
+
+	issues, err := tor.CheckTorrcFile(torrcPath, config)
+	if err != nil {
+		resp += fmt.Sprintf("\n⚠️ Não foi possível conferir o torrc: %v", err)
+		resp += fmt.Sprintf("\n   Acrescente o trecho acima em %s e reinicie o Tor.", torrcPath)
+		return resp
+	}
+	if len(issues) == 0 {
+		resp += fmt.Sprintf("\n✅ %s já tem tudo o que o chat precisa.", torrcPath)
+		return resp
+	}
+
+	resp += fmt.Sprintf("\n🔧 Ajustes necessários em %s:", torrcPath)
+	for _, issue := range issues {
+		mark := "❌"
+		if issue.Optional {
+			mark = "➕"
+		}
+		resp += fmt.Sprintf("\n%s %s\n   %s", mark, issue.Line, issue.Reason)
+	}
+	resp += "\nDepois de editar, reinicie o Tor (sudo systemctl restart tor)."
+	if serviceDir != tor.DefaultServiceDir {
+		resp += fmt.Sprintf("\nUse MAGICIAN_TOR_SERVICE_DIR=%s para o chat achar o endereço .onion.", serviceDir)
+	}
+	return resp
+}
+
+// runTorSetup executa "magician tor-setup", sem interface
+func runTorSetup(args []string) {
+	fs := flag.NewFlagSet("tor-setup", flag.ExitOnError)
+	port := fs.Int("porta", 9000, "porta em que o chat escuta")
+	torrc := fs.String("torrc", defaultTorrcPath, "torrc a conferir")
+	dir := fs.String("dir", torServiceDir(), "HiddenServiceDir do serviço onion")
+	fs.Parse(args)
+
+	fmt.Println(torSetupReport(*port, *torrc, *dir))
+}
+
+// cmdTor mostra ou altera como as conexões passam pelo Tor
+func cmdTor(args []string) string {
+	config := tor.Default().Config()
+	if len(args) == 0 {
+		resp := "🧅 Conexões via Tor:\n"
+		resp += fmt.Sprintf("   Proxy SOCKS: %s", config.SOCKSAddr)
+		if config.Username != "" {
+			resp += fmt.Sprintf(" (usuário %s)", config.Username)
+		}
+		resp += fmt.Sprintf("\n   Tudo pelo Tor: %s", yesNo(config.Everything))
+		if torOnly {
+			resp += " (modo somente Tor)"
+		}
+		resp += fmt.Sprintf("\n   Circuitos isolados por peer: %s", yesNo(config.Isolate))
+		resp += fmt.Sprintf("\n   Timeout: %s", config.TorTimeout)
+		return resp
+	}
+	if args[0] == "setup" {
+		torrcPath := defaultTorrcPath
+		if len(args) > 1 {
+			torrcPath = args[1]
+		}
+		port, err := strconv.Atoi(ListenPort)
+		if err != nil {
+			return fmt.Sprintf("❌ Porta de escuta inválida: %q", ListenPort)
+		}
+		return torSetupReport(port, torrcPath, torServiceDir())
+	}
+	if len(args) < 2 {
+		return torUsage
+	}
+
+	switch args[0] {
+	case "tudo":
+		on, ok := parseYesNo(args[1])
+		if !ok {
+			return "❌ Use sim ou nao"
+		}
+		if !on && torOnly {
+			return "❌ No modo somente Tor todas as conexões passam pelo Tor"
+		}
+		config.Everything = on
+	case "isolar":
+		on, ok := parseYesNo(args[1])
+		if !ok {
+			return "❌ Use sim ou nao"
+		}
+		config.Isolate = on
+	case "socks":
+		addr, err := normalizePeerAddress(args[1])
+		if err != nil || strings.Contains(addr, "://") {
+			return "❌ Endereço inválido. Use host:porta"
+		}
+		config.SOCKSAddr = addr
+		torStatusMutex.Lock()
+		torSOCKSFixed = true
+		torStatusMutex.Unlock()
+	case "timeout":
+		timeout, err := time.ParseDuration(args[1])
+		if err != nil || timeout <= 0 {
+			return "❌ Timeout inválido (ex: 60s, 2m)"
+		}
+		config.TorTimeout = timeout
+	default:
+		return torUsage
+	}
+
+	tor.SetDefault(config)
+	return "🧅 Configuração do Tor atualizada. Vale para as próximas conexões."
+}
+
+// parseYesNo interpreta sim/não
+func parseYesNo(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "sim", "s", "on":
+		return true, true
+	case "nao", "não", "n", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// yesNo formata um booleano como sim/não
+func yesNo(b bool) string {
+	if b {
+		return "sim"
+	}
+	return "não"
+}
@@ -0,0 +1,316 @@
+package tor
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Controller fala o protocolo da porta de controle do Tor (control-spec.txt)
+type Controller struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	info   *ProtocolInfo // O Tor só aceita um PROTOCOLINFO antes da autenticação
+}
+
+// Reply é a resposta a um comando: o código e as linhas, sem o prefixo
+type Reply struct {
+	Code  int
+	Lines []string
+}
+
+// ProtocolInfo descreve o daemon e os métodos de autenticação aceitos
+type ProtocolInfo struct {
+	Methods    []string
+	CookieFile string
+	Version    string
+}
+
+// DialControl conecta à porta de controle
+func DialControl(addr string, timeout time.Duration) (*Controller, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar à porta de controle: %w", err)
+	}
+	return &Controller{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Close encerra a conexão de controle
+func (c *Controller) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline limita o tempo das próximas operações
+func (c *Controller) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Command envia um comando e lê a resposta completa. Códigos 2xx são sucesso;
+// os demais viram erro.
+func (c *Controller) Command(cmd string) (*Reply, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Code/100 != 2 {
+		return reply, fmt.Errorf("tor respondeu %d: %s", reply.Code, strings.Join(reply.Lines, " "))
+	}
+	return reply, nil
+}
+
+// readReply lê linhas "250-...", "250+..." (com bloco de dados terminado
+// em ".") até a linha final "250 ..."
+func (c *Controller) readReply() (*Reply, error) {
+	reply := &Reply{}
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return nil, fmt.Errorf("resposta de controle malformada: %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return nil, fmt.Errorf("resposta de controle malformada: %q", line)
+		}
+		reply.Code = code
+		sep, text := line[3], line[4:]
+
+		switch sep {
+		case ' ':
+			reply.Lines = append(reply.Lines, text)
+			return reply, nil
+		case '-':
+			reply.Lines = append(reply.Lines, text)
+		case '+':
+			// Bloco de dados: acrescenta as linhas ao valor
+			var data []string
+			for {
+				l, err := c.reader.ReadString('\n')
+				if err != nil {
+					return nil, err
+				}
+				l = strings.TrimRight(l, "\r\n")
+				if l == "." {
+					break
+				}
+				data = append(data, strings.TrimPrefix(l, "."))
+			}
+			reply.Lines = append(reply.Lines, text+strings.Join(data, "\n"))
+		default:
+			return nil, fmt.Errorf("resposta de controle malformada: %q", line)
+		}
+	}
+}
+
+// ProtocolInfo consulta versão e métodos de autenticação (permitido antes
+// de autenticar)
+func (c *Controller) ProtocolInfo() (*ProtocolInfo, error) {
+	if c.info != nil {
+		return c.info, nil
+	}
+	reply, err := c.Command("PROTOCOLINFO 1")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ProtocolInfo{}
+	for _, line := range reply.Lines {
+		switch {
+		case strings.HasPrefix(line, "AUTH "):
+			fields := parseKeyValues(strings.TrimPrefix(line, "AUTH "))
+			info.Methods = strings.Split(fields["METHODS"], ",")
+			info.CookieFile = fields["COOKIEFILE"]
+		case strings.HasPrefix(line, "VERSION "):
+			info.Version = parseKeyValues(strings.TrimPrefix(line, "VERSION "))["Tor"]
+		}
+	}
+	c.info = info
+	return info, nil
+}
+
+// Authenticate autentica com o melhor método disponível: sem senha,
+// SAFECOOKIE ou a senha informada (HashedControlPassword no torrc). O cookie
+// nunca é enviado: quem escuta na porta precisa provar que o conhece antes
+// de receber o HMAC, então um processo qualquer ocupando a porta não
+// consegue ler arquivos apontando COOKIEFILE para eles.
+func (c *Controller) Authenticate(password string) error {
+	info, err := c.ProtocolInfo()
+	if err != nil {
+		return err
+	}
+
+	has := func(method string) bool {
+		for _, m := range info.Methods {
+			if m == method {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("NULL"):
+		_, err = c.Command("AUTHENTICATE")
+	case has("SAFECOOKIE") && info.CookieFile != "":
+		cookie, readErr := readCookie(info.CookieFile)
+		if readErr != nil {
+			if has("HASHEDPASSWORD") && password != "" {
+				_, err = c.Command("AUTHENTICATE " + quote(password))
+				break
+			}
+			return readErr
+		}
+		err = c.authenticateSafeCookie(cookie)
+	case has("HASHEDPASSWORD"):
+		if password == "" {
+			return fmt.Errorf("a porta de controle do Tor exige senha")
+		}
+		_, err = c.Command("AUTHENTICATE " + quote(password))
+	default:
+		return fmt.Errorf("nenhum método de autenticação suportado: %s", strings.Join(info.Methods, ","))
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao autenticar no Tor: %w", err)
+	}
+	return nil
+}
+
+const (
+	cookieSize      = 32
+	safeCookieNonce = 32
+
+	safeCookieServerKey = "Tor safe cookie authentication server-to-controller hash"
+	safeCookieClientKey = "Tor safe cookie authentication controller-to-server hash"
+)
+
+// authenticateSafeCookie faz o AUTHCHALLENGE SAFECOOKIE: confere o
+// SERVERHASH com o cookie local e só então envia o hash do controlador
+func (c *Controller) authenticateSafeCookie(cookie []byte) error {
+	clientNonce := make([]byte, safeCookieNonce)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return err
+	}
+	reply, err := c.Command("AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+	line := reply.Lines[len(reply.Lines)-1]
+	if !strings.HasPrefix(line, "AUTHCHALLENGE ") {
+		return fmt.Errorf("resposta inesperada ao AUTHCHALLENGE: %q", line)
+	}
+	fields := parseKeyValues(strings.TrimPrefix(line, "AUTHCHALLENGE "))
+	serverHash, err1 := hex.DecodeString(fields["SERVERHASH"])
+	serverNonce, err2 := hex.DecodeString(fields["SERVERNONCE"])
+	if err1 != nil || err2 != nil || len(serverHash) != sha256.Size || len(serverNonce) != safeCookieNonce {
+		return fmt.Errorf("resposta inesperada ao AUTHCHALLENGE: %q", line)
+	}
+
+	if !hmac.Equal(serverHash, safeCookieHash(safeCookieServerKey, cookie, clientNonce, serverNonce)) {
+		return fmt.Errorf("a porta de controle não provou conhecer o cookie")
+	}
+	_, err = c.Command("AUTHENTICATE " + hex.EncodeToString(safeCookieHash(safeCookieClientKey, cookie, clientNonce, serverNonce)))
+	return err
+}
+
+// readCookie lê o cookie de controle, recusando arquivos que não tenham o
+// tamanho de um cookie do Tor
+func readCookie(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler cookie de controle do Tor: %w", err)
+	}
+	defer f.Close()
+
+	cookie := make([]byte, cookieSize+1)
+	n, err := io.ReadFull(f, cookie)
+	if n != cookieSize || err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("cookie de controle do Tor inválido: %s não tem %d bytes", path, cookieSize)
+	}
+	return cookie[:cookieSize], nil
+}
+
+// safeCookieHash calcula o HMAC-SHA256 do SAFECOOKIE com a chave do sentido
+// indicado sobre cookie, nonce do controlador e nonce do servidor
+func safeCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(cookie)
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// GetInfo consulta uma chave de GETINFO, como "status/bootstrap-phase"
+func (c *Controller) GetInfo(key string) (string, error) {
+	reply, err := c.Command("GETINFO " + key)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range reply.Lines {
+		if strings.HasPrefix(line, key+"=") {
+			return strings.TrimPrefix(line, key+"="), nil
+		}
+	}
+	return "", fmt.Errorf("tor não informou %s", key)
+}
+
+// parseKeyValues lê pares CHAVE=valor, com valores opcionalmente entre aspas
+func parseKeyValues(s string) map[string]string {
+	result := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		// Palavras soltas (como "NOTICE BOOTSTRAP") não são pares
+		if sp := strings.IndexByte(s, ' '); sp >= 0 && sp < eq {
+			s = s[sp:]
+			continue
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			value = sb.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else if sp := strings.IndexByte(s, ' '); sp >= 0 {
+			value, s = s[:sp], s[sp:]
+		} else {
+			value, s = s, ""
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// quote coloca uma string entre aspas no formato do protocolo de controle
+func quote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
@@ -0,0 +1,169 @@
+package tor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeControl sobe a porta de controle falsa com a senha informada
+func fakeControl(t *testing.T, password string) *FakeControl {
+	t.Helper()
+	f, err := NewFakeControl(password)
+	if err != nil {
+		t.Fatalf("NewFakeControl: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// dial autentica na porta falsa e fecha a conexão no fim do teste
+func dial(t *testing.T, f *FakeControl, password string) *Controller {
+	t.Helper()
+	ctrl, err := DialAuthenticated([]string{f.Addr()}, password, 2*time.Second)
+	if err != nil {
+		t.Fatalf("DialAuthenticated: %v", err)
+	}
+	t.Cleanup(func() { ctrl.Close() })
+	return ctrl
+}
+
+func TestAuthenticateNull(t *testing.T) {
+	f := fakeControl(t, "")
+	ctrl := dial(t, f, "")
+
+	if _, err := ctrl.GetInfo("status/circuit-established"); err != nil {
+		t.Errorf("GETINFO após autenticar: %v", err)
+	}
+}
+
+func TestAuthenticatePassword(t *testing.T) {
+	f := fakeControl(t, "senha \"secreta\"")
+	dial(t, f, "senha \"secreta\"")
+
+	if _, err := DialAuthenticated([]string{f.Addr()}, "errada", 2*time.Second); err == nil {
+		t.Error("autenticou com a senha errada")
+	}
+	if _, err := DialAuthenticated([]string{f.Addr()}, "", 2*time.Second); err == nil {
+		t.Error("autenticou sem senha")
+	}
+}
+
+func TestAuthenticateSafeCookie(t *testing.T) {
+	f := fakeControl(t, "")
+	if err := f.UseCookie(filepath.Join(t.TempDir(), "control_auth_cookie")); err != nil {
+		t.Fatalf("UseCookie: %v", err)
+	}
+	ctrl := dial(t, f, "")
+
+	info, _ := ctrl.ProtocolInfo()
+	if info.CookieFile != f.cookieFile {
+		t.Errorf("COOKIEFILE %q, esperava %q", info.CookieFile, f.cookieFile)
+	}
+	if _, err := ctrl.GetInfo("status/bootstrap-phase"); err != nil {
+		t.Errorf("GETINFO após autenticar: %v", err)
+	}
+}
+
+// Uma porta que anuncia um arquivo mas não prova conhecer o conteúdo não
+// recebe nada derivado dele
+func TestAuthenticateRejectsUnprovenCookie(t *testing.T) {
+	f := fakeControl(t, "")
+	dir := t.TempDir()
+	if err := f.UseCookie(filepath.Join(dir, "control_auth_cookie")); err != nil {
+		t.Fatalf("UseCookie: %v", err)
+	}
+	// O impostor aponta para o arquivo, mas não sabe o que há nele
+	f.cookie = make([]byte, cookieSize)
+
+	if _, err := DialAuthenticated([]string{f.Addr()}, "", 2*time.Second); err == nil {
+		t.Fatal("autenticou sem o SERVERHASH correto")
+	}
+}
+
+func TestAuthenticateRejectsWrongSizeCookie(t *testing.T) {
+	f := fakeControl(t, "")
+	dir := t.TempDir()
+	if err := f.UseCookie(filepath.Join(dir, "control_auth_cookie")); err != nil {
+		t.Fatalf("UseCookie: %v", err)
+	}
+	// Um arquivo qualquer no lugar do cookie, como ~/.ssh/id_ed25519
+	other := filepath.Join(dir, "outro")
+	if err := os.WriteFile(other, make([]byte, 400), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.cookieFile = other
+
+	if _, err := DialAuthenticated([]string{f.Addr()}, "", 2*time.Second); err == nil {
+		t.Fatal("aceitou cookie de tamanho errado")
+	}
+}
+
+func TestAddOnionNewAndSavedKey(t *testing.T) {
+	f := fakeControl(t, "senha")
+	ctrl := dial(t, f, "senha")
+
+	service, err := ctrl.AddOnion("", 1337, "127.0.0.1:9000")
+	if err != nil {
+		t.Fatalf("AddOnion: %v", err)
+	}
+	if len(service.ServiceID) != 56 || service.Address() != service.ServiceID+".onion" {
+		t.Errorf("endereço inesperado: %q", service.Address())
+	}
+	if service.PrivateKey == "" {
+		t.Fatal("chave nova não foi devolvida para ser guardada")
+	}
+	if target := f.Services()[service.ServiceID]; target != "1337,127.0.0.1:9000" {
+		t.Errorf("serviço encaminha para %q", target)
+	}
+
+	if err := ctrl.DelOnion(service.ServiceID); err != nil {
+		t.Fatalf("DelOnion: %v", err)
+	}
+
+	// A chave salva recria o mesmo endereço
+	again, err := ctrl.AddOnion(service.PrivateKey, 1337, "127.0.0.1:9000")
+	if err != nil {
+		t.Fatalf("AddOnion com chave salva: %v", err)
+	}
+	if again.ServiceID != service.ServiceID || again.PrivateKey != service.PrivateKey {
+		t.Errorf("chave salva gerou %s, esperava %s", again.ServiceID, service.ServiceID)
+	}
+}
+
+func TestDelOnion(t *testing.T) {
+	f := fakeControl(t, "")
+	ctrl := dial(t, f, "")
+
+	service, err := ctrl.AddOnion("", 80, "127.0.0.1:8080")
+	if err != nil {
+		t.Fatalf("AddOnion: %v", err)
+	}
+	if err := ctrl.DelOnion(service.ServiceID); err != nil {
+		t.Fatalf("DelOnion: %v", err)
+	}
+	if _, ok := f.Services()[service.ServiceID]; ok {
+		t.Error("serviço continuou ativo após DEL_ONION")
+	}
+	if err := ctrl.DelOnion(service.ServiceID); err == nil {
+		t.Error("DEL_ONION de serviço inexistente não falhou")
+	}
+
+	// Serviços efêmeros somem quando a conexão de controle fecha
+	kept, err := ctrl.AddOnion("", 80, "127.0.0.1:8080")
+	if err != nil {
+		t.Fatalf("AddOnion: %v", err)
+	}
+	ctrl.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := f.Services()[kept.ServiceID]; !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("serviço continuou ativo após fechar a conexão de controle")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1 +1,103 @@
 package tor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	// DefaultSOCKSAddrs são os endereços procurados para o proxy SOCKS:
+	// o daemon do sistema e o Tor Browser
+	DefaultSOCKSAddrs = []string{"127.0.0.1:9050", "127.0.0.1:9150"}
+
+	// DefaultControlAddrs são as portas de controle correspondentes
+	DefaultControlAddrs = []string{"127.0.0.1:9051", "127.0.0.1:9151"}
+)
+
+// ProbeSOCKS confere se há um proxy SOCKS5 no endereço, fazendo a
+// negociação inicial sem abrir nenhum circuito
+func ProbeSOCKS(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Versão 5, dois métodos: 0x00 (sem autenticação) e 0x02 (usuário/senha)
+	if _, err := conn.Write([]byte{0x05, 0x02, 0x00, 0x02}); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("não respondeu como SOCKS5: %w", err)
+	}
+	if resp[0] != 0x05 {
+		return fmt.Errorf("não é um proxy SOCKS5")
+	}
+	if resp[1] == 0xff {
+		return fmt.Errorf("proxy SOCKS5 recusou os métodos de autenticação")
+	}
+	return nil
+}
+
+// Detect procura o proxy SOCKS e a porta de controle nos endereços
+// informados (ou nos padrões) e consulta o estado do daemon
+func Detect(socksAddrs, controlAddrs []string, password string, timeout time.Duration) Status {
+	if len(socksAddrs) == 0 {
+		socksAddrs = DefaultSOCKSAddrs
+	}
+	if len(controlAddrs) == 0 {
+		controlAddrs = DefaultControlAddrs
+	}
+
+	status := Status{Checked: time.Now(), BootstrapProgress: -1}
+
+	for _, addr := range socksAddrs {
+		err := ProbeSOCKS(addr, timeout)
+		if err == nil {
+			status.SOCKSAddr = addr
+			status.SOCKSErr = nil
+			break
+		}
+		status.SOCKSErr = err
+	}
+
+	for _, addr := range controlAddrs {
+		ctrl, err := DialControl(addr, timeout)
+		if err != nil {
+			status.ControlErr = err
+			continue
+		}
+		status.ControlAddr = addr
+		status.ControlErr = queryStatus(ctrl, password, timeout, &status)
+		ctrl.Close()
+		break
+	}
+	return status
+}
+
+// queryStatus preenche versão, bootstrap e circuitos pela porta de controle
+func queryStatus(ctrl *Controller, password string, timeout time.Duration, status *Status) error {
+	ctrl.SetDeadline(time.Now().Add(timeout))
+
+	if info, err := ctrl.ProtocolInfo(); err == nil {
+		status.Version = info.Version
+	}
+	if err := ctrl.Authenticate(password); err != nil {
+		return err
+	}
+	status.ControlAuthenticated = true
+
+	if phase, err := ctrl.GetInfo("status/bootstrap-phase"); err == nil {
+		fields := parseKeyValues(phase)
+		fmt.Sscanf(fields["PROGRESS"], "%d", &status.BootstrapProgress)
+		status.BootstrapSummary = fields["SUMMARY"]
+	}
+	if established, err := ctrl.GetInfo("status/circuit-established"); err == nil {
+		status.CircuitEstablished = established == "1"
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package tor
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeControl é uma porta de controle mínima que roda localmente, para
+// testar autenticação e serviços onion sem um daemon do Tor. Aceita
+// PROTOCOLINFO, AUTHCHALLENGE, AUTHENTICATE, GETINFO, ADD_ONION e DEL_ONION.
+type FakeControl struct {
+	Password string // Vazio aceita AUTHENTICATE sem senha (método NULL)
+	Version  string
+
+	listener net.Listener
+
+	cookieFile string // Com cookie, oferece SAFECOOKIE e anuncia o arquivo
+	cookie     []byte
+
+	mu       sync.Mutex
+	services map[string]string // ServiceID → "porta,destino"
+}
+
+// NewFakeControl inicia a porta de controle falsa em uma porta local livre
+func NewFakeControl(password string) (*FakeControl, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FakeControl{
+		Password: password,
+		Version:  "0.4.8.10",
+		listener: ln,
+		services: make(map[string]string),
+	}
+	go f.serve()
+	return f, nil
+}
+
+// UseCookie grava um cookie novo em path e passa a oferecer SAFECOOKIE
+// (e COOKIE, como o Tor, embora o cliente não o use)
+func (f *FakeControl) UseCookie(path string) error {
+	cookie := make([]byte, cookieSize)
+	rand.Read(cookie)
+	if err := os.WriteFile(path, cookie, 0600); err != nil {
+		return err
+	}
+	f.cookieFile, f.cookie = path, cookie
+	return nil
+}
+
+// Addr é o endereço da porta de controle
+func (f *FakeControl) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Services retorna uma cópia dos serviços onion ativos
+func (f *FakeControl) Services() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := make(map[string]string, len(f.services))
+	for k, v := range f.services {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Close para a porta de controle
+func (f *FakeControl) Close() error {
+	return f.listener.Close()
+}
+
+func (f *FakeControl) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle atende uma conexão. Como no Tor, os serviços criados nela são
+// removidos quando ela fecha.
+func (f *FakeControl) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := false
+	var owned []string
+	var clientNonce, serverNonce []byte
+
+	defer func() {
+		f.mu.Lock()
+		for _, id := range owned {
+			delete(f.services, id)
+		}
+		f.mu.Unlock()
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, args, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(cmd) {
+		case "PROTOCOLINFO":
+			var methods []string
+			if f.cookie != nil {
+				methods = append(methods, "COOKIE", "SAFECOOKIE")
+			}
+			if f.Password != "" {
+				methods = append(methods, "HASHEDPASSWORD")
+			}
+			auth := "METHODS=NULL"
+			if len(methods) > 0 {
+				auth = "METHODS=" + strings.Join(methods, ",")
+			}
+			if f.cookie != nil {
+				auth += " COOKIEFILE=" + quote(f.cookieFile)
+			}
+			fmt.Fprintf(conn, "250-PROTOCOLINFO 1\r\n250-AUTH %s\r\n250-VERSION Tor=%q\r\n250 OK\r\n", auth, f.Version)
+		case "AUTHCHALLENGE":
+			method, nonce, _ := strings.Cut(args, " ")
+			clientNonce, err = hex.DecodeString(nonce)
+			if f.cookie == nil || method != "SAFECOOKIE" || err != nil || len(clientNonce) != safeCookieNonce {
+				fmt.Fprint(conn, "513 Invalid AUTHCHALLENGE\r\n")
+				return
+			}
+			serverNonce = make([]byte, safeCookieNonce)
+			rand.Read(serverNonce)
+			fmt.Fprintf(conn, "250 AUTHCHALLENGE SERVERHASH=%X SERVERNONCE=%X\r\n",
+				safeCookieHash(safeCookieServerKey, f.cookie, clientNonce, serverNonce), serverNonce)
+		case "AUTHENTICATE":
+			if !f.authenticate(args, clientNonce, serverNonce) {
+				fmt.Fprint(conn, "515 Authentication failed\r\n")
+				return
+			}
+			authenticated = true
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "250 closing connection\r\n")
+			return
+		default:
+			if !authenticated {
+				fmt.Fprint(conn, "514 Authentication required.\r\n")
+				return
+			}
+			if id := f.command(conn, cmd, args); id != "" {
+				owned = append(owned, id)
+			}
+		}
+	}
+}
+
+// authenticate confere o AUTHENTICATE: o hash do SAFECOOKIE depois de um
+// AUTHCHALLENGE, a senha, ou nada quando não há senha nem cookie
+func (f *FakeControl) authenticate(args string, clientNonce, serverNonce []byte) bool {
+	if f.cookie != nil && serverNonce != nil {
+		proof, err := hex.DecodeString(args)
+		return err == nil && hmac.Equal(proof, safeCookieHash(safeCookieClientKey, f.cookie, clientNonce, serverNonce))
+	}
+	if f.Password != "" {
+		return args == quote(f.Password)
+	}
+	return f.cookie == nil && args == ""
+}
+
+// command trata os comandos que exigem autenticação. Retorna o ServiceID
+// criado por ADD_ONION, se houver.
+func (f *FakeControl) command(conn net.Conn, cmd, args string) string {
+	switch strings.ToUpper(cmd) {
+	case "GETINFO":
+		switch args {
+		case "status/bootstrap-phase":
+			fmt.Fprint(conn, "250-status/bootstrap-phase=NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY=\"Done\"\r\n250 OK\r\n")
+		case "status/circuit-established":
+			fmt.Fprint(conn, "250-status/circuit-established=1\r\n250 OK\r\n")
+		default:
+			fmt.Fprintf(conn, "552 Unrecognized key \"%s\"\r\n", args)
+		}
+	case "ADD_ONION":
+		fields := strings.Fields(args)
+		if len(fields) < 2 || !strings.HasPrefix(fields[len(fields)-1], "Port=") {
+			fmt.Fprint(conn, "512 Missing argument to ADD_ONION\r\n")
+			return ""
+		}
+		key, generated := fields[0], false
+		if key == "NEW:ED25519-V3" || key == "NEW:BEST" {
+			buf := make([]byte, 64)
+			rand.Read(buf)
+			key, generated = "ED25519-V3:"+base64.StdEncoding.EncodeToString(buf), true
+		} else if !strings.HasPrefix(key, "ED25519-V3:") {
+			fmt.Fprint(conn, "513 Invalid key type\r\n")
+			return ""
+		}
+
+		// O endereço é derivado da chave, então a mesma chave dá o mesmo .onion
+		sum := sha256.Sum256([]byte(key))
+		id := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(append(sum[:], sum[:3]...)))[:56]
+
+		f.mu.Lock()
+		if _, exists := f.services[id]; exists {
+			f.mu.Unlock()
+			fmt.Fprint(conn, "550 Onion address collision\r\n")
+			return ""
+		}
+		f.services[id] = strings.TrimPrefix(fields[len(fields)-1], "Port=")
+		f.mu.Unlock()
+
+		fmt.Fprintf(conn, "250-ServiceID=%s\r\n", id)
+		if generated {
+			fmt.Fprintf(conn, "250-PrivateKey=%s\r\n", key)
+		}
+		fmt.Fprint(conn, "250 OK\r\n")
+		return id
+	case "DEL_ONION":
+		f.mu.Lock()
+		_, ok := f.services[args]
+		delete(f.services, args)
+		f.mu.Unlock()
+		if !ok {
+			fmt.Fprint(conn, "552 Unknown Onion Service id\r\n")
+			return ""
+		}
+		fmt.Fprint(conn, "250 OK\r\n")
+	default:
+		fmt.Fprintf(conn, "510 Unrecognized command \"%s\"\r\n", cmd)
+	}
+	return ""
+}
@@ -1 +1,156 @@
 package tor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status é o resultado de uma verificação do daemon do Tor
+type Status struct {
+	Checked time.Time
+
+	SOCKSAddr string // Vazio se nenhum proxy respondeu
+	SOCKSErr  error
+
+	ControlAddr          string // Vazio se nenhuma porta de controle respondeu
+	ControlAuthenticated bool
+	ControlErr           error
+
+	Version            string
+	BootstrapProgress  int // 0 a 100; -1 se desconhecido
+	BootstrapSummary   string
+	CircuitEstablished bool
+}
+
+// Available informa se é possível conectar a endereços .onion
+func (s Status) Available() bool {
+	return s.SOCKSAddr != ""
+}
+
+// Ready informa se o Tor está disponível e já construiu circuitos. Sem a
+// porta de controle, basta o proxy responder.
+func (s Status) Ready() bool {
+	if !s.Available() {
+		return false
+	}
+	if s.ControlAuthenticated {
+		return s.CircuitEstablished
+	}
+	return true
+}
+
+// Summary descreve o estado em uma linha
+func (s Status) Summary() string {
+	if !s.Available() {
+		if s.SOCKSErr != nil {
+			return fmt.Sprintf("indisponível (%v)", s.SOCKSErr)
+		}
+		return "indisponível"
+	}
+
+	summary := "disponível (SOCKS " + s.SOCKSAddr
+	if s.Version != "" {
+		summary += ", Tor " + s.Version
+	}
+	return summary + ")"
+}
+
+// CircuitSummary descreve o bootstrap e os circuitos em uma linha
+func (s Status) CircuitSummary() string {
+	switch {
+	case s.ControlAddr == "":
+		return "desconhecido (porta de controle fechada)"
+	case !s.ControlAuthenticated:
+		return fmt.Sprintf("desconhecido (controle em %s: %v)", s.ControlAddr, s.ControlErr)
+	}
+
+	circuits := "sem circuitos"
+	if s.CircuitEstablished {
+		circuits = "circuitos estabelecidos"
+	}
+	if s.BootstrapProgress < 0 {
+		return circuits
+	}
+	if s.BootstrapSummary != "" {
+		return fmt.Sprintf("bootstrap %d%% (%s), %s", s.BootstrapProgress, s.BootstrapSummary, circuits)
+	}
+	return fmt.Sprintf("bootstrap %d%%, %s", s.BootstrapProgress, circuits)
+}
+
+// OnionService é um serviço onion efêmero criado pela porta de controle
+type OnionService struct {
+	ServiceID  string // Endereço sem o sufixo .onion
+	PrivateKey string // "ED25519-V3:..." para recriar o mesmo endereço
+	Port       int    // Porta virtual anunciada no .onion
+	Target     string // Para onde o Tor encaminha as conexões
+}
+
+// Address é o endereço .onion do serviço
+func (s *OnionService) Address() string {
+	return s.ServiceID + ".onion"
+}
+
+// DialAuthenticated abre e autentica a primeira porta de controle que
+// responder entre as informadas (ou as padrão)
+func DialAuthenticated(controlAddrs []string, password string, timeout time.Duration) (*Controller, error) {
+	if len(controlAddrs) == 0 {
+		controlAddrs = DefaultControlAddrs
+	}
+
+	var lastErr error
+	for _, addr := range controlAddrs {
+		ctrl, err := DialControl(addr, timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ctrl.SetDeadline(time.Now().Add(timeout))
+		if err := ctrl.Authenticate(password); err != nil {
+			ctrl.Close()
+			return nil, err
+		}
+		ctrl.SetDeadline(time.Time{})
+		return ctrl, nil
+	}
+	return nil, lastErr
+}
+
+// AddOnion cria um serviço onion que encaminha a porta virtual para target.
+// Com key vazia o Tor gera uma chave nova, devolvida em PrivateKey para ser
+// guardada; com uma chave salva o endereço continua o mesmo. O serviço dura
+// enquanto esta conexão de controle estiver aberta.
+func (c *Controller) AddOnion(key string, port int, target string) (*OnionService, error) {
+	if key == "" {
+		key = "NEW:ED25519-V3"
+	}
+	reply, err := c.Command(fmt.Sprintf("ADD_ONION %s Port=%d,%s", key, port, target))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar serviço onion: %w", err)
+	}
+
+	service := &OnionService{Port: port, Target: target}
+	if !strings.HasPrefix(key, "NEW:") {
+		service.PrivateKey = key
+	}
+	for _, line := range reply.Lines {
+		switch {
+		case strings.HasPrefix(line, "ServiceID="):
+			service.ServiceID = strings.TrimPrefix(line, "ServiceID=")
+		case strings.HasPrefix(line, "PrivateKey="):
+			service.PrivateKey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+	if service.ServiceID == "" {
+		return nil, fmt.Errorf("tor não informou o endereço do serviço onion")
+	}
+	return service, nil
+}
+
+// DelOnion remove um serviço criado com AddOnion
+func (c *Controller) DelOnion(serviceID string) error {
+	if _, err := c.Command("DEL_ONION " + serviceID); err != nil {
+		return fmt.Errorf("erro ao remover serviço onion: %w", err)
+	}
+	return nil
+}
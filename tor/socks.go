@@ -1,20 +1,146 @@
 package tor
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"golang.org/x/net/proxy"
 	"net"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
 )
 
-// DialOrDirect detecta se é um .onion e usa proxy SOCKS5 se necessário
-func DialOrDirect(address string) (net.Conn, error) {
-	if strings.HasSuffix(address, ".onion:1337") {
-		dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:9050", nil, proxy.Direct)
-		if err != nil {
-			return nil, fmt.Errorf("erro ao criar dialer SOCKS5: %w", err)
+// DefaultDialTimeout limita a conexão inteira via Tor, incluindo a
+// negociação SOCKS e a construção do circuito até o destino
+const DefaultDialTimeout = 60 * time.Second
+
+// DialerConfig define como as conexões passam pelo proxy SOCKS do Tor
+type DialerConfig struct {
+	SOCKSAddr string // Vazio usa 127.0.0.1:9050
+	Username  string // Autenticação SOCKS, se o proxy exigir
+	Password  string
+
+	// Everything manda também os endereços comuns (IP:porta) pelo Tor, e
+	// não só os .onion
+	Everything bool
+
+	// Isolate usa credenciais SOCKS diferentes para cada peer. Com
+	// IsolateSOCKSAuth (padrão do Tor), cada peer ganha circuitos próprios
+	// e não dá para correlacionar as conexões pela saída em comum.
+	Isolate bool
+
+	DirectTimeout time.Duration // Conexões diretas; 0 = sem limite
+	TorTimeout    time.Duration // Conexões via Tor; 0 = DefaultDialTimeout
+}
+
+// Dialer abre conexões diretas ou via Tor conforme a configuração
+type Dialer struct {
+	config DialerConfig
+}
+
+// NewDialer cria um dialer com a configuração, preenchendo os padrões
+func NewDialer(config DialerConfig) *Dialer {
+	if config.SOCKSAddr == "" {
+		config.SOCKSAddr = DefaultSOCKSAddrs[0]
+	}
+	if config.TorTimeout == 0 {
+		config.TorTimeout = DefaultDialTimeout
+	}
+	return &Dialer{config: config}
+}
+
+// Config retorna a configuração em uso
+func (d *Dialer) Config() DialerConfig {
+	return d.config
+}
+
+// IsOnion diz se o endereço (host:porta ou só host) é de um serviço onion
+func IsOnion(address string) bool {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
+
+// UsesTor diz se uma conexão ao endereço passaria pelo Tor
+func (d *Dialer) UsesTor(address string) bool {
+	return d.config.Everything || IsOnion(address)
+}
+
+// Dial conecta ao endereço, via SOCKS5 se for .onion (em qualquer porta) ou
+// se tudo deve passar pelo Tor
+func (d *Dialer) Dial(address string) (net.Conn, error) {
+	if !d.UsesTor(address) {
+		return net.DialTimeout("tcp", address, d.config.DirectTimeout)
+	}
+
+	auth := d.auth(address)
+	forward := &net.Dialer{Timeout: d.config.TorTimeout}
+	dialer, err := proxy.SOCKS5("tcp", d.config.SOCKSAddr, auth, forward)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar dialer SOCKS5: %w", err)
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("dialer SOCKS5 sem suporte a timeout")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.TorTimeout)
+	defer cancel()
+	conn, err := contextDialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar via Tor (%s): %w", d.config.SOCKSAddr, err)
+	}
+	return conn, nil
+}
+
+// auth escolhe as credenciais SOCKS. Com isolamento, a senha inclui um
+// identificador do destino, então conexões ao mesmo peer compartilham
+// circuitos e peers diferentes não.
+func (d *Dialer) auth(address string) *proxy.Auth {
+	if !d.config.Isolate {
+		if d.config.Username == "" {
+			return nil
 		}
-		return dialer.Dial("tcp", address)
+		return &proxy.Auth{User: d.config.Username, Password: d.config.Password}
 	}
-	return net.Dial("tcp", address)
+
+	user := d.config.Username
+	if user == "" {
+		user = "magician"
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(address)))
+	return &proxy.Auth{User: user, Password: d.config.Password + hex.EncodeToString(sum[:8])}
+}
+
+// Dialer usado por DialOrDirect
+var (
+	defaultDialer = NewDialer(DialerConfig{})
+	defaultMutex  sync.RWMutex
+)
+
+// SetDefault troca a configuração usada por DialOrDirect
+func SetDefault(config DialerConfig) {
+	d := NewDialer(config)
+	defaultMutex.Lock()
+	defaultDialer = d
+	defaultMutex.Unlock()
+}
+
+// Default retorna o dialer usado por DialOrDirect
+func Default() *Dialer {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+	return defaultDialer
+}
+
+// DialOrDirect conecta pelo dialer padrão: .onion via SOCKS5 e os demais
+// direto, a menos que a configuração mande tudo pelo Tor
+func DialOrDirect(address string) (net.Conn, error) {
+	return Default().Dial(address)
 }
@@ -1 +1,198 @@
 package tor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultServiceDir é o HiddenServiceDir usado quando nenhum é informado
+const DefaultServiceDir = "/var/lib/tor/magician_chat/"
+
+// TorrcConfig descreve o que o Magician precisa do torrc
+type TorrcConfig struct {
+	Port        int    // Porta do chat: virtual no .onion e local em 127.0.0.1
+	ServiceDir  string // HiddenServiceDir; vazio usa DefaultServiceDir
+	SOCKSPort   int    // 0 usa 9050
+	ControlPort int    // 0 usa 9051
+}
+
+func (c TorrcConfig) withDefaults() TorrcConfig {
+	if c.ServiceDir == "" {
+		c.ServiceDir = DefaultServiceDir
+	}
+	if c.SOCKSPort == 0 {
+		c.SOCKSPort = 9050
+	}
+	if c.ControlPort == 0 {
+		c.ControlPort = 9051
+	}
+	return c
+}
+
+// GenerateTorrc gera o trecho de torrc para a configuração: proxy SOCKS para
+// conectar a peers .onion, porta de controle (estado do daemon e serviço
+// onion automático) e o serviço onion que encaminha para a porta do chat
+func GenerateTorrc(c TorrcConfig) string {
+	c = c.withDefaults()
+	return fmt.Sprintf(`## Magician Chat
+# Proxy para conectar a peers .onion
+SocksPort %d
+
+# Porta de controle: /info mostra o estado do Tor e o serviço onion pode ser
+# criado sem editar este arquivo
+ControlPort %d
+CookieAuthentication 1
+
+# Serviço onion do chat
+HiddenServiceDir %s
+HiddenServicePort %d 127.0.0.1:%d
+`, c.SOCKSPort, c.ControlPort, c.ServiceDir, c.Port, c.Port)
+}
+
+// TorrcIssue é uma linha que falta (ou está errada) no torrc
+type TorrcIssue struct {
+	Line     string // Linha sugerida
+	Reason   string
+	Optional bool // O chat funciona sem ela, com menos recursos
+}
+
+// torrcLine é uma opção do torrc, sem comentários
+type torrcLine struct {
+	key   string // Em minúsculas: o torrc não diferencia
+	value string
+}
+
+func parseTorrc(content string) []torrcLine {
+	var lines []torrcLine
+	for _, raw := range strings.Split(content, "\n") {
+		if i := strings.IndexByte(raw, '#'); i >= 0 {
+			raw = raw[:i]
+		}
+		fields := strings.Fields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		lines = append(lines, torrcLine{
+			key:   strings.ToLower(fields[0]),
+			value: strings.Join(fields[1:], " "),
+		})
+	}
+	return lines
+}
+
+// sameDir compara diretórios ignorando a barra final
+func sameDir(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// CheckTorrc confere se o conteúdo de um torrc atende à configuração e
+// explica o que falta
+func CheckTorrc(content string, c TorrcConfig) []TorrcIssue {
+	c = c.withDefaults()
+	lines := parseTorrc(content)
+	var issues []TorrcIssue
+
+	// Sem SocksPort o Tor usa 9050; só "SocksPort 0" desliga o proxy
+	hasSocks, socksOn := false, false
+	for _, l := range lines {
+		if l.key == "socksport" {
+			hasSocks = true
+			socksOn = socksOn || l.value != "0"
+		}
+	}
+	if hasSocks && !socksOn {
+		issues = append(issues, TorrcIssue{
+			Line:   fmt.Sprintf("SocksPort %d", c.SOCKSPort),
+			Reason: "o proxy SOCKS está desligado (SocksPort 0): não será possível conectar a peers .onion",
+		})
+	}
+
+	hasControl, hasAuth := false, false
+	for _, l := range lines {
+		switch l.key {
+		case "controlport":
+			hasControl = hasControl || l.value != "0"
+		case "cookieauthentication":
+			hasAuth = hasAuth || l.value == "1"
+		case "hashedcontrolpassword":
+			hasAuth = true
+		}
+	}
+	if !hasControl {
+		issues = append(issues, TorrcIssue{
+			Line:     fmt.Sprintf("ControlPort %d", c.ControlPort),
+			Reason:   "sem porta de controle, /info não mostra os circuitos e o serviço onion automático não funciona",
+			Optional: true,
+		})
+	}
+	if !hasAuth {
+		issues = append(issues, TorrcIssue{
+			Line:     "CookieAuthentication 1",
+			Reason:   "a porta de controle aceitaria comandos de qualquer programa local sem autenticação",
+			Optional: !hasControl,
+		})
+	}
+
+	issues = append(issues, checkHiddenService(lines, c)...)
+	return issues
+}
+
+// checkHiddenService procura o bloco HiddenServiceDir do chat e confere se ele
+// encaminha a porta certa
+func checkHiddenService(lines []torrcLine, c TorrcConfig) []TorrcIssue {
+	want := fmt.Sprintf("HiddenServicePort %d 127.0.0.1:%d", c.Port, c.Port)
+
+	inBlock, found := false, false
+	var ports []string
+	for _, l := range lines {
+		switch l.key {
+		case "hiddenservicedir":
+			inBlock = sameDir(l.value, c.ServiceDir)
+			found = found || inBlock
+		case "hiddenserviceport":
+			if inBlock {
+				ports = append(ports, l.value)
+			}
+		}
+	}
+
+	if !found {
+		return []TorrcIssue{
+			{Line: "HiddenServiceDir " + c.ServiceDir, Reason: "não há serviço onion para o chat"},
+			{Line: want, Reason: "o serviço onion precisa encaminhar a porta do chat"},
+		}
+	}
+
+	for _, p := range ports {
+		fields := strings.Fields(p)
+		if len(fields) == 0 || fields[0] != strconv.Itoa(c.Port) {
+			continue
+		}
+		// Sem destino, o Tor usa 127.0.0.1 na mesma porta
+		if len(fields) == 1 || fields[1] == strconv.Itoa(c.Port) || fields[1] == fmt.Sprintf("127.0.0.1:%d", c.Port) {
+			return nil
+		}
+		return []TorrcIssue{{
+			Line:   want,
+			Reason: fmt.Sprintf("a porta %d do serviço onion é encaminhada para %s, mas o chat escuta em 127.0.0.1:%d", c.Port, fields[1], c.Port),
+		}}
+	}
+
+	reason := fmt.Sprintf("o serviço onion em %s não encaminha a porta %d", c.ServiceDir, c.Port)
+	if len(ports) > 0 {
+		reason += " (encaminha: " + strings.Join(ports, "; ") + ")"
+	}
+	return []TorrcIssue{{Line: want, Reason: reason}}
+}
+
+// CheckTorrcFile lê e confere um torrc
+func CheckTorrcFile(path string, c TorrcConfig) ([]TorrcIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+	return CheckTorrc(string(data), c), nil
+}
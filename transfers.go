@@ -0,0 +1,487 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransferState representa o estágio do ciclo de vida de uma transferência
+type TransferState int
+
+const (
+	TransferOffered TransferState = iota
+	TransferInProgress
+	TransferComplete
+	TransferFailed
+	TransferCancelled
+)
+
+const (
+	transferIDBytes   = 8                // IDs de 16 caracteres hexadecimais
+	transferTimeout   = 2 * time.Minute  // Inatividade máxima antes de falhar
+	pauseTimeout      = 15 * time.Minute // Pausa máxima antes de falhar
+	transferRetention = 10 * time.Minute // Tempo que transferências finalizadas ficam na tabela
+	janitorInterval   = 15 * time.Second
+
+	// Limites por peer para ofertas recebidas: arquivos simples ficam em
+	// memória até o fim, então um peer não pode acumular transferências
+	maxIncomingPerPeer = 4
+	maxBufferedPerPeer = 200 * 1024 * 1024
+)
+
+func (s TransferState) String() string {
+	switch s {
+	case TransferOffered:
+		return "oferecida"
+	case TransferInProgress:
+		return "em andamento"
+	case TransferComplete:
+		return "concluída"
+	case TransferFailed:
+		return "falhou"
+	case TransferCancelled:
+		return "cancelada"
+	default:
+		return "desconhecido"
+	}
+}
+
+// Transfer guarda o estado de uma transferência de arquivo com um peer
+type Transfer struct {
+	ID           string
+	Peer         string // Chave do peer no mapa Peers
+	Sender       string // Nickname declarado pelo remetente (apenas exibição)
+	FileName     string
+	Size         int64
+	TotalChunks  int
+	Incoming     bool
+	Archive      bool
+	State        TransferState
+	Paused       bool
+	PausedAt     time.Time
+	BytesDone    int64
+	WireBytes    int64  // Bytes efetivamente transmitidos (após compressão)
+	Compression  string // Algoritmo negociado para a transferência
+	Err          string
+	StartedAt    time.Time
+	LastActivity time.Time
+	FinishedAt   time.Time
+
+	chunks   [][]byte
+	partial  *os.File     // Pacote recebido sendo gravado em disco
+	limiter  *RateLimiter // Limite específico definido com /banda
+	received int
+}
+
+// Tabelas de transferências por peer: peer -> ID -> transferência.
+// Entradas e saídas ficam separadas para que um peer não consiga
+// interferir em uma transferência nossa escolhendo o mesmo ID.
+var (
+	incomingTransfers = make(map[string]map[string]*Transfer)
+	outgoingTransfers = make(map[string]map[string]*Transfer)
+	transfersMutex    sync.Mutex
+)
+
+// newTransferID gera um ID aleatório para uma transferência
+func newTransferID() (string, error) {
+	b := make([]byte, transferIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("erro ao gerar ID de transferência: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validTransferID verifica se o ID recebido tem o formato esperado
+func validTransferID(id string) bool {
+	if len(id) != transferIDBytes*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// IsActive indica se a transferência ainda não chegou a um estado final
+func (t *Transfer) IsActive() bool {
+	return t.State == TransferOffered || t.State == TransferInProgress
+}
+
+// finish move a transferência para um estado final e libera os buffers.
+// Deve ser chamada com transfersMutex travado.
+func (t *Transfer) finish(state TransferState, reason string) {
+	t.State = state
+	t.Paused = false
+	t.Err = reason
+	t.FinishedAt = time.Now()
+	t.chunks = nil
+	t.discardPartial()
+}
+
+// discardPartial fecha e remove o arquivo temporário de um pacote
+func (t *Transfer) discardPartial() {
+	if t.partial != nil {
+		t.partial.Close()
+		os.Remove(t.partial.Name())
+		t.partial = nil
+	}
+}
+
+var errDuplicateTransfer = errors.New("transferência duplicada")
+
+// addTransfer registra uma transferência na tabela do peer correspondente.
+// Falha se já existir uma transferência ativa com o mesmo ID ou se uma
+// transferência recebida passar dos limites do peer.
+func addTransfer(t *Transfer) error {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+
+	table := outgoingTransfers
+	if t.Incoming {
+		table = incomingTransfers
+	}
+
+	peerTable, ok := table[t.Peer]
+	if !ok {
+		peerTable = make(map[string]*Transfer)
+		table[t.Peer] = peerTable
+	}
+
+	if existing, exists := peerTable[t.ID]; exists && existing.IsActive() {
+		return errDuplicateTransfer
+	}
+	if t.Incoming {
+		if err := checkIncomingLimits(peerTable, t); err != nil {
+			return err
+		}
+	}
+
+	if t.limiter == nil {
+		t.limiter = NewRateLimiter(0)
+	}
+
+	now := time.Now()
+	t.StartedAt = now
+	t.LastActivity = now
+	peerTable[t.ID] = t
+	return nil
+}
+
+// checkIncomingLimits recusa uma oferta além das transferências simultâneas
+// ou dos bytes em memória permitidos por peer.
+// Deve ser chamada com transfersMutex travado.
+func checkIncomingLimits(peerTable map[string]*Transfer, t *Transfer) error {
+	active, buffered := 0, int64(0)
+	for _, other := range peerTable {
+		if other.IsActive() {
+			active++
+			if !other.Archive {
+				buffered += other.Size
+			}
+		}
+	}
+	if active >= maxIncomingPerPeer {
+		return fmt.Errorf("limite de %d transferências simultâneas por peer", maxIncomingPerPeer)
+	}
+	if !t.Archive && buffered+t.Size > maxBufferedPerPeer {
+		return fmt.Errorf("limite de %s em memória por peer", formatBytes(maxBufferedPerPeer))
+	}
+	return nil
+}
+
+// findTransfer busca uma transferência na tabela de um peer.
+// Deve ser chamada com transfersMutex travado.
+func findTransfer(peer, id string, incoming bool) *Transfer {
+	table := outgoingTransfers
+	if incoming {
+		table = incomingTransfers
+	}
+	return table[peer][id]
+}
+
+// failPeerTransfers marca como falhas as transferências ativas de um peer desconectado
+func failPeerTransfers(peer string) {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+
+	for _, table := range []map[string]map[string]*Transfer{incomingTransfers, outgoingTransfers} {
+		for _, t := range table[peer] {
+			if t.IsActive() {
+				t.finish(TransferFailed, "peer desconectado")
+				logMessage(fmt.Sprintf("Transferência %s ('%s') falhou: peer desconectado", t.ID, t.FileName))
+			}
+		}
+	}
+}
+
+// startTransferJanitor encerra transferências paradas e remove as antigas da memória
+func startTransferJanitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireTransfers(time.Now())
+	}
+}
+
+func expireTransfers(now time.Time) {
+	var expired []*Transfer
+
+	transfersMutex.Lock()
+	for _, table := range []map[string]map[string]*Transfer{incomingTransfers, outgoingTransfers} {
+		for peer, peerTable := range table {
+			for id, t := range peerTable {
+				// Pausas também expiram, para que um peer não segure uma
+				// transferência (e os chunks em memória) indefinidamente
+				if t.IsActive() && t.Paused && now.Sub(t.PausedAt) > pauseTimeout {
+					t.finish(TransferFailed, "pausada por tempo demais")
+					expired = append(expired, t)
+				} else if t.IsActive() && !t.Paused && now.Sub(t.LastActivity) > transferTimeout {
+					t.finish(TransferFailed, "tempo esgotado")
+					expired = append(expired, t)
+				} else if !t.IsActive() && now.Sub(t.FinishedAt) > transferRetention {
+					delete(peerTable, id)
+				}
+			}
+			if len(peerTable) == 0 {
+				delete(table, peer)
+			}
+		}
+	}
+	transfersMutex.Unlock()
+
+	for _, t := range expired {
+		updateChatView(fmt.Sprintf("⌛ Transferência %s ('%s') expirou por inatividade", t.ID, t.FileName))
+		logMessage(fmt.Sprintf("Transferência %s ('%s') expirou por inatividade", t.ID, t.FileName))
+	}
+}
+
+// Ações de controle trocadas entre os peers de uma transferência
+const (
+	controlCancel = "cancelar"
+	controlPause  = "pausar"
+	controlResume = "retomar"
+	controlRate   = "taxa"
+)
+
+// FileControl notifica o outro lado sobre mudanças em uma transferência
+type FileControl struct {
+	ID     string
+	Action string
+	Rate   int64 `json:",omitempty"` // Usado pela ação "taxa", em bytes/s
+}
+
+// Speed retorna a taxa média da transferência em bytes por segundo
+func (t *Transfer) Speed(now time.Time) float64 {
+	end := now
+	if !t.IsActive() {
+		end = t.FinishedAt
+	}
+	elapsed := end.Sub(t.StartedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(t.BytesDone) / elapsed
+}
+
+// ETA estima o tempo restante com base na taxa média
+func (t *Transfer) ETA(now time.Time) time.Duration {
+	speed := t.Speed(now)
+	if speed <= 0 || t.Paused {
+		return -1
+	}
+	remaining := t.Size - t.BytesDone
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(float64(remaining)/speed) * time.Second
+}
+
+// compressionSummary descreve a economia obtida com a compressão
+func (t *Transfer) compressionSummary() string {
+	if t.Compression == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s → %s, %s)", formatBytes(t.BytesDone), formatBytes(t.WireBytes),
+		compressionRatio(t.WireBytes, t.BytesDone))
+}
+
+// Progress retorna a fração concluída entre 0 e 1
+func (t *Transfer) Progress() float64 {
+	if t.Size <= 0 {
+		if t.State == TransferComplete {
+			return 1
+		}
+		return 0
+	}
+	return float64(t.BytesDone) / float64(t.Size)
+}
+
+// snapshotTransfers copia as transferências para exibição, mais recentes por último
+func snapshotTransfers(activeOnly bool) []Transfer {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+
+	var list []Transfer
+	for _, table := range []map[string]map[string]*Transfer{incomingTransfers, outgoingTransfers} {
+		for _, peerTable := range table {
+			for _, t := range peerTable {
+				if activeOnly && !t.IsActive() {
+					continue
+				}
+				copyT := *t
+				copyT.chunks = nil
+				copyT.partial = nil
+				list = append(list, copyT)
+			}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// matchTransfers encontra as transferências ativas cujo ID começa com o prefixo informado.
+// Deve ser chamada com transfersMutex travado.
+func matchTransfers(prefix string) []*Transfer {
+	var matches []*Transfer
+	for _, table := range []map[string]map[string]*Transfer{incomingTransfers, outgoingTransfers} {
+		for _, peerTable := range table {
+			for id, t := range peerTable {
+				if strings.HasPrefix(id, prefix) && t.IsActive() {
+					matches = append(matches, t)
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// sendFileControl avisa um peer sobre uma ação em uma transferência
+func sendFileControl(peer, id, action string) {
+	data, err := json.Marshal(FileControl{ID: id, Action: action})
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityControl, "[FILE_CONTROL]"+string(data))
+}
+
+// sendFileControlRate pede ao remetente que limite a taxa de uma transferência
+func sendFileControlRate(peer, id string, rate int64) {
+	data, err := json.Marshal(FileControl{ID: id, Action: controlRate, Rate: rate})
+	if err != nil {
+		return
+	}
+	peerSend(peer, priorityControl, "[FILE_CONTROL]"+string(data))
+}
+
+// controlTransfers aplica uma ação local às transferências com o ID informado
+// e notifica os peers envolvidos
+func controlTransfers(prefix, action string) (string, error) {
+	if prefix == "" {
+		return "", fmt.Errorf("ID de transferência vazio")
+	}
+
+	transfersMutex.Lock()
+	matches := matchTransfers(prefix)
+	ids := make(map[string]bool)
+	for _, t := range matches {
+		ids[t.ID] = true
+	}
+	if len(matches) == 0 {
+		transfersMutex.Unlock()
+		return "", fmt.Errorf("nenhuma transferência ativa com ID '%s'", prefix)
+	}
+	if len(ids) > 1 {
+		transfersMutex.Unlock()
+		return "", fmt.Errorf("ID '%s' é ambíguo, informe mais caracteres", prefix)
+	}
+
+	type notice struct{ peer, id string }
+	var notices []notice
+	fileName := matches[0].FileName
+	for _, t := range matches {
+		applyControl(t, action, "cancelada localmente")
+		notices = append(notices, notice{t.Peer, t.ID})
+	}
+	transfersMutex.Unlock()
+
+	for _, n := range notices {
+		sendFileControl(n.peer, n.id, action)
+	}
+
+	logMessage(fmt.Sprintf("Transferência %s ('%s'): ação '%s'", matches[0].ID, fileName, action))
+	return fileName, nil
+}
+
+// applyControl altera o estado de uma transferência conforme a ação.
+// Deve ser chamada com transfersMutex travado.
+func applyControl(t *Transfer, action, reason string) {
+	switch action {
+	case controlCancel:
+		t.finish(TransferCancelled, reason)
+	case controlPause:
+		if !t.Paused {
+			t.PausedAt = time.Now()
+		}
+		t.Paused = true
+	case controlResume:
+		t.Paused = false
+		t.LastActivity = time.Now()
+	}
+}
+
+// handleFileControl trata uma ação de controle enviada pelo outro lado
+func handleFileControl(peer string, data string) {
+	var ctrl FileControl
+	if err := json.Unmarshal([]byte(data), &ctrl); err != nil {
+		logMessage(fmt.Sprintf("Erro ao processar controle de transferência: %v", err))
+		return
+	}
+
+	if ctrl.Action == controlRate {
+		// Só o remetente controla o ritmo: a taxa vale para nossas transferências de saída
+		transfersMutex.Lock()
+		if t := findTransfer(peer, ctrl.ID, false); t != nil && t.IsActive() && ctrl.Rate >= 0 {
+			t.limiter.SetRate(ctrl.Rate)
+		}
+		transfersMutex.Unlock()
+		return
+	}
+
+	if ctrl.Action != controlCancel && ctrl.Action != controlPause && ctrl.Action != controlResume {
+		logMessage(fmt.Sprintf("Ação de transferência desconhecida de %s: %s", peer, ctrl.Action))
+		return
+	}
+
+	transfersMutex.Lock()
+	t := findTransfer(peer, ctrl.ID, false)
+	if t == nil || !t.IsActive() {
+		t = findTransfer(peer, ctrl.ID, true)
+	}
+	if t == nil || !t.IsActive() {
+		transfersMutex.Unlock()
+		return
+	}
+	applyControl(t, ctrl.Action, "cancelada pelo peer")
+	fileName := t.FileName
+	transfersMutex.Unlock()
+
+	switch ctrl.Action {
+	case controlCancel:
+		updateChatView(fmt.Sprintf("🚫 Transferência '%s' [%s] cancelada por %s", fileName, ctrl.ID, peer))
+	case controlPause:
+		updateChatView(fmt.Sprintf("⏸️  Transferência '%s' [%s] pausada por %s", fileName, ctrl.ID, peer))
+	case controlResume:
+		updateChatView(fmt.Sprintf("▶️  Transferência '%s' [%s] retomada por %s", fileName, ctrl.ID, peer))
+	}
+	logMessage(fmt.Sprintf("Transferência %s ('%s'): ação '%s' recebida de %s", ctrl.ID, fileName, ctrl.Action, peer))
+}
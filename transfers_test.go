@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// resetTransfers começa o teste com as tabelas vazias, gravando os logs em
+// um diretório temporário
+func resetTransfers(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	transfersMutex.Lock()
+	incomingTransfers = make(map[string]map[string]*Transfer)
+	outgoingTransfers = make(map[string]map[string]*Transfer)
+	transfersMutex.Unlock()
+}
+
+func incoming(peer string, n int, size int64, archive bool) *Transfer {
+	return &Transfer{ID: fmt.Sprintf("%016x", n), Peer: peer, FileName: "a.txt", Size: size, Incoming: true, Archive: archive}
+}
+
+func TestIncomingTransferLimits(t *testing.T) {
+	resetTransfers(t)
+
+	for i := 0; i < maxIncomingPerPeer; i++ {
+		if err := addTransfer(incoming("10.0.0.1:9000", i, 1024, false)); err != nil {
+			t.Fatalf("transferência %d recusada: %v", i, err)
+		}
+	}
+	if err := addTransfer(incoming("10.0.0.1:9000", 99, 1024, false)); err == nil {
+		t.Error("aceitou mais transferências simultâneas que o limite")
+	}
+	if err := addTransfer(incoming("10.0.0.1:9000", 0, 1024, false)); err != errDuplicateTransfer {
+		t.Errorf("oferta repetida: %v", err)
+	}
+
+	// O limite é por peer, e transferências encerradas não contam
+	if err := addTransfer(incoming("10.0.0.2:9000", 0, 1024, false)); err != nil {
+		t.Errorf("outro peer recusado: %v", err)
+	}
+	transfersMutex.Lock()
+	findTransfer("10.0.0.1:9000", fmt.Sprintf("%016x", 1), true).finish(TransferComplete, "")
+	transfersMutex.Unlock()
+	if err := addTransfer(incoming("10.0.0.1:9000", 100, 1024, false)); err != nil {
+		t.Errorf("vaga liberada não foi reaproveitada: %v", err)
+	}
+}
+
+func TestIncomingBufferedBytesLimit(t *testing.T) {
+	resetTransfers(t)
+
+	if err := addTransfer(incoming("10.0.0.1:9000", 1, maxBufferedPerPeer/2, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTransfer(incoming("10.0.0.1:9000", 2, maxBufferedPerPeer/2, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTransfer(incoming("10.0.0.1:9000", 3, 1, false)); err == nil {
+		t.Error("aceitou mais bytes em memória que o limite")
+	}
+	// Pacotes vão para o disco e não contam nos bytes em memória
+	if err := addTransfer(incoming("10.0.0.1:9000", 4, maxBufferedPerPeer, true)); err != nil {
+		t.Errorf("pacote recusado: %v", err)
+	}
+}
+
+// Uma pausa pedida pelo peer não segura a transferência para sempre
+func TestPausedTransferExpires(t *testing.T) {
+	resetTransfers(t)
+
+	tr := incoming("10.0.0.1:9000", 1, 1024, false)
+	if err := addTransfer(tr); err != nil {
+		t.Fatal(err)
+	}
+	transfersMutex.Lock()
+	applyControl(tr, controlPause, "")
+	transfersMutex.Unlock()
+
+	now := time.Now()
+	expireTransfers(now.Add(pauseTimeout - time.Minute))
+	if !tr.IsActive() {
+		t.Fatal("pausa curta expirou como inatividade")
+	}
+	expireTransfers(now.Add(pauseTimeout + time.Minute))
+	if tr.State != TransferFailed {
+		t.Errorf("pausa longa não expirou: %s", tr.State)
+	}
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// Tamanho do material exportado por ChannelBinding
+const bindingSize = 32
+
+// tlsStater é implementado pelas conexões que escondem a sessão TLS
+// (QUIC e WebSocket)
+type tlsStater interface {
+	tlsState() (tls.ConnectionState, bool)
+}
+
+// ChannelBinding exporta material da sessão TLS da conexão (RFC 5705) com
+// o rótulo informado. Os dois lados obtêm o mesmo valor, e quem intercepta
+// a conexão tem sessões diferentes de cada lado, então assinaturas sobre ele
+// não podem ser repassadas. Retorna nil em transportes sem TLS próprio.
+func ChannelBinding(conn net.Conn, label string) []byte {
+	var state tls.ConnectionState
+	switch c := conn.(type) {
+	case *tls.Conn:
+		state = c.ConnectionState()
+	case tlsStater:
+		s, ok := c.tlsState()
+		if !ok {
+			return nil
+		}
+		state = s
+	default:
+		return nil
+	}
+	if !state.HandshakeComplete {
+		return nil
+	}
+	material, err := state.ExportKeyingMaterial(label, nil, bindingSize)
+	if err != nil {
+		return nil
+	}
+	return material
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// MemoryTransport conecta peers do mesmo processo por net.Pipe, sem sockets.
+// Útil para exercitar a lógica dos peers isoladamente. O chat não o registra:
+// só os testes usam endereços mem://, com Register(NewMemory()).
+type MemoryTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	next      int // Numera os clientes para que cada conexão tenha endereço próprio
+}
+
+// NewMemory cria um transporte em memória vazio
+func NewMemory() *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener)}
+}
+
+// Name implementa Transport
+func (m *MemoryTransport) Name() string {
+	return "mem"
+}
+
+// Dial implementa Transport
+func (m *MemoryTransport) Dial(address string) (net.Conn, error) {
+	m.mu.Lock()
+	l, ok := m.listeners[address]
+	m.next++
+	local := memoryAddr(fmt.Sprintf("cliente-%d", m.next))
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("ninguém escutando em mem://%s", address)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &memoryConn{Conn: server, local: l.addr, remote: local}:
+		return &memoryConn{Conn: client, local: local, remote: l.addr}, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("listener mem://%s fechado", address)
+	}
+}
+
+// Listen implementa Transport
+func (m *MemoryTransport) Listen(address string) (net.Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.listeners[address]; exists {
+		return nil, fmt.Errorf("endereço mem://%s já em uso", address)
+	}
+
+	l := &memoryListener{
+		owner: m,
+		addr:  memoryAddr(address),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	m.listeners[address] = l
+	return l, nil
+}
+
+type memoryListener struct {
+	owner *MemoryTransport
+	addr  memoryAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.owner.mu.Lock()
+		delete(l.owner.listeners, string(l.addr))
+		l.owner.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryConn substitui os endereços "pipe" do net.Pipe pelos endereços mem://
+type memoryConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.remote }
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "mem" }
+func (a memoryAddr) String() string  { return string(a) }
@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"magician/mux"
+)
+
+// O transporte em memória só é registrado pelos testes
+func init() {
+	Register(NewMemory())
+}
+
+// acceptOne aceita uma conexão em segundo plano
+func acceptOne(t *testing.T, ln net.Listener) <-chan net.Conn {
+	t.Helper()
+	ch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- conn
+	}()
+	return ch
+}
+
+func TestMemoryDialByScheme(t *testing.T) {
+	tr, addr, err := ForAddress("mem://sala-esquema")
+	if err != nil {
+		t.Fatalf("ForAddress: %v", err)
+	}
+	if tr.Name() != "mem" || addr != "sala-esquema" {
+		t.Fatalf("esperava mem e sala-esquema, veio %s e %s", tr.Name(), addr)
+	}
+
+	ln, err := tr.Listen(addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := acceptOne(t, ln)
+
+	client, err := Dial("mem://sala-esquema")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	if server == nil {
+		t.Fatal("nenhuma conexão aceita")
+	}
+	defer server.Close()
+
+	go fmt.Fprintln(client, "AUTH teste")
+	line, err := bufio.NewReader(server).ReadString('\n')
+	if err != nil || line != "AUTH teste\n" {
+		t.Fatalf("linha recebida: %q, %v", line, err)
+	}
+
+	if server.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("endereços não conferem: %s e %s", server.RemoteAddr(), client.LocalAddr())
+	}
+	if client.RemoteAddr().String() != "sala-esquema" || client.RemoteAddr().Network() != "mem" {
+		t.Errorf("endereço remoto inesperado: %s/%s", client.RemoteAddr().Network(), client.RemoteAddr())
+	}
+}
+
+// Cada conexão precisa de endereço próprio, porque o chat usa o endereço
+// remoto como chave das tabelas de peers
+func TestMemoryDistinctRemoteAddrs(t *testing.T) {
+	m := NewMemory()
+	ln, err := m.Listen("sala")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		accepted := acceptOne(t, ln)
+		client, err := m.Dial("sala")
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		server := <-accepted
+		remote := server.RemoteAddr().String()
+		if seen[remote] {
+			t.Fatalf("endereço remoto repetido: %s", remote)
+		}
+		seen[remote] = true
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestMemoryListenErrors(t *testing.T) {
+	m := NewMemory()
+	if _, err := m.Dial("ninguem"); err == nil {
+		t.Error("Dial sem listener deveria falhar")
+	}
+
+	ln, err := m.Listen("sala")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if _, err := m.Listen("sala"); err == nil {
+		t.Error("Listen no mesmo endereço deveria falhar")
+	}
+
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept após Close: %v", err)
+	}
+	if _, err := m.Dial("sala"); err == nil {
+		t.Error("Dial após Close deveria falhar")
+	}
+
+	// O endereço fica livre de novo
+	ln, err = m.Listen("sala")
+	if err != nil {
+		t.Fatalf("Listen após Close: %v", err)
+	}
+	ln.Close()
+}
+
+// A sessão multiplexada roda sobre o transporte em memória como sobre TLS:
+// é assim que a lógica dos peers pode ser testada sem sockets
+func TestMemoryMuxSession(t *testing.T) {
+	m := NewMemory()
+	ln, err := m.Listen("mux")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := acceptOne(t, ln)
+
+	conn, err := m.Dial("mux")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client := mux.NewSession(conn, true)
+	server := mux.NewSession(<-accepted, false)
+	defer client.Close()
+	defer server.Close()
+
+	const streams = 3
+	for i := 0; i < streams; i++ {
+		st, err := client.Open(i % mux.NumPriorities)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		go func(i int) {
+			fmt.Fprintf(st, "fluxo %d\n", i)
+			st.Close()
+		}(i)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < streams; i++ {
+		st, err := server.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		data, err := io.ReadAll(st)
+		if err != nil {
+			t.Fatalf("leitura do fluxo: %v", err)
+		}
+		got[string(data)] = true
+		st.Close()
+	}
+	for i := 0; i < streams; i++ {
+		if !got[fmt.Sprintf("fluxo %d\n", i)] {
+			t.Errorf("fluxo %d não chegou: %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/quic"
+
+	"magician/tor"
+)
+
+// Protocolo ALPN negociado nas conexões QUIC entre peers
+const quicALPN = "magician"
+
+// PunchMagic inicia os datagramas de hole punching, que dividem o socket UDP
+// com o QUIC mas nunca chegam a ele
+var PunchMagic = []byte("MGPUNCH ")
+
+// PunchHandler recebe os datagramas de hole punching (sem o prefixo)
+type PunchHandler func(msg []byte, from netip.AddrPort)
+
+// QUICTransport carrega o protocolo dos peers em QUIC (UDP cifrado com TLS
+// 1.3 e entrega confiável). O mesmo socket UDP é usado para escutar, discar e
+// abrir caminho nos NATs, para que o mapeamento criado no roteador sirva à conexão.
+//
+// Experimental: golang.org/x/net/quic ainda não tem API estável. A versão
+// fica fixada no go.mod; ao atualizá-la, rode os testes deste pacote e os do
+// rendezvous, que cobrem o Accept, o vínculo TLS e o hole punching.
+type QUICTransport struct {
+	server *tls.Config
+	client *tls.Config
+
+	mu       sync.Mutex
+	endpoint *quic.Endpoint
+	socket   *punchConn
+	onPunch  PunchHandler
+}
+
+// NewQUIC cria o transporte QUIC
+func NewQUIC(server, client *tls.Config) *QUICTransport {
+	server = server.Clone()
+	server.NextProtos = []string{quicALPN}
+	client = client.Clone()
+	client.NextProtos = []string{quicALPN}
+	return &QUICTransport{server: server, client: client}
+}
+
+// Name implementa Transport
+func (t *QUICTransport) Name() string {
+	return "quic"
+}
+
+func (t *QUICTransport) config() *quic.Config {
+	return &quic.Config{
+		TLSConfig:       t.server,
+		MaxIdleTimeout:  2 * time.Minute,
+		KeepAlivePeriod: 20 * time.Second, // Mantém vivo o mapeamento no NAT
+	}
+}
+
+// open cria o endpoint no endereço informado, se ainda não existir
+func (t *QUICTransport) open(address string) (*quic.Endpoint, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.endpoint != nil {
+		return t.endpoint, nil
+	}
+
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	t.socket = &punchConn{PacketConn: pc, transport: t}
+	endpoint, err := quic.NewEndpoint(t.socket, t.config())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	t.endpoint = endpoint
+	return endpoint, nil
+}
+
+// OnPunch define quem trata os datagramas de hole punching
+func (t *QUICTransport) OnPunch(handler PunchHandler) {
+	t.mu.Lock()
+	t.onPunch = handler
+	t.mu.Unlock()
+}
+
+// LocalPort retorna a porta UDP em uso (0 se o socket ainda não foi aberto)
+func (t *QUICTransport) LocalPort() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.socket == nil {
+		return 0
+	}
+	return t.socket.LocalAddr().(*net.UDPAddr).Port
+}
+
+// SendPunch envia um datagrama de hole punching pelo socket compartilhado
+func (t *QUICTransport) SendPunch(address string, msg []byte) error {
+	if tor.Default().Config().Everything {
+		return fmt.Errorf("hole punching desativado: todas as conexões devem passar pelo Tor")
+	}
+	if _, err := t.open(":0"); err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	_, err = t.socket.PacketConn.WriteTo(append(append([]byte{}, PunchMagic...), msg...), addr)
+	return err
+}
+
+// Dial implementa Transport
+func (t *QUICTransport) Dial(address string) (net.Conn, error) {
+	// O Tor só transporta TCP: com tudo pelo Tor, o QUIC vazaria o IP
+	if tor.Default().Config().Everything {
+		return nil, fmt.Errorf("QUIC desativado: todas as conexões devem passar pelo Tor")
+	}
+
+	endpoint, err := t.open(":0")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	config := t.config()
+	config.TLSConfig = t.client
+	conn, err := endpoint.Dial(ctx, "udp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar via QUIC: %w", err)
+	}
+	stream, err := conn.NewStream(ctx)
+	if err != nil {
+		conn.Abort(nil)
+		return nil, err
+	}
+	return newQUICConn(conn, stream), nil
+}
+
+// Listen implementa Transport
+func (t *QUICTransport) Listen(address string) (net.Listener, error) {
+	endpoint, err := t.open(address)
+	if err != nil {
+		return nil, err
+	}
+	l := &quicListener{
+		endpoint:  endpoint,
+		transport: t,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		pending:   make(chan struct{}, quicPendingStreams),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// punchConn separa os datagramas de hole punching dos pacotes QUIC
+type punchConn struct {
+	net.PacketConn
+	transport *QUICTransport
+}
+
+func (c *punchConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+
+		// Em sockets dual-stack, endereços IPv4 chegam mapeados em IPv6
+		// ("::ffff:1.2.3.4") e não casariam com o endereço discado
+		udp, ok := addr.(*net.UDPAddr)
+		if ok {
+			ap := udp.AddrPort()
+			ap = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+			addr = net.UDPAddrFromAddrPort(ap)
+		}
+		if !bytes.HasPrefix(b[:n], PunchMagic) {
+			return n, addr, nil
+		}
+
+		c.transport.mu.Lock()
+		handler := c.transport.onPunch
+		c.transport.mu.Unlock()
+		if ok && handler != nil {
+			msg := append([]byte{}, b[len(PunchMagic):n]...)
+			handler(msg, addr.(*net.UDPAddr).AddrPort())
+		}
+	}
+}
+
+// Conexões QUIC aceitas que ainda não abriram o fluxo; acima disso as novas
+// são recusadas
+const quicPendingStreams = 64
+
+type quicListener struct {
+	endpoint  *quic.Endpoint
+	transport *QUICTransport
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	pending   chan struct{}
+}
+
+// serve aceita conexões e espera o fluxo de cada uma em paralelo, para que
+// uma conexão que não abre o fluxo não atrase as outras
+func (l *quicListener) serve() {
+	defer l.shutdown()
+	for {
+		conn, err := l.endpoint.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		select {
+		case l.pending <- struct{}{}:
+			go l.awaitStream(conn)
+		default:
+			conn.Abort(nil)
+		}
+	}
+}
+
+// awaitStream espera o único fluxo QUIC usado entre peers
+func (l *quicListener) awaitStream(conn *quic.Conn) {
+	defer func() { <-l.pending }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	stream, err := conn.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		conn.Abort(nil)
+		return
+	}
+
+	c := newQUICConn(conn, stream)
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *quicListener) shutdown() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	l.shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return l.endpoint.Close(ctx)
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return net.UDPAddrFromAddrPort(l.endpoint.LocalAddr())
+}
+
+// quicConn adapta um fluxo QUIC para net.Conn
+type quicConn struct {
+	conn   *quic.Conn
+	stream *quic.Stream
+
+	mu          sync.Mutex
+	cancelRead  context.CancelFunc
+	cancelWrite context.CancelFunc
+	closeOnce   sync.Once
+}
+
+func newQUICConn(conn *quic.Conn, stream *quic.Stream) *quicConn {
+	return &quicConn{conn: conn, stream: stream}
+}
+
+func (c *quicConn) Read(b []byte) (int, error) {
+	return c.stream.Read(b)
+}
+
+// Write envia imediatamente: o fluxo QUIC acumula os dados até um Flush
+func (c *quicConn) Write(b []byte) (int, error) {
+	n, err := c.stream.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.stream.Flush()
+}
+
+func (c *quicConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stream.CloseRead()
+		c.stream.CloseWrite()
+		c.conn.Abort(nil)
+	})
+	return nil
+}
+
+func (c *quicConn) tlsState() (tls.ConnectionState, bool) {
+	return c.conn.ConnectionState(), true
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.conn.LocalAddr())
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.conn.RemoteAddr())
+}
+
+func (c *quicConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline traduz o prazo para o contexto de leitura do fluxo
+func (c *quicConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelRead != nil {
+		c.cancelRead()
+		c.cancelRead = nil
+	}
+	if t.IsZero() {
+		c.stream.SetReadContext(context.Background())
+		return nil
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), t)
+	c.cancelRead = cancel
+	c.stream.SetReadContext(ctx)
+	return nil
+}
+
+// SetWriteDeadline traduz o prazo para o contexto de escrita do fluxo
+func (c *quicConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelWrite != nil {
+		c.cancelWrite()
+		c.cancelWrite = nil
+	}
+	if t.IsZero() {
+		c.stream.SetWriteContext(context.Background())
+		return nil
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), t)
+	c.cancelWrite = cancel
+	c.stream.SetWriteContext(ctx)
+	return nil
+}
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// testTLS gera um certificado autoassinado para os testes
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "magician-teste"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13},
+		&tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13}
+}
+
+func listenQUIC(t *testing.T) (*QUICTransport, net.Listener, *tls.Config) {
+	t.Helper()
+	server, client := testTLS(t)
+	tr := NewQUIC(server, client)
+	ln, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return tr, ln, client
+}
+
+// Uma conexão que nunca abre o fluxo não pode segurar as seguintes
+func TestQUICAcceptNotBlockedByIdleConn(t *testing.T) {
+	_, ln, clientTLS := listenQUIC(t)
+	addr := ln.Addr().String()
+
+	// Conexão QUIC completa, mas sem fluxo
+	idleTLS := clientTLS.Clone()
+	idleTLS.NextProtos = []string{quicALPN}
+	idle, err := quic.Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	idleConn, err := idle.Dial(ctx, "udp", addr, &quic.Config{TLSConfig: idleTLS})
+	if err != nil {
+		t.Fatalf("Dial ocioso: %v", err)
+	}
+	defer idleConn.Abort(nil)
+
+	_, clientTLS2 := testTLS(t)
+	dialer := NewQUIC(clientTLS2, clientTLS2)
+	conn, err := dialer.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	// O fluxo só chega ao outro lado com dados
+	if _, err := conn.Write([]byte("oi\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "oi\n" {
+			t.Errorf("leitura: %q %v", buf, err)
+		}
+
+		// As duas pontas exportam o mesmo material da sessão TLS
+		a, b := ChannelBinding(conn, "EXPORTER-teste"), ChannelBinding(c, "EXPORTER-teste")
+		if len(a) != bindingSize || !bytes.Equal(a, b) {
+			t.Errorf("material exportado diferente: %x e %x", a, b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept ficou preso na conexão sem fluxo")
+	}
+}
+
+func TestQUICCloseUnblocksAccept(t *testing.T) {
+	_, ln, _ := listenQUIC(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ln.Close()
+
+	select {
+	case err := <-done:
+		if err != net.ErrClosed {
+			t.Errorf("Accept após Close: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept não retornou após Close")
+	}
+}
+
+// O fim do hole punching: os dois lados trocam datagramas pelo socket do
+// QUIC e um disca para o outro por esse mesmo socket, então o outro vê a
+// conexão chegar da porta que o rendezvous observou
+func TestQUICPunchThenDial(t *testing.T) {
+	a, _, _ := listenQUIC(t)
+	b, lnB, _ := listenQUIC(t)
+	addrA := net.JoinHostPort("127.0.0.1", strconv.Itoa(a.LocalPort()))
+	addrB := lnB.Addr().String()
+
+	hellos := make(chan netip.AddrPort, 1)
+	b.OnPunch(func(msg []byte, from netip.AddrPort) {
+		if string(msg) == "HELLO a" {
+			select {
+			case hellos <- from:
+			default:
+			}
+		}
+	})
+	// Datagramas de punch nunca chegam ao QUIC nem ao Accept
+	a.OnPunch(func([]byte, netip.AddrPort) {})
+
+	if err := b.SendPunch(addrA, []byte("HELLO b")); err != nil {
+		t.Fatalf("SendPunch: %v", err)
+	}
+	if err := a.SendPunch(addrB, []byte("HELLO a")); err != nil {
+		t.Fatalf("SendPunch: %v", err)
+	}
+	select {
+	case from := <-hellos:
+		if int(from.Port()) != a.LocalPort() {
+			t.Errorf("punch veio da porta %d, esperava %d", from.Port(), a.LocalPort())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("punch não chegou")
+	}
+
+	conn, err := a.Dial(addrB)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("oi\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	accepted, err := lnB.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer accepted.Close()
+	_, port, _ := net.SplitHostPort(accepted.RemoteAddr().String())
+	if port != strconv.Itoa(a.LocalPort()) {
+		t.Errorf("conexão veio da porta %s, esperava a do socket de punch (%d)", port, a.LocalPort())
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "oi\n" {
+		t.Errorf("leitura: %q %v", buf, err)
+	}
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"magician/identity"
+	"magician/relay"
+)
+
+// RelayTransport alcança peers através de um relay. Endereços de discagem
+// têm a forma "[senha@]host:porta/ID" e de escuta "[senha@]host:porta".
+type RelayTransport struct {
+	key    ed25519.PrivateKey
+	server *tls.Config
+	client *tls.Config
+
+	mu      sync.Mutex
+	clients map[string]*relay.Client
+}
+
+// NewRelay cria o transporte via relay com a identidade deste peer
+func NewRelay(key ed25519.PrivateKey, server, client *tls.Config) *RelayTransport {
+	return &RelayTransport{
+		key:     key,
+		server:  server,
+		client:  client,
+		clients: make(map[string]*relay.Client),
+	}
+}
+
+// Name implementa Transport
+func (t *RelayTransport) Name() string {
+	return "relay"
+}
+
+// splitRelayAddress separa senha, endereço do relay e ID do peer
+func splitRelayAddress(address string) (password, hostPort, peerID string) {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		password, address = address[:i], address[i+1:]
+	}
+	hostPort = address
+	if i := strings.Index(address, "/"); i >= 0 {
+		hostPort, peerID = address[:i], address[i+1:]
+	}
+	return password, hostPort, peerID
+}
+
+// connect retorna a sessão com o relay, abrindo uma nova se preciso
+func (t *RelayTransport) connect(password, hostPort string) (*relay.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[hostPort]; ok {
+		select {
+		case <-c.Done():
+			delete(t.clients, hostPort)
+		default:
+			return c, nil
+		}
+	}
+
+	conn, err := Dial(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar ao relay %s: %w", hostPort, err)
+	}
+	c, err := relay.Connect(conn, hostPort, password, t.key, t.server, t.client)
+	if err != nil {
+		return nil, err
+	}
+	t.clients[hostPort] = c
+	return c, nil
+}
+
+// Dial implementa Transport
+func (t *RelayTransport) Dial(address string) (net.Conn, error) {
+	password, hostPort, peerID := splitRelayAddress(address)
+	if !identity.ValidID(peerID) {
+		return nil, fmt.Errorf("ID de peer inválido: '%s'", peerID)
+	}
+
+	c, err := t.connect(password, hostPort)
+	if err != nil {
+		return nil, err
+	}
+	return c.Dial(peerID)
+}
+
+// Listen implementa Transport: registra este peer no relay para receber circuitos
+func (t *RelayTransport) Listen(address string) (net.Listener, error) {
+	password, hostPort, _ := splitRelayAddress(address)
+
+	c, err := t.connect(password, hostPort)
+	if err != nil {
+		return nil, err
+	}
+	return c.Listen()
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"magician/tor"
+)
+
+// Tempo máximo para concluir o handshake TLS
+const handshakeTimeout = 30 * time.Second
+
+// DialFunc abre a conexão bruta sobre a qual o TLS é negociado
+type DialFunc func(address string) (net.Conn, error)
+
+// TLSTransport é TLS sobre uma conexão de fluxo (TCP direto ou via Tor)
+type TLSTransport struct {
+	name   string
+	server *tls.Config
+	client *tls.Config
+	dial   DialFunc
+}
+
+// NewTLS cria um transporte TLS que abre as conexões com dial
+func NewTLS(name string, server, client *tls.Config, dial DialFunc) *TLSTransport {
+	return &TLSTransport{name: name, server: server, client: client, dial: dial}
+}
+
+// NewTCP cria o transporte padrão: TLS sobre TCP. A conexão passa pelo
+// dialer do Tor, que só usa o proxy se ele estiver configurado para tudo.
+func NewTCP(server, client *tls.Config) *TLSTransport {
+	return NewTLS("tcp", server, client, tor.DialOrDirect)
+}
+
+// NewTor cria o transporte TLS sobre o proxy SOCKS do Tor. As conexões
+// recebidas chegam pelo serviço onion, encaminhadas para a porta local.
+func NewTor(server, client *tls.Config) *TLSTransport {
+	return NewTLS("tor", server, client, tor.DialOrDirect)
+}
+
+// Name implementa Transport
+func (t *TLSTransport) Name() string {
+	return t.name
+}
+
+// Dial implementa Transport
+func (t *TLSTransport) Dial(address string) (net.Conn, error) {
+	rawConn, err := t.dial(address)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar (%s): %w", t.name, err)
+	}
+
+	conn := tls.Client(rawConn, t.client)
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro no handshake TLS: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Listen implementa Transport
+func (t *TLSTransport) Listen(address string) (net.Listener, error) {
+	return tls.Listen("tcp", address, t.server)
+}
@@ -0,0 +1,69 @@
+// Package transport abstrai como os peers se conectam: cada transporte
+// entrega uma conexão já pronta para o protocolo de linhas do chat.
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"magician/tor"
+)
+
+// Transport disca e escuta conexões entre peers
+type Transport interface {
+	// Name identifica o transporte (também usado como esquema: "mem://...")
+	Name() string
+	// Dial abre uma conexão com o endereço, com handshake concluído
+	Dial(address string) (net.Conn, error)
+	// Listen aceita conexões no endereço informado
+	Listen(address string) (net.Listener, error)
+}
+
+var (
+	mu         sync.RWMutex
+	transports = make(map[string]Transport)
+)
+
+// Register adiciona ou substitui um transporte
+func Register(t Transport) {
+	mu.Lock()
+	defer mu.Unlock()
+	transports[t.Name()] = t
+}
+
+// Get retorna o transporte registrado com o nome
+func Get(name string) (Transport, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := transports[name]
+	return t, ok
+}
+
+// ForAddress escolhe o transporte de um endereço. Um esquema explícito
+// ("mem://sala") tem prioridade; endereços .onion usam "tor" e os demais "tcp".
+// Retorna também o endereço sem o esquema.
+func ForAddress(address string) (Transport, string, error) {
+	name, addr := "tcp", address
+	if i := strings.Index(address, "://"); i >= 0 {
+		name, addr = address[:i], address[i+3:]
+	} else if _, _, err := net.SplitHostPort(address); err == nil && tor.IsOnion(address) {
+		name = "tor"
+	}
+
+	t, ok := Get(name)
+	if !ok {
+		return nil, "", fmt.Errorf("transporte desconhecido: %s", name)
+	}
+	return t, addr, nil
+}
+
+// Dial conecta ao endereço usando o transporte adequado
+func Dial(address string) (net.Conn, error) {
+	t, addr, err := ForAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return t.Dial(addr)
+}
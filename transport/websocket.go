@@ -0,0 +1,283 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"magician/tor"
+)
+
+// WebSocketTransport leva o protocolo dos peers em mensagens WebSocket
+// binárias, para redes que só liberam HTTP(S) ou atrás de proxies reversos.
+// "wss" usa TLS; "ws" é texto puro, para quando o proxy já termina o TLS.
+type WebSocketTransport struct {
+	secure bool
+	server *tls.Config
+	client *tls.Config
+}
+
+// NewWebSocket cria o transporte "wss" (secure) ou "ws"
+func NewWebSocket(secure bool, server, client *tls.Config) *WebSocketTransport {
+	return &WebSocketTransport{secure: secure, server: server, client: client}
+}
+
+// Name implementa Transport
+func (t *WebSocketTransport) Name() string {
+	if t.secure {
+		return "wss"
+	}
+	return "ws"
+}
+
+// splitWebSocketAddress separa "host:porta/caminho" em endereço e caminho
+func splitWebSocketAddress(address string, defaultPort string) (string, string) {
+	hostPort, path := address, "/"
+	if i := strings.Index(address, "/"); i >= 0 {
+		hostPort, path = address[:i], address[i:]
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(strings.Trim(hostPort, "[]"), defaultPort)
+	}
+	return hostPort, path
+}
+
+// Dial implementa Transport. Respeita HTTPS_PROXY/HTTP_PROXY do ambiente.
+func (t *WebSocketTransport) Dial(address string) (net.Conn, error) {
+	scheme, origin, port := "ws", "http", "80"
+	if t.secure {
+		scheme, origin, port = "wss", "https", "443"
+	}
+	hostPort, path := splitWebSocketAddress(address, port)
+
+	config, err := websocket.NewConfig(scheme+"://"+hostPort+path, origin+"://"+hostPort+"/")
+	if err != nil {
+		return nil, fmt.Errorf("endereço WebSocket inválido: %w", err)
+	}
+
+	rawConn, err := dialThroughProxy(origin, hostPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn = rawConn
+	var tlsConn *tls.Conn
+	if t.secure {
+		host, _, _ := net.SplitHostPort(hostPort)
+		client := t.client.Clone()
+		if client.ServerName == "" {
+			client.ServerName = host
+		}
+		tlsConn = tls.Client(rawConn, client)
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("erro no handshake TLS: %w", err)
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro no handshake WebSocket: %w", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return &wsClientConn{Conn: ws, tls: tlsConn}, nil
+}
+
+// dialThroughProxy abre a conexão TCP, usando CONNECT se houver proxy configurado
+func dialThroughProxy(scheme, hostPort string) (net.Conn, error) {
+	// Com tudo pelo Tor, o proxy SOCKS substitui o proxy HTTP
+	if tor.Default().UsesTor(hostPort) {
+		return tor.DialOrDirect(hostPort)
+	}
+
+	target := &url.URL{Scheme: scheme, Host: hostPort}
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: target})
+	if err != nil {
+		return nil, fmt.Errorf("proxy inválido: %w", err)
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", hostPort, handshakeTimeout)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := net.DialTimeout("tcp", proxyAddr, handshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar ao proxy %s: %w", proxyAddr, err)
+	}
+
+	req := "CONNECT " + hostPort + " HTTP/1.1\r\nHost: " + hostPort + "\r\n"
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao falar com o proxy: %w", err)
+	}
+
+	// O proxy não envia nada após a resposta até recebermos dados do destino
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao ler resposta do proxy: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy recusou o túnel: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Listen implementa Transport. O endereço é "host:porta/caminho".
+func (t *WebSocketTransport) Listen(address string) (net.Listener, error) {
+	port := "80"
+	if t.secure {
+		port = "443"
+	}
+	hostPort, path := splitWebSocketAddress(address, port)
+
+	var ln net.Listener
+	var err error
+	if t.secure {
+		ln, err = tls.Listen("tcp", hostPort, t.server)
+	} else {
+		ln, err = net.Listen("tcp", hostPort)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &wsListener{
+		ln:    ln,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+
+	handler := http.NewServeMux()
+	handler.Handle(path, websocket.Server{Handler: l.serve})
+	l.server = &http.Server{Handler: handler, ReadHeaderTimeout: handshakeTimeout}
+	go l.server.Serve(ln)
+	return l, nil
+}
+
+type wsListener struct {
+	ln     net.Listener
+	server *http.Server
+	conns  chan net.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+// serve entrega a conexão ao Accept e a mantém aberta até ser fechada,
+// já que o pacote websocket a encerra quando o handler retorna
+func (l *wsListener) serve(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	conn := &wsConn{Conn: ws, remote: wsRemoteAddr(ws.Request()), closed: make(chan struct{})}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		return
+	}
+
+	select {
+	case <-conn.closed:
+	case <-l.done:
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.server.Close()
+	})
+	return nil
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// wsConn expõe o endereço real do cliente (o pacote websocket retorna a Origin)
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// tlsState usa a sessão TLS da requisição de upgrade (nil em ws:// puro)
+func (c *wsConn) tlsState() (tls.ConnectionState, bool) {
+	if r := c.Request(); r != nil && r.TLS != nil {
+		return *r.TLS, true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// wsClientConn guarda a sessão TLS de uma conexão wss:// discada
+type wsClientConn struct {
+	*websocket.Conn
+	tls *tls.Conn
+}
+
+func (c *wsClientConn) tlsState() (tls.ConnectionState, bool) {
+	if c.tls == nil {
+		return tls.ConnectionState{}, false
+	}
+	return c.tls.ConnectionState(), true
+}
+
+func (c *wsConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// wsRemoteAddr usa o endereço da conexão HTTP, que é único por cliente
+// mesmo quando todos chegam pelo mesmo proxy reverso
+func wsRemoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return wsAddr(r.RemoteAddr)
+	}
+	return addr
+}
+
+type wsAddr string
+
+func (a wsAddr) Network() string { return "ws" }
+func (a wsAddr) String() string  { return string(a) }
@@ -11,9 +11,15 @@ import (
 
 var chatView *gocui.View
 
+// Largura do painel de transferências e largura mínima do terminal para exibi-lo
+const (
+	transfersPanelWidth = 42
+	transfersPanelMinX  = 90
+)
+
 // initUI inicializa a interface do usuário baseada em terminal usando gocui
 func initUI() {
-	g, err := gocui.NewGui(gocui.OutputNormal)
+	g, err := gocui.NewGui(gocui.Output256)
 	if err != nil {
 		log.Fatalf("Falha ao iniciar interface: %v", err)
 	}
@@ -33,9 +39,16 @@ func initUI() {
 		log.Fatalf("Erro ao configurar tecla: %v", err)
 	}
 
+	if err := popupKeybindings(g); err != nil {
+		log.Fatalf("Erro ao configurar tecla: %v", err)
+	}
+
 	// Foca na área de input
 	g.SetCurrentView("input")
 
+	// Atualiza periodicamente o painel de progresso das transferências
+	go refreshTransfersPanel()
+
 	// Exibe mensagem de boas-vindas
 	updateChatView(fmt.Sprintf("--- Bem-vindo ao Magician Chat, %s! ---", Nickname))
 	updateChatView("Use /ajuda para ver os comandos disponíveis.")
@@ -49,8 +62,23 @@ func initUI() {
 func layout(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 
+	// Em terminais largos, reserva a lateral direita para o painel de transferências
+	chatMaxX := maxX - 1
+	if maxX >= transfersPanelMinX {
+		chatMaxX = maxX - transfersPanelWidth - 1
+		if v, err := g.SetView("transfers", chatMaxX+1, 0, maxX-1, maxY-4); err != nil {
+			if err != gocui.ErrUnknownView {
+				return err
+			}
+			v.Title = "📦 Transferências"
+			v.Wrap = false
+		}
+	} else {
+		g.DeleteView("transfers")
+	}
+
 	// View do chat (ocupa maior parte da tela)
-	if v, err := g.SetView("chat", 0, 0, maxX-1, maxY-4); err != nil {
+	if v, err := g.SetView("chat", 0, 0, chatMaxX, maxY-4); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -74,7 +102,8 @@ func layout(g *gocui.Gui) error {
 		}
 	}
 
-	return nil
+	// Popup do /abrir, sobre as demais views
+	return layoutPopup(g, maxX, maxY)
 }
 
 // updateChatView atualiza a view do chat com uma nova mensagem
@@ -90,6 +119,100 @@ func updateChatView(message string) {
 	})
 }
 
+// refreshTransfersPanel redesenha o painel de transferências a cada segundo
+func refreshTransfersPanel() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if G == nil {
+			continue
+		}
+		G.Update(func(g *gocui.Gui) error {
+			v, err := g.View("transfers")
+			if err != nil {
+				return nil
+			}
+			v.Clear()
+			fmt.Fprint(v, renderTransfersPanel(transfersPanelWidth-2))
+			return nil
+		})
+	}
+}
+
+// renderTransfersPanel gera o conteúdo do painel com uma barra de progresso por transferência
+func renderTransfersPanel(width int) string {
+	list := snapshotTransfers(false)
+	if len(list) == 0 {
+		return "Nenhuma transferência."
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	for i := len(list) - 1; i >= 0; i-- {
+		t := list[i]
+		arrow := "⬆"
+		if t.Incoming {
+			arrow = "⬇"
+		}
+
+		name := []rune(t.FileName)
+		if len(name) > width-12 {
+			name = append(name[:width-15], []rune("...")...)
+		}
+		fmt.Fprintf(&sb, "%s %s %s\n", arrow, t.ID[:6], string(name))
+
+		status := t.State.String()
+		if t.Paused {
+			status = "pausada"
+		}
+		if t.IsActive() && !t.Paused {
+			fmt.Fprintf(&sb, "%s %3.0f%%\n", progressBar(t.Progress(), width-6), t.Progress()*100)
+			fmt.Fprintf(&sb, "  %s/s  ETA %s\n", formatBytes(int64(t.Speed(now))), formatETA(t.ETA(now)))
+		} else {
+			fmt.Fprintf(&sb, "  %s (%s)\n", status, formatBytes(t.BytesDone))
+		}
+	}
+	return sb.String()
+}
+
+// progressBar desenha uma barra de progresso em texto
+func progressBar(fraction float64, width int) string {
+	if width < 3 {
+		width = 3
+	}
+	inner := width - 2
+	filled := int(fraction * float64(inner))
+	if filled > inner {
+		filled = inner
+	} else if filled < 0 {
+		filled = 0
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", inner-filled) + "]"
+}
+
+// formatBytes formata um tamanho em bytes de forma legível
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA formata o tempo restante de uma transferência
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "--"
+	}
+	return d.Round(time.Second).String()
+}
+
 // processMessage processa uma mensagem para verificar se é um comando
 func processMessage(message string) (bool, string) {
 	if !strings.HasPrefix(message, "/") {
@@ -109,8 +232,27 @@ func processMessage(message string) (bool, string) {
 /privado <peer> <msg> - Envia mensagem privada
 /limpar             - Limpa a tela
 /logs [n]           - Mostra últimas n mensagens do log
-/arquivo <path> [peer] - Envia arquivo
+/arquivo <path> [peer] - Envia arquivo, diretório ou padrão (*.txt)
+/transferencias     - Lista as transferências ativas
+/cancelar <id>      - Cancela uma transferência
+/pausar <id>        - Pausa uma transferência
+/retomar <id>       - Retoma uma transferência pausada
+/banda [up|down|id] [KB/s] - Mostra ou limita a banda de arquivos
+/compartilhar <arquivo> - Compartilha um arquivo por hash
+/compartilhados     - Lista arquivos compartilhados e downloads
+/baixar <hash>      - Baixa um arquivo de todos os peers que o possuem
+/sync [pasta|parar|confiar <peer>] - Sincroniza uma pasta com peers confiáveis
+/abrir <id>         - Lê um arquivo de texto recebido
+/conectar <endereço|n|nome> - Conecta a um peer (IP:porta, quic://, wss://, relay://, nº de /descobertos, contato)
+/contatos           - Lista a agenda de contatos
+/adicionar <nome> <endereço...> [id=<fingerprint>] [favorito] - Salva um contato
+/remover <nome>     - Remove um contato
+/descobertos [politica auto|perguntar|nunca] - Lista peers da rede local
+/relay [host:porta] - Registra-se em um relay ou mostra seu ID
+/direto <ID> [peer] - Conexão direta por hole punching (UDP/QUIC)
 /info               - Mostra as informações da Rede Tor
+/tor [tudo|isolar sim|nao] [socks host:porta] [timeout 60s] - Mostra ou altera as conexões via Tor
+/tor setup [torrc]  - Gera o trecho de torrc e confere o arquivo
 /sair               - Fecha o programa
 `
 	case "/usuarios", "/users":
@@ -123,8 +265,44 @@ func processMessage(message string) (bool, string) {
 		return true, cmdShowLogs(args)
 	case "/arquivo", "/file":
 		return true, cmdSendFile(args)
+	case "/transferencias", "/transfers":
+		return true, cmdListTransfers(args)
+	case "/cancelar", "/cancel":
+		return true, cmdControlTransfer(args, controlCancel)
+	case "/pausar", "/pause":
+		return true, cmdControlTransfer(args, controlPause)
+	case "/retomar", "/resume":
+		return true, cmdControlTransfer(args, controlResume)
+	case "/banda", "/bandwidth":
+		return true, cmdBandwidth(args)
+	case "/compartilhar", "/share":
+		return true, cmdShare(args)
+	case "/compartilhados", "/shared":
+		return true, cmdListShared(args)
+	case "/baixar", "/download":
+		return true, cmdDownload(args)
+	case "/sync", "/sincronizar":
+		return true, cmdSync(args)
+	case "/conectar", "/connect":
+		return true, cmdConnect(args)
+	case "/contatos", "/contacts":
+		return true, cmdContacts(args)
+	case "/adicionar", "/add":
+		return true, cmdAddContact(args)
+	case "/remover", "/remove":
+		return true, cmdRemoveContact(args)
+	case "/descobertos", "/discovered":
+		return true, cmdDiscovered(args)
+	case "/relay":
+		return true, cmdRelay(args)
+	case "/direto", "/direct":
+		return true, cmdDirect(args)
+	case "/abrir", "/open":
+		return true, cmdOpen(args)
 	case "/info":
 		return true, cmdInfo(args)
+	case "/tor":
+		return true, cmdTor(args)
 	case "/sair", "/exit":
 		return true, "Saindo..."
 	default:
@@ -144,7 +322,7 @@ func cmdShowLogs(args []string) string {
 // cmdSendFile inicia a transferência de um arquivo
 func cmdSendFile(args []string) string {
 	if len(args) < 1 {
-		return "Uso: /arquivo <caminho_do_arquivo> [peer_destino]"
+		return "Uso: /arquivo <arquivo|diretório|padrão> [peer_destino]"
 	}
 
 	filePath := args[0]
@@ -153,12 +331,26 @@ func cmdSendFile(args []string) string {
 		targetPeer = args[1]
 	}
 
+	paths, archive, archiveName, err := expandSendPaths(filePath)
+	if err != nil {
+		return fmt.Sprintf("❌ Erro ao enviar arquivo: %v", err)
+	}
+
 	go func() {
-		if err := sendFile(filePath, targetPeer); err != nil {
+		var err error
+		if archive {
+			err = sendArchive(paths, archiveName, targetPeer)
+		} else {
+			err = sendFile(paths[0], targetPeer)
+		}
+		if err != nil {
 			updateChatView(fmt.Sprintf("❌ Erro ao enviar arquivo: %v", err))
 		}
 	}()
 
+	if archive {
+		return fmt.Sprintf("Empacotando e enviando: %s", filePath)
+	}
 	return fmt.Sprintf("Iniciando envio do arquivo: %s", filePath)
 }
 